@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// adminToken guards the /admin endpoints. When unset they are disabled.
+var adminToken = envString("ADMIN_TOKEN", "")
+
+// requireAdmin wraps an admin handler, accepting the token as a bearer
+// Authorization header or a ?token= query parameter.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeJSONError(w, http.StatusForbidden, CodeForbidden, "admin endpoints disabled")
+			return
+		}
+		if !tokenMatches(requestToken(r), adminToken) {
+			writeJSONError(w, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Reset-all modes: "reset" zeroes every match, "remove" also closes every
+// room; the default match, which can't close, is reset.
+const (
+	ResetAllReset  = "reset"
+	ResetAllRemove = "remove"
+)
+
+// resetSummary reports how many matches an admin reset touched, how many
+// of them were rooms it removed, and the IDs of any that refused it, such
+// as read-only ones.
+type resetSummary struct {
+	Mode    string   `json:"mode"`
+	Matches int      `json:"matches"`
+	Removed int      `json:"removed,omitempty"`
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// serveResetAll zeroes the default match and every open room through the
+// normal reset action, so connected overlays clear. With ?mode=remove the
+// rooms are removed instead (see roomRegistry.remove) and their clients
+// closed. With ?tenant= only that tenant's rooms are reset.
+func serveResetAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = ResetAllReset
+	}
+	if mode != ResetAllReset && mode != ResetAllRemove {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("mode must be %q or %q, got %q", ResetAllReset, ResetAllRemove, mode))
+		return
+	}
+	matches := append([]*GameState{&gameState}, rooms.all()...)
+	if tenant := requestTenant(r); tenant != "" {
+		matches = slices.DeleteFunc(matches, func(gs *GameState) bool { return !gs.inTenant(tenant) })
+	}
+	summary := resetSummary{Mode: mode}
+	removed := make(map[*GameState]bool)
+	for _, gs := range matches {
+		var err error
+		if mode == ResetAllRemove && gs != &gameState {
+			if err = rooms.remove(gs); err == nil {
+				removed[gs] = true
+			}
+		} else {
+			_, err = applyActions(gs, nil, Message{Action: "reset", Actor: "admin", Trusted: true})
+		}
+		if err != nil {
+			log.Printf("reset-all: match %s: %v", gs.matchID(), err)
+			summary.Skipped = append(summary.Skipped, gs.matchID())
+			continue
+		}
+		summary.Matches++
+	}
+	if summary.Removed = len(removed); summary.Removed > 0 {
+		hub.closeWhere(func(c *Client) bool { return removed[c.game.Load()] }, clientCloseTimeout, clientCloseTimeout, "match removed")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// clientInfo describes one connection for support troubleshooting.
+type clientInfo struct {
+	ID          uint64     `json:"id"`
+	Role        string     `json:"role"`
+	Name        string     `json:"name,omitempty"`
+	RemoteIP    string     `json:"remoteIp"`
+	ConnectedAt time.Time  `json:"connectedAt"`
+	LastActive  *time.Time `json:"lastActive,omitempty"`
+	Queued      bool       `json:"queued,omitempty"`
+	Muted       bool       `json:"muted,omitempty"`
+	// Tags are the connection's analytics tags.
+	Tags map[string]string `json:"tags,omitempty"`
+	// View is the broadcast view the client selected, if any.
+	View string `json:"view,omitempty"`
+}
+
+// info snapshots the client's metadata. The caller must hold hub.mutex.
+func (c *Client) info(queued bool) clientInfo {
+	info := clientInfo{
+		ID:          c.id,
+		Role:        c.role,
+		Name:        c.name,
+		RemoteIP:    c.ip,
+		ConnectedAt: c.connectedAt,
+		Queued:      queued,
+		Muted:       c.muted,
+		Tags:        c.tags,
+	}
+	if c.view != nil {
+		info.View = c.view.name
+	}
+	if ns := c.lastActive.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		info.LastActive = &t
+	}
+	return info
+}
+
+// serveMatchClients lists the connections on a match, live clients first in
+// connection order, then the waiting queue.
+func serveMatchClients(w http.ResponseWriter, r *http.Request) {
+	id := scopedMatchID(r, r.PathValue("id"))
+	if _, ok := lookupMatch(id); !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	hub.mutex.Lock()
+	clients := make([]clientInfo, 0, len(hub.clients)+len(hub.queue))
+	for c := range hub.clients {
+		if c.game.Load().matchID() == id {
+			clients = append(clients, c.info(false))
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	if id == defaultMatchID {
+		for _, c := range hub.queue {
+			clients = append(clients, c.info(true))
+		}
+	}
+	hub.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+// rebroadcastSummary reports how many clients a rebroadcast reached.
+type rebroadcastSummary struct {
+	Clients int `json:"clients"`
+}
+
+// serveRebroadcast re-sends the current state to every client without
+// changing it, to resync clients that drifted. A frozen match is refused, as
+// its live state holds edits viewers shouldn't see yet.
+func serveRebroadcast(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(scopedMatchID(r, r.PathValue("id")))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	gs.mu.Lock()
+	if gs.Frozen {
+		gs.mu.Unlock()
+		writeJSONError(w, http.StatusConflict, CodeConflict, "match is frozen")
+		return
+	}
+	state := gs.clone()
+	payload := state.broadcastPayload()
+	gs.mu.Unlock()
+	if payload == nil {
+		writeJSONError(w, http.StatusInternalServerError, CodeInternal, "state is too large to broadcast")
+		return
+	}
+
+	hub.broadcastTo(gs, payload, state, time.Time{})
+	hub.mutex.Lock()
+	clients := hub.clientsOn(gs)
+	hub.mutex.Unlock()
+	log.Printf("Rebroadcast state to %d clients", clients)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rebroadcastSummary{Clients: clients})
+}
+
+// readOnlyRequest is the body of a read-only toggle.
+type readOnlyRequest struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+// serveReadOnly turns a match into a read-only record, or back into a live
+// game, through the admin-only "read_only" action so clients see the flag.
+func serveReadOnly(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(scopedMatchID(r, r.PathValue("id")))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	var req readOnlyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	if _, err := applyActions(gs, nil, Message{Action: "read_only", ReadOnly: req.ReadOnly, Actor: "admin", Trusted: true}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
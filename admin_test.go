@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"livescore/engine"
+)
+
+func TestReadOnlyMatchRefusesActions(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A"})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+
+	path := "/admin/matches/" + defaultMatchID + "/read-only"
+	if resp, body := admin(t, srv, http.MethodPost, path, `{"readOnly":true}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("read-only: %d %s", resp.StatusCode, body)
+	}
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 2 }); !s.ReadOnly {
+		t.Error("broadcast after the toggle doesn't carry readOnly")
+	}
+	for _, msg := range []Message{{Action: "increment", Team: "A"}, {Action: "reset"}, {Action: "read_only"}} {
+		send(t, conn, msg)
+		if text := readError(t, conn); text != engine.ErrMatchReadOnly.Error() && text != engine.ErrAdminOnly.Error() {
+			t.Errorf("%s on a read-only match: %q", msg.Action, text)
+		}
+	}
+	// Reads still work.
+	if resp, body := get(t, srv, "/score", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /score on a read-only match: %d %s", resp.StatusCode, body)
+	}
+	if got := scoreOf(&gameState, "A"); got != 1 {
+		t.Errorf("A = %v, want the score from before read-only", got)
+	}
+
+	if resp, body := admin(t, srv, http.MethodPost, path, `{"readOnly":false}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("live again: %d %s", resp.StatusCode, body)
+	}
+	send(t, conn, Message{Action: "increment", Team: "A"})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 4 }); s.ReadOnly || score(t, s, "A") != 2 {
+		t.Errorf("live again: readOnly %t with A=%v, want scoring back on", s.ReadOnly, score(t, s, "A"))
+	}
+}
+
+func TestClientTagsAppearInAdminListing(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	tagged := dial(t, srv, "/ws?src=overlay&region=us&campaign=spring")
+	readState(t, tagged)
+	plain := dial(t, srv, "/ws")
+	readState(t, plain)
+
+	resp, body := admin(t, srv, http.MethodGet, "/admin/matches/"+defaultMatchID+"/clients", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("listing clients: %d %s", resp.StatusCode, body)
+	}
+	var clients []clientInfo
+	if err := json.Unmarshal(body, &clients); err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("listed %d clients, want 2: %s", len(clients), body)
+	}
+	// Only the keys in CLIENT_TAGS are taken.
+	if want := map[string]string{"src": "overlay", "region": "us"}; !maps.Equal(clients[0].Tags, want) {
+		t.Errorf("tagged client lists tags %v, want %v", clients[0].Tags, want)
+	}
+	if clients[1].Tags != nil {
+		t.Errorf("untagged client lists tags %v", clients[1].Tags)
+	}
+}
+
+func TestResetAllRemovesRooms(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	onDefault := dialControl(t, srv, "")
+	readState(t, onDefault)
+	send(t, onDefault, Message{Action: "increment", Team: "A"})
+	readStateWith(t, onDefault, func(s StateJSON) bool { return s.Version == 1 })
+	var inRooms []*websocket.Conn
+	for _, id := range []string{"cup", "final"} {
+		conn := dialControl(t, srv, "match="+id)
+		readState(t, conn)
+		send(t, conn, Message{Action: "increment", Team: "B", Value: 2})
+		readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+		inRooms = append(inRooms, conn)
+	}
+
+	if resp, body := admin(t, srv, http.MethodPost, "/admin/reset-all?mode=wipe", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown mode: %d %s, want 400", resp.StatusCode, body)
+	}
+	resp, body := admin(t, srv, http.MethodPost, "/admin/reset-all?mode=remove", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reset-all: %d %s", resp.StatusCode, body)
+	}
+	var summary resetSummary
+	json.Unmarshal(body, &summary)
+	if summary.Mode != ResetAllRemove || summary.Matches != 3 || summary.Removed != 2 || summary.Skipped != nil {
+		t.Errorf("summary %+v, want 3 matches of which 2 rooms removed", summary)
+	}
+	if n := rooms.count(); n != 0 {
+		t.Errorf("%d rooms left open", n)
+	}
+
+	// The rooms' clients are closed; the default match, which stays, is
+	// reset.
+	for _, conn := range inRooms {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+					t.Errorf("room client ended with %v, want a going-away close", err)
+				}
+				break
+			}
+		}
+	}
+	if s := readStateWith(t, onDefault, func(s StateJSON) bool { return s.Version == 2 }); score(t, s, "A") != 0 {
+		t.Errorf("default match A = %v after reset-all, want 0", score(t, s, "A"))
+	}
+	// A room opened again under a removed ID starts fresh.
+	again := dialControl(t, srv, "match=cup")
+	if s := readState(t, again); score(t, s, "B") != 0 {
+		t.Errorf("reopened cup has B = %v, want a fresh board", score(t, s, "B"))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveDir is where finished matches are written for record keeping. When
+// it is unset nothing is archived.
+var archiveDir = envString("ARCHIVE_DIR", "")
+
+// eventRetention bounds the in-memory event log; 0 keeps every event.
+// Without archiving the oldest events are dropped past it. With archiving
+// none are lost: once the log passes eventRetention its older half is
+// written to a segment of the match's archive, which lists its segments.
+var eventRetention = envInt("EVENT_RETENTION", 1000)
+
+// segmentDir is the subdirectory of archiveDir holding the events trimmed
+// from matches still in play.
+const segmentDir = "segments"
+
+// Errors for archive lookups.
+var (
+	errArchivingDisabled = errors.New("archiving disabled")
+	errUnknownArchive    = errors.New("unknown archive")
+)
+
+// archivedEvent is one applied action in a match's event log.
+type archivedEvent struct {
+	Version uint64    `json:"version"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor,omitempty"`
+	Message Message   `json:"message"`
+}
+
+// matchArchive is the file written when a match finishes.
+type matchArchive struct {
+	Match      string          `json:"match"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	State      json.RawMessage `json:"state"`
+	// Segments name the files in segmentDir holding the match's earlier
+	// events, oldest first; Events follow on from them.
+	Segments []string        `json:"segments,omitempty"`
+	Events   []archivedEvent `json:"events"`
+}
+
+// eventSegment is a run of events trimmed from a match still in play.
+type eventSegment struct {
+	Match  string          `json:"match"`
+	Events []archivedEvent `json:"events"`
+}
+
+// recordEvent appends msg, just applied, to the match's event log. When the
+// action finished the match, the log is archived and trimmed from memory.
+// The caller must hold gs.mu.
+func (gs *GameState) recordEvent(msg Message, wasFinished bool) {
+	if a, ok := gs.logEvent(msg, wasFinished); ok {
+		// Writing happens off the game lock; a failure is logged and never
+		// holds up finishing the match.
+		go writeArchive(a)
+	}
+}
+
+// logEvent is recordEvent without writing the archive, which it returns
+// when the action finished the match. A replay uses it directly, as the
+// archive was written when the action was first applied; for the same
+// reason a replayed action trims the log without writing the segment.
+func (gs *GameState) logEvent(msg Message, wasFinished bool) (matchArchive, bool) {
+	gs.events = append(gs.events, archivedEvent{Version: gs.Version, At: msg.Time(), Actor: msg.Actor, Message: msg})
+	gs.trimEvents(!msg.Replayed)
+	if archiveDir == "" || !gs.Finished || wasFinished {
+		return matchArchive{}, false
+	}
+	state, _ := json.Marshal(gs)
+	a := matchArchive{Match: gs.matchID(), FinishedAt: msg.Time(), State: state, Segments: gs.segments, Events: gs.events}
+	gs.events, gs.segments = nil, nil
+	return a, true
+}
+
+// trimEvents holds the event log to eventRetention; see there. The trimmed
+// segment is written unless write is false, when it already was. The
+// caller must hold gs.mu.
+func (gs *GameState) trimEvents(write bool) {
+	if eventRetention <= 0 || len(gs.events) <= eventRetention {
+		return
+	}
+	if archiveDir == "" {
+		gs.events = append(gs.events[:0], gs.events[len(gs.events)-eventRetention:]...)
+		return
+	}
+	cut := len(gs.events) - eventRetention/2
+	seg := eventSegment{Match: gs.matchID(), Events: append([]archivedEvent(nil), gs.events[:cut]...)}
+	gs.events = append(gs.events[:0], gs.events[cut:]...)
+	// Named by its first event, a segment is the same file when a replay
+	// trims it again.
+	first := seg.Events[0]
+	name := fmt.Sprintf("%s-%s-v%d.json", seg.Match, first.At.UTC().Format("20060102T150405Z"), first.Version)
+	gs.segments = append(gs.segments, name)
+	if write {
+		go writeSegment(name, seg)
+	}
+}
+
+// writeArchive saves a as {match}-{timestamp}.json in archiveDir.
+func writeArchive(a matchArchive) {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(archiveDir, 0o755)
+	}
+	name := a.Match + "-" + a.FinishedAt.UTC().Format("20060102T150405Z") + ".json"
+	if err == nil {
+		err = os.WriteFile(filepath.Join(archiveDir, name), data, 0o644)
+	}
+	if err != nil {
+		log.Printf("archiving match %s: %v", a.Match, err)
+		return
+	}
+	log.Printf("Archived match %s with %d events to %s", a.Match, len(a.Events), name)
+}
+
+// writeSegment saves seg as name in segmentDir.
+func writeSegment(name string, seg eventSegment) {
+	dir := filepath.Join(archiveDir, segmentDir)
+	data, err := json.Marshal(seg)
+	if err == nil {
+		err = os.MkdirAll(dir, 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, name), data, 0o644)
+	}
+	if err != nil {
+		log.Printf("archiving events of match %s: %v", seg.Match, err)
+	}
+}
+
+// serveArchive serves an archived match. The ID is either an archive name
+// ({match}-{timestamp}) or a match ID, which serves its latest archive.
+// Events trimmed in play stay in the segments the archive names.
+func serveArchive(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if archiveDir == "" {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, errArchivingDisabled.Error())
+		return
+	}
+	path, ok := archivePath(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, errUnknownArchive.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, path)
+}
+
+// archivePath resolves an archive ID, either a file name without ".json"
+// or a match ID for its newest archive. The caller checks archiveDir.
+func archivePath(id string) (string, bool) {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return "", false
+	}
+	path := filepath.Join(archiveDir, id+".json")
+	if _, err := os.Stat(path); err != nil {
+		matches, _ := filepath.Glob(filepath.Join(archiveDir, id+"-*.json"))
+		if len(matches) == 0 {
+			return "", false
+		}
+		// Timestamps sort lexically, so the last name is the newest.
+		sort.Strings(matches)
+		path = matches[len(matches)-1]
+	}
+	return path, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveKeepsEventsTrimmedInPlay(t *testing.T) {
+	setVar(t, &archiveDir, t.TempDir())
+	setVar(t, &eventRetention, 4)
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	for range 10 {
+		send(t, conn, Message{Action: "increment", Team: "A"})
+	}
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 10 })
+	gameState.mu.Lock()
+	held := len(gameState.events)
+	gameState.mu.Unlock()
+	if held > 4 {
+		t.Errorf("%d events held in memory, want at most EVENT_RETENTION=4", held)
+	}
+
+	send(t, conn, Message{Action: "finish"})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Finished })
+	var events []archivedEvent
+	waitFor(t, "the archive to be written", func() bool {
+		var err error
+		events, err = loadArchivedEvents(defaultMatchID)
+		return err == nil
+	})
+	if len(events) != 11 {
+		t.Fatalf("archive holds %d events, want all 11", len(events))
+	}
+	for i, ev := range events {
+		if ev.Version != uint64(i+1) {
+			t.Fatalf("event %d has version %d, want the log in order from 1", i, ev.Version)
+		}
+	}
+	if segs, _ := os.ReadDir(filepath.Join(archiveDir, segmentDir)); len(segs) == 0 {
+		t.Error("no segments written for the trimmed events")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols for the state encoding. JSON is the default for
+// clients that ask for neither; clients that can't parse JSON cheaply, such
+// as microcontroller scoreboards, offer subprotocolBinary and receive each
+// state as a binary frame in the layout below. Every other message (errors,
+// notices, the roster, ...) stays a JSON text frame, and a server with a
+// BroadcastTransform sends JSON to everyone, since transforms produce
+// arbitrary values.
+const (
+	subprotocolJSON   = "livescore.json"
+	subprotocolBinary = "livescore.binary.v1"
+)
+
+// binaryLayout is the first byte of a binary state frame. It changes only
+// with the subprotocol.
+const binaryLayout = 1
+
+// Flags in byte 1 of a binary state frame.
+const (
+	binaryFinished     = 1 << 0
+	binaryClockRunning = 1 << 1
+	binaryNotStarted   = 1 << 2
+	binaryReadOnly     = 1 << 3
+)
+
+// binaryNoWinner marks a match without a winner in the winner index.
+const binaryNoWinner = 0xFF
+
+// binaryMaxTeams is the most teams the layout's count byte holds. States
+// with more go to binary clients as JSON text frames; see fitsBinary.
+const binaryMaxTeams = 255
+
+// marshalBinary encodes the state, limited to the teams in filter when it is
+// non-nil, in the fixed binary layout. Integers are big-endian; strings are
+// a length byte followed by UTF-8.
+//
+//	offset size field
+//	0      1    layout, always 1
+//	1      1    flags: 1 finished, 2 clock running, 4 not started,
+//	            8 read-only
+//	2      1    precision: scores are sent multiplied by 10^precision
+//	3      1    team count
+//	4      8    state version
+//	12     2    period
+//	14     4    period clock, in milliseconds
+//	18     1    winner's index among the teams sent, 255 for none
+//	19          teams, each:
+//	       4      score, scaled by precision; saturates at 2^32-1
+//	       2      fouls
+//	       1      timeouts left
+//	       1+n    name
+//	       1+n    color, empty when unset
+//
+// On a two-team state with a few fouls, BenchmarkStateEncoding measures 39
+// bytes against 103 as JSON, encoded in about a twentieth of the time with
+// one allocation against seven. The series, preset, schedule and score
+// level fields are JSON-only.
+func (gs *GameState) marshalBinary(filter map[string]bool) []byte {
+	teams := gs.FilterTeams(filter)
+
+	var flags byte
+	if gs.Finished {
+		flags |= binaryFinished
+	}
+	if gs.Clock.Running() {
+		flags |= binaryClockRunning
+	}
+	if gs.NotStarted {
+		flags |= binaryNotStarted
+	}
+	if gs.ReadOnly {
+		flags |= binaryReadOnly
+	}
+	winner := byte(binaryNoWinner)
+	for i, t := range teams {
+		if gs.Winner != "" && t.Name == gs.Winner {
+			winner = byte(i)
+		}
+	}
+	elapsed := gs.Clock.At(time.Now(), gs.Options.PeriodLength).Milliseconds()
+
+	b := make([]byte, 0, 19+len(teams)*24)
+	b = append(b, binaryLayout, flags, byte(gs.Options.Precision), byte(len(teams)))
+	b = binary.BigEndian.AppendUint64(b, gs.Version)
+	b = binary.BigEndian.AppendUint16(b, uint16(gs.Period))
+	b = binary.BigEndian.AppendUint32(b, uint32(min(elapsed, math.MaxUint32)))
+	b = append(b, winner)
+	scale := math.Pow10(gs.Options.Precision)
+	for _, t := range teams {
+		b = binary.BigEndian.AppendUint32(b, uint32(min(math.Round(t.Score*scale), math.MaxUint32)))
+		b = binary.BigEndian.AppendUint16(b, uint16(t.Fouls))
+		b = append(b, byte(t.TimeoutsLeft))
+		b = appendBinaryString(b, t.Name)
+		b = appendBinaryString(b, t.Color)
+	}
+	return b
+}
+
+// fitsBinary reports whether the state, limited to the teams in filter when
+// it is non-nil, can be sent in the binary layout.
+func (gs *GameState) fitsBinary(filter map[string]bool) bool {
+	if filter == nil {
+		return len(gs.Teams) <= binaryMaxTeams
+	}
+	return len(gs.FilterTeams(filter)) <= binaryMaxTeams
+}
+
+// appendBinaryString appends s with a length byte, cutting it at 255 bytes.
+func appendBinaryString(b []byte, s string) []byte {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	b = append(b, byte(len(s)))
+	return append(b, s...)
+}
+
+// frameType is the frame type client c receives states in.
+func (c *Client) frameType() int {
+	if c.binary && broadcastTransform == nil && (c.view == nil || c.view.Transform == nil) {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// encodeFor renders the state as client c should receive it with the given
+// team filter and locale, returning the frame type and payload. The caller
+// must hold gs.mu or own gs.
+func (gs *GameState) encodeFor(c *Client, filter map[string]bool, locale string) (int, []byte) {
+	if c.view != nil && c.view.Transform != nil {
+		return websocket.TextMessage, c.view.transform(gs, filter)
+	}
+	if c.frameType() == websocket.BinaryMessage && gs.fitsBinary(filter) {
+		return websocket.BinaryMessage, gs.marshalBinary(filter)
+	}
+	return websocket.TextMessage, gs.view(c.role, filter, locale)
+}
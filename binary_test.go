@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"livescore/engine"
+)
+
+func TestBinaryFallsBackToJSONPastTeamCount(t *testing.T) {
+	srv := newTestServer(t)
+	names := make([]string, binaryMaxTeams+45)
+	for i := range names {
+		names[i] = fmt.Sprintf("T%d", i)
+	}
+	gameState.mu.Lock()
+	gameState.Teams = engine.TeamsNamed(names)
+	gameState.mu.Unlock()
+
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocolBinary}}
+	conn, _, err := dialer.Dial(wsURL(srv, "/ws"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	applyAndBroadcast(Message{Action: "increment", Team: "T300", Trusted: true})
+	for _, what := range []string{"initial state", "broadcast"} {
+		for {
+			kind, payload, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("reading the %s: %v", what, err)
+			}
+			if kind == websocket.BinaryMessage {
+				t.Fatalf("%s of %d teams sent in the binary layout", what, len(names))
+			}
+			var s StateJSON
+			if json.Unmarshal(payload, &s) == nil && s.Teams != nil {
+				if len(s.Teams) != len(names) {
+					t.Errorf("%s has %d teams, want %d", what, len(s.Teams), len(names))
+				}
+				break
+			}
+		}
+	}
+
+	// A binary client whose filter leaves few enough teams keeps the
+	// binary layout.
+	c := &Client{binary: true}
+	gameState.mu.Lock()
+	defer gameState.mu.Unlock()
+	if kind, payload := gameState.encodeFor(c, map[string]bool{"T1": true, "T2": true}, ""); kind != websocket.BinaryMessage || payload[3] != 2 {
+		t.Errorf("filtered to two teams: frame type %d, team count %d; want binary with 2", kind, payload[3])
+	}
+}
+
+// BenchmarkStateEncoding encodes a two-team state with a few fouls as JSON,
+// as broadcast, and in the binary layout, reporting each frame's size.
+func BenchmarkStateEncoding(b *testing.B) {
+	gs := &GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 2, Version: 42, Options: baseOptions}}
+	gs.ResetTimeouts()
+	gs.Team("A").Score, gs.Team("A").Fouls = 17, 3
+	gs.Team("B").Score, gs.Team("B").Fouls = 12, 4
+
+	encodings := []struct {
+		name   string
+		encode func() []byte
+	}{
+		{"json", func() []byte { payload, _ := json.Marshal(gs); return payload }},
+		{"binary", func() []byte { return gs.marshalBinary(nil) }},
+	}
+	for _, e := range encodings {
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var payload []byte
+			for range b.N {
+				payload = e.encode()
+			}
+			b.ReportMetric(float64(len(payload)), "bytes")
+		})
+	}
+}
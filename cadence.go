@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scoreIntervalBounds are the upper bounds, in seconds, of the buckets of
+// the time between scoring events. Very short intervals point at spam or a
+// stuck button, very long ones at a board nobody is keeping.
+var scoreIntervalBounds = []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// scoringActions are the actions counted as scoring events.
+var scoringActions = map[string]bool{"increment": true, "decrement": true, "set": true}
+
+// intervalHistogram is a cumulative Prometheus histogram of scoring
+// intervals, one series per match, labelled with the match's preset too. A
+// room's series goes when the room closes, so rooms bound the series.
+type intervalHistogram struct {
+	mu      sync.Mutex
+	byMatch map[intervalSeries]*intervalCounts
+}
+
+// intervalSeries identifies a series of the histogram.
+type intervalSeries struct {
+	match, preset string
+}
+
+type intervalCounts struct {
+	buckets []uint64 // per bound, not cumulative; the last is +Inf
+	sum     float64
+	count   uint64
+}
+
+var scoreIntervals = &intervalHistogram{byMatch: make(map[intervalSeries]*intervalCounts)}
+
+// observe records an interval for match, using preset.
+func (h *intervalHistogram) observe(match, preset string, d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(scoreIntervalBounds, s)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := intervalSeries{match, preset}
+	c := h.byMatch[key]
+	if c == nil {
+		c = &intervalCounts{buckets: make([]uint64, len(scoreIntervalBounds)+1)}
+		h.byMatch[key] = c
+	}
+	c.buckets[i]++
+	c.sum += s
+	c.count++
+}
+
+// forget drops the series of a closed match.
+func (h *intervalHistogram) forget(match string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.byMatch {
+		if key.match == match {
+			delete(h.byMatch, key)
+		}
+	}
+}
+
+// write prints the histogram in the Prometheus text format.
+func (h *intervalHistogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP livescore_score_interval_seconds Time between consecutive scoring events of a match.\n")
+	fmt.Fprintf(w, "# TYPE livescore_score_interval_seconds histogram\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]intervalSeries, 0, len(h.byMatch))
+	for key := range h.byMatch {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].match != keys[j].match {
+			return keys[i].match < keys[j].match
+		}
+		return keys[i].preset < keys[j].preset
+	})
+	for _, key := range keys {
+		c := h.byMatch[key]
+		labels := fmt.Sprintf("match=%q,preset=%q", key.match, key.preset)
+		var cumulative uint64
+		for i, bound := range scoreIntervalBounds {
+			cumulative += c.buckets[i]
+			fmt.Fprintf(w, "livescore_score_interval_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "livescore_score_interval_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, c.count)
+		fmt.Fprintf(w, "livescore_score_interval_seconds_sum{%s} %g\n", labels, c.sum)
+		fmt.Fprintf(w, "livescore_score_interval_seconds_count{%s} %d\n", labels, c.count)
+	}
+}
+
+// observeScoreInterval records the time since the previous scoring event
+// in the event log, if msg is a scoring event and the log has one. Actions
+// replayed from the persisted log were measured when first applied, so
+// they are skipped. The caller must hold gs.mu.
+func (gs *GameState) observeScoreInterval(msg Message) {
+	if !scoringActions[msg.Action] || msg.Replayed {
+		return
+	}
+	for i := len(gs.events) - 1; i >= 0; i-- {
+		if ev := gs.events[i]; scoringActions[ev.Message.Action] {
+			preset := gs.Options.Preset
+			if preset == "" {
+				preset = "none"
+			}
+			scoreIntervals.observe(gs.matchID(), preset, msg.Time().Sub(ev.At))
+			return
+		}
+	}
+}
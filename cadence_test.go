@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"livescore/engine"
+)
+
+func TestScoreIntervalsPerMatchSkipReplay(t *testing.T) {
+	setVar(t, &scoreIntervals, &intervalHistogram{byMatch: make(map[intervalSeries]*intervalCounts)})
+	start := time.Now()
+	scoreAt := func(gs *GameState, after time.Duration, replayed bool) {
+		t.Helper()
+		msg := Message{Action: "increment", Team: "A", Trusted: true, Replayed: replayed, At: start.Add(after)}
+		if err := applyAction(gs, msg); err != nil {
+			t.Fatal(err)
+		}
+		gs.logEvent(msg, false)
+	}
+	newMatch := func(id string) *GameState {
+		return &GameState{id: id, GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 1, Options: MatchOptions{Preset: "hockey"}}}
+	}
+
+	cup, final := newMatch("cup"), newMatch("final")
+	scoreAt(cup, 0, false)
+	scoreAt(cup, 3*time.Second, false)
+	scoreAt(final, 0, false)
+	scoreAt(final, 40*time.Second, false)
+	// A restart replaying the log measures nothing again.
+	restored := newMatch("cup")
+	scoreAt(restored, 0, true)
+	scoreAt(restored, 3*time.Second, true)
+
+	var out strings.Builder
+	scoreIntervals.write(&out)
+	for _, want := range []string{
+		`livescore_score_interval_seconds_count{match="cup",preset="hockey"} 1`,
+		`livescore_score_interval_seconds_bucket{match="cup",preset="hockey",le="2"} 0`,
+		`livescore_score_interval_seconds_bucket{match="cup",preset="hockey",le="5"} 1`,
+		`livescore_score_interval_seconds_count{match="final",preset="hockey"} 1`,
+		`livescore_score_interval_seconds_sum{match="final",preset="hockey"} 40`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("/metrics lacks %s:\n%s", want, out.String())
+		}
+	}
+
+	// A closed room's series goes with it.
+	scoreIntervals.forget("final")
+	out.Reset()
+	scoreIntervals.write(&out)
+	if strings.Contains(out.String(), `match="final"`) {
+		t.Errorf("series of a closed room still exported:\n%s", out.String())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockAdjustClampsToPeriod(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.PeriodLength = 10 * time.Minute })
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	for i, step := range []struct {
+		deltaMs, want int64
+	}{
+		{12_000, 12_000},
+		{-5_000, 7_000},
+		{-60_000, 0},
+		{20 * 60_000, 10 * 60_000},
+		{-12_000, 10*60_000 - 12_000},
+	} {
+		send(t, conn, Message{Action: "clock_adjust", DeltaMs: step.deltaMs})
+		s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == uint64(i+1) })
+		if s.ElapsedMs != step.want {
+			t.Errorf("adjust by %dms: clock at %dms, want %dms", step.deltaMs, s.ElapsedMs, step.want)
+		}
+	}
+}
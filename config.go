@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envInt reads an integer setting from the environment, falling back to def
+// when the variable is unset or malformed.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean setting ("1", "true", ...) from the environment.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envString reads a string setting from the environment.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a duration setting such as "5s" from the environment.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// envTime reads an RFC 3339 timestamp from the environment; unset or
+// malformed values yield the zero time.
+func envTime(key string) time.Time {
+	v := os.Getenv(key)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Printf("invalid %s=%q, ignoring", key, v)
+		return time.Time{}
+	}
+	return t
+}
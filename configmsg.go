@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"livescore/engine"
+)
+
+// stateConfig keeps the preset's "config" object in every state broadcast,
+// as before config messages existed. Clients that read the config message
+// can turn it off to keep score broadcasts small.
+var stateConfig = envBool("STATE_CONFIG", true)
+
+// configMessage carries the slow-changing layout of a match. It is sent to
+// each client on connect and broadcast whenever the layout changes, e.g.
+// on "rename" or "configure", ahead of the state that carries the change.
+type configMessage struct {
+	Type string `json:"type"`
+	// Version is the state version the layout took effect at.
+	Version uint64 `json:"version"`
+	matchLayout
+}
+
+// matchLayout is who plays, in what colors, and the rules that decide the
+// match.
+type matchLayout struct {
+	Teams []configTeam `json:"teams"`
+	// Sport is the preset, empty for a custom match.
+	Sport             string             `json:"sport,omitempty"`
+	ScoreMax          int                `json:"scoreMax,omitempty"`
+	OnMax             string             `json:"onMax,omitempty"`
+	Periods           int                `json:"periods,omitempty"`
+	PeriodLengthSec   int                `json:"periodLengthSec,omitempty"`
+	Points            []int              `json:"points,omitempty"`
+	Timeouts          int                `json:"timeouts,omitempty"`
+	TimeoutStopsClock bool               `json:"timeoutStopsClock,omitempty"`
+	Levels            *engine.LevelRules `json:"levels,omitempty"`
+}
+
+type configTeam struct {
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+}
+
+// layout returns the match's current layout. The caller must hold gs.mu or
+// own gs.
+func (gs *GameState) layout() matchLayout {
+	o := gs.Options
+	l := matchLayout{
+		Teams:             make([]configTeam, len(gs.Teams)),
+		Sport:             o.Preset,
+		ScoreMax:          o.ScoreMax,
+		Periods:           o.Periods,
+		PeriodLengthSec:   int(o.PeriodLength / time.Second),
+		Points:            o.Points,
+		Timeouts:          o.Timeouts,
+		TimeoutStopsClock: o.TimeoutStopsClock,
+		Levels:            o.Levels,
+	}
+	if o.ScoreMax > 0 {
+		l.OnMax = o.OnMax
+	}
+	for i, t := range gs.Teams {
+		l.Teams[i] = configTeam{Name: t.Name, Color: t.Color}
+	}
+	return l
+}
+
+// configPayload encodes the match's config message. The caller must hold
+// gs.mu or own gs.
+func (gs *GameState) configPayload() []byte {
+	payload, _ := json.Marshal(configMessage{Type: "config", Version: gs.Version, matchLayout: gs.layout()})
+	return payload
+}
+
+// configChange returns the config message if the layout differs from the
+// one last broadcast, recording it as sent, or nil if it is unchanged. The
+// caller must hold gs.mu.
+func (gs *GameState) configChange() []byte {
+	layout, _ := json.Marshal(gs.layout())
+	if bytes.Equal(layout, gs.lastLayout) {
+		return nil
+	}
+	gs.lastLayout = layout
+	return gs.configPayload()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLateJoinerReceivesFullConfig(t *testing.T) {
+	srv := newTestServer(t)
+
+	console := dialControl(t, srv, "")
+	readState(t, console)
+	send(t, console, Message{Action: "configure", Preset: "basketball"})
+	send(t, console, Message{Action: "rename", Team: "A", Name: "Home"})
+	send(t, console, Message{Action: "increment", Team: "B", Value: 2})
+	readStateWith(t, console, func(s StateJSON) bool { return s.Version == 3 })
+
+	late := dial(t, srv, "/ws")
+	f := readFrame(t, late, func(f frame) bool { return f["type"] == "config" })
+	raw, _ := json.Marshal(f)
+	var got configMessage
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := configMessage{Type: "config", Version: 3, matchLayout: matchLayout{
+		Teams:             []configTeam{{Name: "Home"}, {Name: "B"}},
+		Sport:             "basketball",
+		Periods:           4,
+		PeriodLengthSec:   int(10 * time.Minute / time.Second),
+		Points:            []int{1, 2, 3},
+		Timeouts:          5,
+		TimeoutStopsClock: baseOptions.TimeoutStopsClock,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("late joiner got config %+v, want %+v", got, want)
+	}
+	// The state follows, scores and all.
+	if s := readState(t, late); s.Version != 3 || score(t, s, "B") != 2 {
+		t.Errorf("late joiner's state at version %d with B=%v, want version 3 with B=2", s.Version, score(t, s, "B"))
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"livescore/engine"
+)
+
+// serveCorrections lists a match's score corrections.
+func serveCorrections(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(matchID(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	gs.mu.Lock()
+	corrections := append([]engine.Correction{}, gs.Corrections...)
+	gs.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(corrections)
+}
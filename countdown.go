@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxCountdown bounds a countdown's length.
+const maxCountdown = 24 * time.Hour
+
+// ErrInvalidCountdown is returned for a countdown without a usable length.
+var ErrInvalidCountdown = errors.New("countdown ms must be between 1 and 86400000")
+
+// countdownMessage is broadcast once a second while a countdown runs, with
+// a final remainingMs of 0 when it expires or is cancelled.
+type countdownMessage struct {
+	Type        string `json:"type"`
+	RemainingMs int64  `json:"remainingMs"`
+	Cancelled   bool   `json:"cancelled,omitempty"`
+}
+
+// countdownTimer runs the pre-game or timeout countdown overlays show. It
+// is independent of the period clock and isn't part of the match state.
+// Starting a countdown replaces the one running.
+type countdownTimer struct {
+	mu   sync.Mutex
+	stop chan struct{} // closed to end the running countdown; nil if none
+}
+
+var countdown = &countdownTimer{}
+
+// start begins a countdown of d, replacing any running one.
+func (c *countdownTimer) start(d time.Duration) error {
+	if d <= 0 || d > maxCountdown {
+		return ErrInvalidCountdown
+	}
+	stop := make(chan struct{})
+	c.mu.Lock()
+	if c.stop != nil {
+		close(c.stop)
+	}
+	c.stop = stop
+	c.mu.Unlock()
+
+	log.Printf("Countdown of %s started", d)
+	go c.run(time.Now().Add(d), stop)
+	return nil
+}
+
+// cancel ends the running countdown, telling clients it was cancelled. It
+// is a no-op when none is running.
+func (c *countdownTimer) cancel() {
+	c.mu.Lock()
+	stop := c.stop
+	c.stop = nil
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	broadcastCountdown(countdownMessage{Type: "countdown", Cancelled: true})
+	log.Println("Countdown cancelled")
+}
+
+// run broadcasts the time left each second until deadline or until stop is
+// closed.
+func (c *countdownTimer) run(deadline time.Time, stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		remaining := max(time.Until(deadline), 0)
+		// Checked under the lock, so a replaced or cancelled countdown
+		// never sends another tick.
+		c.mu.Lock()
+		if c.stop != stop {
+			c.mu.Unlock()
+			return
+		}
+		broadcastCountdown(countdownMessage{Type: "countdown", RemainingMs: remaining.Milliseconds()})
+		if remaining == 0 {
+			c.stop = nil
+		}
+		c.mu.Unlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func broadcastCountdown(msg countdownMessage) {
+	payload, _ := json.Marshal(msg)
+	hub.broadcast(payload, nil, time.Time{})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readCountdown reads frames until the next countdown tick.
+func readCountdown(t *testing.T, conn *websocket.Conn) frame {
+	t.Helper()
+	return readFrame(t, conn, func(f frame) bool { return f["type"] == "countdown" })
+}
+
+func TestCountdownReplaceAndCancel(t *testing.T) {
+	srv := newTestServer(t)
+	t.Cleanup(countdown.cancel)
+
+	console := dialControl(t, srv, "")
+	readState(t, console)
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+
+	send(t, console, Message{Action: "countdown", Ms: 3000})
+	if ms := readCountdown(t, viewer)["remainingMs"].(float64); ms <= 2000 || ms > 3000 {
+		t.Errorf("first tick of a 3s countdown: remainingMs %v", ms)
+	}
+
+	// A new countdown replaces the running one: once its first tick is
+	// in, the old one never ticks again.
+	send(t, console, Message{Action: "countdown", Ms: 60000})
+	for {
+		if readCountdown(t, viewer)["remainingMs"].(float64) > 50000 {
+			break
+		}
+	}
+	send(t, console, Message{Action: "countdown_cancel"})
+	for {
+		f := readCountdown(t, viewer)
+		if f["cancelled"] == true {
+			if f["remainingMs"] != nil && f["remainingMs"].(float64) != 0 {
+				t.Errorf("cancelled frame: remainingMs %v, want 0", f["remainingMs"])
+			}
+			break
+		}
+		if ms := f["remainingMs"].(float64); ms <= 50000 {
+			t.Errorf("tick of the replaced countdown after the new one started: %v", ms)
+		}
+	}
+
+	send(t, console, Message{Action: "countdown", Ms: 0})
+	if text := readError(t, console); !strings.Contains(text, "countdown ms") {
+		t.Errorf("countdown of 0ms: %q", text)
+	}
+	// Neither countdown ticks after the cancel.
+	viewer.SetReadDeadline(time.Now().Add(1500 * time.Millisecond))
+	for {
+		_, payload, err := viewer.ReadMessage()
+		if err != nil {
+			break
+		}
+		if strings.Contains(string(payload), `"countdown"`) {
+			t.Fatalf("tick after cancel: %s", payload)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"slices"
+)
+
+// debugPprof mounts net/http/pprof under /debug/pprof/, behind the admin
+// token. It is off by default so profiles are never exposed by accident.
+var debugPprof = envBool("DEBUG_PPROF", false)
+
+// matchDebug is the per-match part of /debug/stats.
+type matchDebug struct {
+	ID      string `json:"id"`
+	Clients int    `json:"clients"`
+	Queued  int    `json:"queued"`
+	// Goroutines estimates what the match accounts for: a reader, a writer
+	// and a heartbeat per connection plus its background workers.
+	Goroutines int `json:"goroutines"`
+}
+
+// debugStats is the JSON body served at /debug/stats.
+type debugStats struct {
+	Goroutines int          `json:"goroutines"`
+	Clients    int          `json:"clients"`
+	Matches    []matchDebug `json:"matches"`
+}
+
+// connGoroutines is how many goroutines each connection runs.
+func connGoroutines() int {
+	if pingInterval > 0 {
+		return 3
+	}
+	return 2
+}
+
+// backgroundWorkers counts the long-running goroutines started by
+// initialize.
+func backgroundWorkers() int {
+	n := 0
+	for _, running := range []bool{feed != nil, sim != nil, mqtt != nil, webhook != nil} {
+		if running {
+			n++
+		}
+	}
+	return n
+}
+
+// serveDebugStats reports goroutine and connection counts, to confirm that
+// disconnects actually release their goroutines.
+func serveDebugStats(w http.ResponseWriter, r *http.Request) {
+	hub.mutex.Lock()
+	m := matchDebug{ID: defaultMatchID, Queued: len(hub.queue)}
+	roomClients := make(map[string]int)
+	for c := range hub.clients {
+		if c.game.Load() == &gameState {
+			m.Clients++
+		} else {
+			roomClients[c.game.Load().matchID()]++
+		}
+	}
+	total := len(hub.clients) + len(hub.queue)
+	hub.mutex.Unlock()
+	m.Goroutines = (m.Clients+m.Queued)*connGoroutines() + backgroundWorkers()
+
+	matches := []matchDebug{m}
+	for _, id := range slices.Sorted(maps.Keys(roomClients)) {
+		n := roomClients[id]
+		matches = append(matches, matchDebug{ID: id, Clients: n, Goroutines: n * connGoroutines()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(debugStats{
+		Goroutines: runtime.NumGoroutine(),
+		Clients:    total,
+		Matches:    matches,
+	})
+}
+
+// registerPprof mounts the profiling handlers when DEBUG_PPROF is set.
+func registerPprof(mux *http.ServeMux) {
+	if !debugPprof {
+		return
+	}
+	mux.HandleFunc("/debug/pprof/", requireAdmin(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", requireAdmin(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", requireAdmin(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", requireAdmin(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", requireAdmin(pprof.Trace))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBackfill caps how many versions one /diff replays; requests reaching
+// further back get a full snapshot instead, which costs the same however
+// stale the client is.
+var maxBackfill = envInt("MAX_BACKFILL", 500)
+
+// diffRateLimit caps /diff requests per client IP per minute, so a client
+// can't force repeated backfills; 0 disables the limit.
+var diffRateLimit = envInt("DIFF_RATE_LIMIT", 60)
+
+// Resync counters, exposed on /metrics.
+var (
+	resyncsThrottled  atomic.Uint64
+	resyncsDowngraded atomic.Uint64
+)
+
+// diffLimiter counts /diff requests per IP in fixed one-minute windows.
+type diffLimiter struct {
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+var resyncLimiter = &diffLimiter{}
+
+// allow counts a request from ip, reporting false once the limit is spent.
+func (l *diffLimiter) allow(ip string) bool {
+	if diffRateLimit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.window) >= time.Minute {
+		l.window = now
+		l.counts = make(map[string]int)
+	}
+	l.counts[ip]++
+	return l.counts[ip] <= diffRateLimit
+}
+
+// eventDiff is the JSON body served at /diff. When a catch-up to the current
+// version exceeds maxBackfill, Events is omitted and Snapshot holds the
+// current state.
+type eventDiff struct {
+	From     uint64          `json:"from"`
+	To       uint64          `json:"to"`
+	Events   []archivedEvent `json:"events,omitempty"`
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// eventsBetween returns the events that turned version from into version
+// to. Both must lie within the retained log. The caller must hold gs.mu.
+func (gs *GameState) eventsBetween(from, to uint64) ([]archivedEvent, error) {
+	if from > to {
+		return nil, fmt.Errorf("from (%d) is after to (%d)", from, to)
+	}
+	if to > gs.Version {
+		return nil, fmt.Errorf("to (%d) is past the current version %d", to, gs.Version)
+	}
+	oldest := gs.Version // the earliest "from" the log can answer
+	if len(gs.events) > 0 {
+		oldest = gs.events[0].Version - 1
+	}
+	if from < oldest {
+		return nil, fmt.Errorf("from (%d) is older than the retained history (%d)", from, oldest)
+	}
+	var events []archivedEvent
+	for _, ev := range gs.events {
+		if ev.Version > from && ev.Version <= to {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// serveDiff returns the events between ?from= and ?to= versions, so a client
+// that was offline can replay exactly what it missed.
+func serveDiff(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(matchID(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	ip := clientIP(r).String()
+	if !resyncLimiter.allow(ip) {
+		resyncsThrottled.Add(1)
+		log.Printf("throttling /diff requests from %s", ip)
+		writeJSONError(w, http.StatusTooManyRequests, CodeRateLimited, "too many resync requests")
+		return
+	}
+	q := r.URL.Query()
+	from, err := strconv.ParseUint(q.Get("from"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "from must be a state version")
+		return
+	}
+	gs.mu.Lock()
+	to := gs.Version
+	if v := q.Get("to"); v != "" {
+		if to, err = strconv.ParseUint(v, 10, 64); err != nil {
+			gs.mu.Unlock()
+			writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "to must be a state version")
+			return
+		}
+	}
+	// A from past to, such as a stale client's from past the current
+	// version, is refused before any backfill arithmetic.
+	if from > to {
+		gs.mu.Unlock()
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("from (%d) is after to (%d)", from, to))
+		return
+	}
+	if maxBackfill > 0 && to == gs.Version && to-from > uint64(maxBackfill) {
+		snapshot, _ := json.Marshal(gs)
+		snapshot = gs.publicPayload(snapshot)
+		gs.mu.Unlock()
+		resyncsDowngraded.Add(1)
+		log.Printf("resync from %s spans %d versions, sending a snapshot", ip, to-from)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(eventDiff{From: from, To: to, Snapshot: snapshot})
+		return
+	}
+	if maxBackfill > 0 && to-from > uint64(maxBackfill) {
+		gs.mu.Unlock()
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("at most %d versions can be replayed; omit to for a snapshot", maxBackfill))
+		return
+	}
+	events, err := gs.eventsBetween(from, to)
+	gs.mu.Unlock()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	// Actors name connections by IP; they are for the audited admin views.
+	for i := range events {
+		events[i].Actor = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eventDiff{From: from, To: to, Events: events})
+}
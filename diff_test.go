@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiffRefusesFromAfterTo(t *testing.T) {
+	srv := newTestServer(t)
+	for range 3 {
+		applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+	}
+
+	for _, path := range []string{
+		// A stale from past the current version.
+		"/diff?from=10",
+		"/diff?from=3&to=1",
+	} {
+		resp, body := get(t, srv, path, nil)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "is after to") {
+			t.Errorf("GET %s: %d %s, want 400 from after to", path, resp.StatusCode, body)
+		}
+	}
+	if resp, body := get(t, srv, "/diff?from=1&to=3", nil); resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"events"`) {
+		t.Errorf("GET /diff?from=1&to=3: %d %s, want the events", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+
+	"livescore/engine"
+)
+
+// displayScore computes each team's display score with the formula of
+// engine.DisplayFormulas named by DISPLAY_SCORE, or is nil (the default) to
+// broadcast official scores only.
+var displayScore = displayFormulaFromEnv()
+
+func displayFormulaFromEnv() engine.DisplayScore {
+	name := envString("DISPLAY_SCORE", "")
+	if name == "" {
+		return nil
+	}
+	f, ok := engine.DisplayFormulas[name]
+	if !ok {
+		log.Printf("unknown DISPLAY_SCORE=%q, broadcasting official scores only", name)
+	}
+	return f
+}
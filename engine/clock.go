@@ -0,0 +1,58 @@
+package engine
+
+import "time"
+
+// Clock tracks elapsed time in the current period. While running,
+// elapsed is the time banked before startedAt.
+type Clock struct {
+	Elapsed   time.Duration
+	StartedAt time.Time
+}
+
+// Running reports whether the clock is ticking.
+func (c Clock) Running() bool {
+	return !c.StartedAt.IsZero()
+}
+
+// At returns the elapsed period time at now, clamped to [0, limit]. A zero
+// limit leaves the clock unbounded above.
+func (c Clock) At(now time.Time, limit time.Duration) time.Duration {
+	d := c.Elapsed
+	if c.Running() {
+		d += now.Sub(c.StartedAt)
+	}
+	return clampClock(d, limit)
+}
+
+// clampClock bounds d to [0, limit], or to [0, ∞) when limit is zero.
+func clampClock(d, limit time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if limit > 0 && d > limit {
+		return limit
+	}
+	return d
+}
+
+// Start sets the clock running; it is a no-op if it already is.
+func (c *Clock) Start(now time.Time) {
+	if !c.Running() {
+		c.StartedAt = now
+	}
+}
+
+// stop banks the elapsed time and halts the clock.
+func (c *Clock) stop(now time.Time, limit time.Duration) {
+	c.Elapsed = c.At(now, limit)
+	c.StartedAt = time.Time{}
+}
+
+// adjust moves the clock by delta, keeping it within the period. A running
+// clock keeps running from the corrected time.
+func (c *Clock) adjust(now time.Time, delta, limit time.Duration) {
+	c.Elapsed = clampClock(c.At(now, limit)+delta, limit)
+	if c.Running() {
+		c.StartedAt = now
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReasonRequired is returned when a correction carries no justification.
+var ErrReasonRequired = errors.New("a correction needs a reason")
+
+// Correction is an audited manual adjustment of a team's score. Corrections
+// are kept apart from normal scoring so disputes can be reviewed.
+type Correction struct {
+	Version uint64    `json:"version"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Team    string    `json:"team"`
+	Before  float64   `json:"before"`
+	After   float64   `json:"after"`
+	Reason  string    `json:"reason"`
+}
+
+// correct sets a team's score and records who changed it and why.
+func (gs *GameState) correct(msg Message) error {
+	t := gs.Team(msg.Team)
+	if t == nil {
+		return ErrUnknownTeam
+	}
+	if msg.Reason == "" {
+		return ErrReasonRequired
+	}
+	score, err := gs.Options.ValidScore(msg.Value)
+	if err != nil {
+		return err
+	}
+	gs.Corrections = append(gs.Corrections, Correction{
+		Version: gs.Version + 1,
+		At:      msg.Time(),
+		Actor:   msg.Actor,
+		Team:    t.Name,
+		Before:  t.Score,
+		After:   score,
+		Reason:  msg.Reason,
+	})
+	t.Score = score
+	gs.History = nil
+	return nil
+}
@@ -0,0 +1,43 @@
+package engine
+
+// DisplayScore derives the headline number an overlay shows for a team when
+// it differs from the official score, e.g. official points minus penalties.
+// It runs while the state is encoded.
+//
+// Pick one of DisplayFormulas, or assign your own to Display before any
+// match is encoded:
+//
+//	engine.Display = func(gs *engine.GameState, t engine.Team) float64 {
+//		return t.Score * 10
+//	}
+type DisplayScore func(gs *GameState, t Team) float64
+
+// DisplayFormulas are the built-in formulas, by name.
+var DisplayFormulas = map[string]DisplayScore{
+	// minus_fouls charges a point per team foul.
+	"minus_fouls": func(gs *GameState, t Team) float64 {
+		return gs.Options.round(t.Score - float64(t.Fouls))
+	},
+	// lead is the margin over the best other team; negative when behind.
+	"lead": func(gs *GameState, t Team) float64 {
+		best, found := 0.0, false
+		for _, other := range gs.Teams {
+			if other.Name != t.Name && (!found || other.Score > best) {
+				best, found = other.Score, true
+			}
+		}
+		return gs.Options.round(t.Score - best)
+	},
+}
+
+// withDisplay returns teams annotated with their display scores, leaving
+// the originals untouched.
+func (gs *GameState) withDisplay(teams []Team) []Team {
+	out := make([]Team, len(teams))
+	for i, t := range teams {
+		d := Display(gs, t)
+		t.Display = &d
+		out[i] = t
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+// Package engine keeps the score of a match: its teams, options and clock
+// and the rules of every action, with no network code. The livescore server
+// runs each of its matches on a GameState; programs embedding the
+// scoreboard use an Engine.
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Engine runs a match on its own, for programs that embed the scoreboard
+// rather than serve it. It is safe for concurrent use.
+type Engine struct {
+	mu    sync.Mutex
+	state GameState
+	// subs maps each subscriber's channel to its sending side.
+	subs map[<-chan GameState]chan GameState
+}
+
+// New returns an engine running a fresh match between the default teams
+// (see TeamNames), scored with options.
+func New(options MatchOptions) *Engine {
+	e := &Engine{
+		state: GameState{Teams: DefaultTeams(), Period: 1, Options: options, CreatedAt: time.Now()},
+		subs:  make(map[<-chan GameState]chan GameState),
+	}
+	e.state.ResetTimeouts()
+	e.state.ResetLevels()
+	return e
+}
+
+// Apply performs the action msg, as a client's message would, and sends
+// the new state to every subscriber. On error the state is unchanged and
+// nothing is sent. Actions reserved for the server, such as "read_only",
+// need msg.Trusted.
+func (e *Engine) Apply(msg Message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.state.Apply(msg); err != nil {
+		return err
+	}
+	state := *e.state.Clone()
+	for _, ch := range e.subs {
+		// Each channel holds only the latest state: a subscriber that
+		// falls behind skips ahead instead of holding up Apply.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- state
+	}
+	return nil
+}
+
+// State returns a copy of the current state.
+func (e *Engine) State() GameState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return *e.state.Clone()
+}
+
+// Subscribe returns a channel that receives the state after each action
+// applied from now on. It buffers one state, the latest, so a slow reader
+// misses intermediate versions but never the current one.
+func (e *Engine) Subscribe() <-chan GameState {
+	ch := make(chan GameState, 1)
+	e.mu.Lock()
+	e.subs[ch] = ch
+	e.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops sending states to ch, a channel from Subscribe, and
+// closes it.
+func (e *Engine) Unsubscribe(ch <-chan GameState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if send, ok := e.subs[ch]; ok {
+		delete(e.subs, ch)
+		close(send)
+	}
+}
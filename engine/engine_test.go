@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// receive returns the next state sent on ch.
+func receive(t *testing.T, ch <-chan GameState) GameState {
+	t.Helper()
+	select {
+	case s, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("no state within a second")
+		return GameState{}
+	}
+}
+
+func TestEngineSendsEachAppliedState(t *testing.T) {
+	e := New(MatchOptions{Step: 1})
+	states := e.Subscribe()
+
+	if err := e.Apply(Message{Action: "increment", Team: "A", Points: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s := receive(t, states)
+	if s.Version != 1 || s.Team("A").Score != 2 {
+		t.Errorf("after the increment: version %d with A=%v, want version 1 with A=2", s.Version, s.Team("A").Score)
+	}
+
+	// A refused action changes nothing and sends nothing.
+	if err := e.Apply(Message{Action: "increment", Team: "Z"}); !errors.Is(err, ErrUnknownTeam) {
+		t.Errorf("increment of an unknown team: %v, want ErrUnknownTeam", err)
+	}
+	if err := e.Apply(Message{Action: "read_only", ReadOnly: true}); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("untrusted read_only: %v, want ErrAdminOnly", err)
+	}
+	select {
+	case s := <-states:
+		t.Errorf("state sent for a refused action: version %d", s.Version)
+	default:
+	}
+
+	// The subscriber's copy is its own.
+	s.Teams[0].Score = 99
+	if now := e.State(); now.Team("A").Score != 2 {
+		t.Errorf("engine's A=%v after a subscriber edited its state, want 2", now.Team("A").Score)
+	}
+}
+
+func TestEngineSubscriberSkipsToTheLatestState(t *testing.T) {
+	e := New(MatchOptions{Step: 1})
+	slow, fast := e.Subscribe(), e.Subscribe()
+
+	for range 3 {
+		if err := e.Apply(Message{Action: "increment", Team: "B"}); err != nil {
+			t.Fatal(err)
+		}
+		receive(t, fast)
+	}
+	if s := receive(t, slow); s.Version != 3 || s.Team("B").Score != 3 {
+		t.Errorf("slow subscriber got version %d with B=%v, want version 3 with B=3", s.Version, s.Team("B").Score)
+	}
+
+	e.Unsubscribe(slow)
+	if _, ok := <-slow; ok {
+		t.Error("state received after Unsubscribe")
+	}
+	if err := e.Apply(Message{Action: "finish"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := receive(t, fast); !s.Finished || s.Winner != "B" {
+		t.Errorf("finished %v with winner %q, want B to win", s.Finished, s.Winner)
+	}
+}
@@ -0,0 +1,912 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// OnMax behaviours applied when an increment would pass MatchOptions.ScoreMax.
+const (
+	OnMaxCap    = "cap"    // hold the score at the ceiling
+	OnMaxWrap   = "wrap"   // roll the score over, modulo the ceiling plus one
+	OnMaxReject = "reject" // refuse the action
+	OnMaxWin    = "win"    // hold the score and award the match
+)
+
+// maxTeamNameLen bounds team names so they fit on an overlay.
+const maxTeamNameLen = 32
+
+// DefaultMaxTeams is the team limit used when MatchOptions.MaxTeams is unset.
+const DefaultMaxTeams = 16
+
+// MaxExactScore is the largest whole score kept exactly: scores are float64,
+// like JavaScript numbers, and both lose integers past 2^53. It bounds every
+// match, so long-running counters stop there instead of drifting.
+const MaxExactScore = 1<<53 - 1
+
+var (
+	// ErrScoreMax is returned when an increment is rejected by the score ceiling.
+	ErrScoreMax = errors.New("score limit reached")
+	// ErrUnknownTeam is returned when an action names a team not in the match.
+	ErrUnknownTeam = errors.New("unknown team")
+	// ErrTeamName is returned for empty or overlong team names.
+	ErrTeamName = errors.New("invalid team name")
+	// ErrTeamNameTaken is returned when a rename collides with another team.
+	ErrTeamNameTaken = errors.New("team name already in use")
+	// ErrInvalidValue is returned when "set" carries an unusable score.
+	ErrInvalidValue = errors.New("invalid score value")
+	// ErrInvalidScope is returned for a reset with an unknown scope.
+	ErrInvalidScope = errors.New(`reset scope must be "score" or "all"`)
+	// ErrResetNotArmed is returned for a reset that wasn't armed in time on
+	// a match that requires arming.
+	ErrResetNotArmed = errors.New(`reset must be armed first: send "reset_arm" and confirm within the window`)
+	// ErrMatchFinished is returned for actions on a finished match; only a
+	// reset, "new_game" or "configure" reopens it, and a forced
+	// "declare_winner" can overrule the result.
+	ErrMatchFinished = errors.New("match is finished")
+	// ErrTooManyTeams is returned when adding a team would pass the
+	// match's team limit.
+	ErrTooManyTeams = errors.New("team limit reached")
+	// ErrNotStarted is returned for scoring on a scheduled match before its
+	// start time.
+	ErrNotStarted = errors.New("match has not started")
+	// ErrNoTimeouts is returned when a team with no timeouts left calls one.
+	ErrNoTimeouts = errors.New("no timeouts left")
+	// ErrMatchReadOnly is returned for every action on a read-only match
+	// until an admin lifts the flag.
+	ErrMatchReadOnly = errors.New("match is read-only")
+	// ErrAdminOnly is returned when a client sends an action reserved for
+	// the admin API.
+	ErrAdminOnly = errors.New("action is reserved for admins")
+	// ErrUnknownAction is returned for an action the server doesn't know,
+	// usually a typo in the client.
+	ErrUnknownAction = errors.New("unknown action")
+	// ErrScoreZero is returned for a decrement of a team with no score.
+	ErrScoreZero = errors.New("score is already zero")
+)
+
+// Encoding settings shared by every match. A server sets them once, before
+// any match is encoded.
+var (
+	// TeamNames are the teams a new match starts with.
+	TeamNames = []string{"A", "B"}
+	// Display computes each team's display score, or is nil (the default)
+	// to encode official scores only.
+	Display DisplayScore
+	// SendConfig keeps the preset's "config" object in every state.
+	SendConfig = true
+	// SendCreatedAt adds when the match was created to every state, for
+	// servers that limit how long a match lives.
+	SendCreatedAt bool
+)
+
+// ValidateTeamName normalises a team name and checks it is usable.
+func ValidateTeamName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxTeamNameLen {
+		return "", ErrTeamName
+	}
+	return name, nil
+}
+
+// MatchOptions tunes how a match is scored.
+type MatchOptions struct {
+	// ScoreMax is the highest score a team can reach; 0 means no limit
+	// below maxExactScore.
+	ScoreMax int
+	// OnMax selects what happens when an increment passes ScoreMax. With
+	// OnMaxWin, the first team to reach it wins the match.
+	OnMax string
+	// Step is what an increment or decrement without a value is worth.
+	Step int
+	// Precision is the number of decimal places scores carry, for sports
+	// such as diving or gymnastics; 0 keeps whole-number scores.
+	Precision int
+	// StringScores encodes scores as JSON strings ("score":"123"), for
+	// counter boards whose clients parse numbers into narrow integers.
+	StringScores bool
+	// Timeouts is how many timeouts each team gets per game; 0 disables
+	// timeout tracking. TimeoutStopsClock halts the clock when one is used.
+	Timeouts          int
+	TimeoutStopsClock bool
+	// MaxTeams bounds the number of teams, keeping payloads and overlays
+	// manageable; 0 means defaultMaxTeams.
+	MaxTeams int
+	// ResetFoulsOnPeriod clears every team's fouls when a new period starts.
+	ResetFoulsOnPeriod bool
+	// Levels, from the preset, turns on scoring in sets, games and points.
+	Levels *LevelRules
+	// MaxBroadcastBytes bounds a state broadcast; larger payloads are
+	// replaced by the compact form. 0 means unlimited.
+	MaxBroadcastBytes int
+	// RequireResetArm makes a reset valid only within ResetArmWindow of a
+	// "reset_arm" action, guarding live games against stray resets.
+	RequireResetArm bool
+	ResetArmWindow  time.Duration
+	// ResetOnEmpty resets the score when the last client disconnects, for
+	// kiosk-style boards. Off by default so scores persist between sessions.
+	ResetOnEmpty bool
+	// SnapshotLastWhileFrozen sends clients joining during a freeze the last
+	// broadcast state instead of the live, possibly mid-edit one.
+	SnapshotLastWhileFrozen bool
+
+	// Preset names the layout loaded by "configure", or is empty. Periods,
+	// PeriodLength and Points come from it; see Preset.
+	Preset       string
+	Periods      int
+	PeriodLength time.Duration
+	Points       []int
+}
+
+// increment returns the score after adding points, honouring the ceiling.
+func (o MatchOptions) increment(score, points float64) (float64, error) {
+	next := o.round(score + points)
+	if next <= o.ceiling() {
+		return next, nil
+	}
+	switch o.OnMax {
+	case OnMaxWrap:
+		// Points past the ceiling carry over, as on a counter of
+		// ScoreMax+1 positions.
+		return o.round(math.Mod(next, o.ceiling()+1)), nil
+	case OnMaxReject:
+		return score, ErrScoreMax
+	default:
+		return o.ceiling(), nil
+	}
+}
+
+// ceiling returns the highest score a team can reach: ScoreMax, or the
+// largest score the precision keeps exact.
+func (o MatchOptions) ceiling() float64 {
+	exact := math.Floor(MaxExactScore / math.Pow10(o.Precision))
+	if o.ScoreMax > 0 && float64(o.ScoreMax) < exact {
+		return float64(o.ScoreMax)
+	}
+	return exact
+}
+
+// round rounds v to the score precision. Every stored score goes through
+// it, so equal scores are bitwise equal and can be compared directly.
+func (o MatchOptions) round(v float64) float64 {
+	scale := math.Pow10(o.Precision)
+	return math.Round(v*scale) / scale
+}
+
+// exact reports whether v needs no more than the score precision.
+func (o MatchOptions) exact(v float64) bool {
+	return math.Abs(o.round(v)-v) < 1e-9
+}
+
+// ValidScore checks a score given by "set" or "correct": non-negative,
+// within the ceiling and within the precision.
+func (o MatchOptions) ValidScore(v float64) (float64, error) {
+	if v < 0 || v > o.ceiling() || !o.exact(v) {
+		return 0, ErrInvalidValue
+	}
+	return o.round(v), nil
+}
+
+// TeamLimit returns the effective team limit.
+func (o MatchOptions) TeamLimit() int {
+	if o.MaxTeams > 0 {
+		return o.MaxTeams
+	}
+	return DefaultMaxTeams
+}
+
+// Team is one side of a match. Actions refer to teams by name.
+type Team struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	// Color is an optional display color for overlays, e.g. "#c8102e".
+	Color string `json:"color,omitempty"`
+	// Fouls counts team fouls or penalties for sports that track them.
+	Fouls int `json:"fouls,omitempty"`
+	// TimeoutsLeft counts remaining timeouts when the match tracks them.
+	TimeoutsLeft int `json:"timeoutsLeft,omitempty"`
+	// Display is the derived headline score, broadcast alongside the
+	// official one when a display formula is configured.
+	Display *float64 `json:"display,omitempty"`
+	// Levels holds sets, games and points when the preset scores in
+	// levels. Score then counts the sets won.
+	Levels *LevelScore `json:"levels,omitempty"`
+}
+
+// ParseTeamNames parses a comma-separated list of distinct team names.
+func ParseTeamNames(list string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, raw := range strings.Split(list, ",") {
+		name, err := ValidateTeamName(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", err, raw)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %q", ErrTeamNameTaken, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// DefaultTeams returns the teams a new match starts with.
+func DefaultTeams() []Team {
+	return TeamsNamed(TeamNames)
+}
+
+// TeamsNamed returns a fresh team for each name.
+func TeamsNamed(names []string) []Team {
+	teams := make([]Team, len(names))
+	for i, name := range names {
+		teams[i].Name = name
+	}
+	return teams
+}
+
+// GameState is the state of a match and the rules that change it.
+type GameState struct {
+	Teams   []Team       `json:"teams"`
+	Period  int          `json:"period"`
+	Version uint64       `json:"version"`
+	Options MatchOptions `json:"-"`
+	// StartsAt is when a scheduled match opens for scoring; until then
+	// clients watch an empty board. Zero means the match is live at once.
+	StartsAt time.Time `json:"startsAt"`
+	// EndsAt is the wall-clock time a time-boxed match finishes; zero means
+	// the match has no hard end.
+	EndsAt time.Time `json:"endsAt"`
+	// CreatedAt is when the match was created, for lifetime limits.
+	CreatedAt time.Time `json:"createdAt"`
+	// Finished is set once the match is over; Winner names the winning team,
+	// or is empty for a draw.
+	Finished bool   `json:"finished"`
+	Winner   string `json:"winner"`
+	// ReadOnly keeps a finalized match as a viewable record: it can still
+	// be read, but every action is refused. Only the admin API sets it.
+	ReadOnly bool `json:"readOnly"`
+	// Corrections is the audit trail of "correct" actions. It is served by
+	// /corrections rather than broadcast.
+	Corrections []Correction `json:"-"`
+
+	// NotStarted is set while a scheduled match waits for StartsAt.
+	NotStarted bool `json:"-"`
+	// ResetArmedUntil is when an armed reset expires.
+	ResetArmedUntil time.Time `json:"-"`
+	// Clock is the period clock, broadcast as elapsedMs.
+	Clock Clock `json:"-"`
+	// Frozen suppresses broadcasts while an operator composes several edits.
+	Frozen bool `json:"-"`
+	// TeamSets are the line-ups registered for the board, and ActiveSet
+	// indexes the one in Teams. Empty until a second set is added.
+	TeamSets  [][]Team `json:"-"`
+	ActiveSet int      `json:"-"`
+	// Series tallies the games finalized by "new_game".
+	Series SeriesJSON `json:"-"`
+	// History holds the latest score changes, oldest first, for "undo".
+	History []scoreChange `json:"-"`
+}
+
+// Clone returns a copy of what the match shows, its teams, options, clock
+// and result, sharing nothing mutable with gs. Corrections, team sets and
+// the undo history stay behind.
+func (gs *GameState) Clone() *GameState {
+	teams := append([]Team(nil), gs.Teams...)
+	for i := range teams {
+		teams[i].Levels = teams[i].Levels.clone()
+	}
+	return &GameState{
+		Teams:     teams,
+		Period:    gs.Period,
+		Version:   gs.Version,
+		Options:   gs.Options,
+		StartsAt:  gs.StartsAt,
+		EndsAt:    gs.EndsAt,
+		CreatedAt: gs.CreatedAt,
+		Finished:  gs.Finished,
+		Winner:    gs.Winner,
+		ReadOnly:  gs.ReadOnly,
+		Clock:     gs.Clock,
+		Series:    gs.Series.clone(),
+
+		NotStarted: gs.NotStarted,
+		Frozen:     gs.Frozen,
+	}
+}
+
+// MarshalFiltered renders the state with only the teams in filter, keeping
+// their order in the match.
+func (gs *GameState) MarshalFiltered(filter map[string]bool) []byte {
+	payload, _ := json.Marshal(gs.Wire(gs.FilterTeams(filter)))
+	return payload
+}
+
+// FilterTeams returns the teams in filter, in match order, or every team
+// for a nil filter.
+func (gs *GameState) FilterTeams(filter map[string]bool) []Team {
+	if filter == nil {
+		return gs.Teams
+	}
+	teams := make([]Team, 0, len(filter))
+	for _, t := range gs.Teams {
+		if filter[t.Name] {
+			teams = append(teams, t)
+		}
+	}
+	return teams
+}
+
+// StateJSON is the wire form of GameState. Fields are listed explicitly and
+// collections are slices, never maps, so the same state always encodes to
+// the same bytes whether it is sent in full or filtered.
+type StateJSON struct {
+	Teams    []Team     `json:"teams"`
+	Period   int        `json:"period"`
+	Version  uint64     `json:"version"`
+	StartsAt *time.Time `json:"startsAt,omitempty"`
+	// NotStarted marks a scheduled match still waiting for StartsAt.
+	NotStarted bool       `json:"notStarted,omitempty"`
+	EndsAt     *time.Time `json:"endsAt,omitempty"`
+	Finished   bool       `json:"finished,omitempty"`
+	Winner     string     `json:"winner,omitempty"`
+	ReadOnly   bool       `json:"readOnly,omitempty"`
+	// ElapsedMs is the period clock when the state was encoded; clients
+	// extrapolate from it while ClockRunning is set.
+	ElapsedMs    int64 `json:"elapsedMs,omitempty"`
+	ClockRunning bool  `json:"clockRunning,omitempty"`
+	// CreatedAt is sent when matches have a maximum lifetime.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	// Config describes the preset loaded by "configure".
+	Config *matchConfig `json:"config,omitempty"`
+
+	// stringScores selects MatchOptions.StringScores encoding.
+	stringScores bool
+	// Series is the tally of finalized games, once there is one.
+	Series *SeriesJSON `json:"series,omitempty"`
+	// Formatted holds display strings for clients that set a locale.
+	Formatted *FormattedJSON `json:"formatted,omitempty"`
+}
+
+// FormattedJSON carries display strings for a client's locale, alongside
+// the raw values so clients can still reformat. Scores line up with the
+// teams in the same payload.
+type FormattedJSON struct {
+	Locale string   `json:"locale"`
+	Scores []string `json:"scores"`
+	// Clock is the period clock as MM:SS, or H:MM:SS past an hour.
+	Clock string `json:"clock"`
+}
+
+// Wire returns the wire form of the state showing the given teams.
+func (gs *GameState) Wire(teams []Team) StateJSON {
+	if Display != nil {
+		teams = gs.withDisplay(teams)
+	}
+	s := StateJSON{
+		Teams:    teams,
+		Period:   gs.Period,
+		Version:  gs.Version,
+		Finished: gs.Finished,
+		Winner:   gs.Winner,
+		ReadOnly: gs.ReadOnly,
+
+		stringScores: gs.Options.StringScores,
+
+		NotStarted: gs.NotStarted,
+
+		ElapsedMs:    gs.Clock.At(time.Now(), gs.Options.PeriodLength).Milliseconds(),
+		ClockRunning: gs.Clock.Running(),
+	}
+	if SendConfig {
+		s.Config = gs.Options.Config()
+	}
+	if !gs.StartsAt.IsZero() {
+		startsAt := gs.StartsAt
+		s.StartsAt = &startsAt
+	}
+	if !gs.EndsAt.IsZero() {
+		endsAt := gs.EndsAt
+		s.EndsAt = &endsAt
+	}
+	if SendCreatedAt && !gs.CreatedAt.IsZero() {
+		createdAt := gs.CreatedAt
+		s.CreatedAt = &createdAt
+	}
+	if gs.Series.Games > 0 {
+		series := gs.Series
+		s.Series = &series
+	}
+	return s
+}
+
+// compactTeam and compactStateJSON form the minimal state payload: names,
+// scores and version only.
+type compactTeam struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type compactStateJSON struct {
+	Teams   []compactTeam `json:"teams"`
+	Version uint64        `json:"version"`
+	Compact bool          `json:"compact"`
+}
+
+// MarshalCompact renders the compact state payload.
+func (gs *GameState) MarshalCompact() []byte {
+	c := compactStateJSON{Teams: make([]compactTeam, len(gs.Teams)), Version: gs.Version, Compact: true}
+	for i, t := range gs.Teams {
+		c.Teams[i] = compactTeam{Name: t.Name, Score: t.Score}
+	}
+	if gs.Options.StringScores {
+		payload, _ := json.Marshal(stringCompactState(c))
+		return payload
+	}
+	payload, _ := json.Marshal(c)
+	return payload
+}
+
+// MarshalJSON implements json.Marshaler via stateJSON.
+func (gs *GameState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(gs.Wire(gs.Teams))
+}
+
+// UnmarshalJSON implements json.Unmarshaler for saved snapshots. It fills
+// the fields a snapshot persists; see restoreSaved.
+func (gs *GameState) UnmarshalJSON(data []byte) error {
+	var s StateJSON
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	gs.Teams = s.Teams
+	for i := range gs.Teams {
+		gs.Teams[i].Display = nil // derived, never stored
+	}
+	gs.Period = s.Period
+	gs.Version = s.Version
+	gs.Finished = s.Finished
+	gs.Winner = s.Winner
+	gs.ReadOnly = s.ReadOnly
+	if s.EndsAt != nil {
+		gs.EndsAt = *s.EndsAt
+	}
+	if s.CreatedAt != nil {
+		gs.CreatedAt = *s.CreatedAt
+	}
+	if s.Series != nil {
+		gs.Series = *s.Series
+	}
+	return nil
+}
+
+// RestoreSaved takes over the persisted fields of a loaded snapshot. The
+// schedule and options stay as configured, and EndsAt only moves if the
+// snapshot set one.
+func (gs *GameState) RestoreSaved(saved *GameState) {
+	gs.Teams = saved.Teams
+	gs.Period = saved.Period
+	gs.Version = saved.Version
+	gs.Finished = saved.Finished
+	gs.Winner = saved.Winner
+	gs.ReadOnly = saved.ReadOnly
+	if !saved.EndsAt.IsZero() {
+		gs.EndsAt = saved.EndsAt
+	}
+	if !saved.CreatedAt.IsZero() {
+		gs.CreatedAt = saved.CreatedAt
+	}
+	gs.Series = saved.Series
+}
+
+// Team returns the team with the given name, or nil if there is none.
+func (gs *GameState) Team(name string) *Team {
+	for i := range gs.Teams {
+		if gs.Teams[i].Name == name {
+			return &gs.Teams[i]
+		}
+	}
+	return nil
+}
+
+// Message represents an incoming command from a client.
+type Message struct {
+	Action string  `json:"action"`          // see GameState.apply for the full list
+	Team   string  `json:"team"`            // team name, e.g. "A", "B"
+	Name   string  `json:"name,omitempty"`  // new team name for "rename" and "add_team", display name for "setname"
+	Value  float64 `json:"value,omitempty"` // score for "set", points for "increment" and "decrement"
+	// Points is what an "increment" or "decrement" is worth, in place of
+	// Value, e.g. 3 for a three-pointer. Zero means the match's Step.
+	Points float64 `json:"points,omitempty"`
+	// Teams lists the teams a "filter" action subscribes to.
+	Teams []string `json:"teams,omitempty"`
+	// Reason justifies a "correct" action.
+	Reason string `json:"reason,omitempty"`
+	// Scope selects what "reset" clears: "all" (the default) starts a new
+	// game, "score" only zeroes the scores.
+	Scope string `json:"scope,omitempty"`
+	// Preset names the layout "configure" loads.
+	Preset string `json:"preset,omitempty"`
+	// DeltaMs is the signed clock correction for "clock_adjust".
+	DeltaMs int64 `json:"deltaMs,omitempty"`
+	// Force lets "declare_winner" overrule a match that already finished.
+	Force bool `json:"force,omitempty"`
+	// Lineup is the team set "teamset_add" registers; Index picks the set
+	// "teamset_switch" activates.
+	Lineup []Team `json:"lineup,omitempty"`
+	Index  int    `json:"index,omitempty"`
+	// Locale is the language tag "setlocale" formats states for, e.g.
+	// "de-DE"; empty turns formatting off.
+	Locale string `json:"locale,omitempty"`
+	// Level is what "level_increment" scores: "points", "games" or "sets".
+	Level string `json:"level,omitempty"`
+	// Emoji is the reaction a viewer sends with "react".
+	Emoji string `json:"emoji,omitempty"`
+	// Ms is the length of a "countdown".
+	Ms int64 `json:"ms,omitempty"`
+	// ReadOnly is the flag the admin-only "read_only" action sets.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Client is the connection ID (as listed by the admin API) that
+	// "mute_client" and "unmute_client" target.
+	Client uint64 `json:"client,omitempty"`
+	// Match names the match a multiplexed connection's action is for, its
+	// own or one it subscribed to; empty means its own.
+	Match string `json:"match,omitempty"`
+	// Nonce and Ts are the replay protection of a signed action, checked
+	// by verifySigned before the action is decoded.
+	Nonce string `json:"nonce,omitempty"`
+	Ts    int64  `json:"ts,omitempty"`
+
+	// Actor identifies the sender for audit records. It and the fields
+	// below are set by the server, never decoded from the client.
+	Actor string `json:"-"`
+	// Trusted marks server-originated actions (admin endpoints, timers,
+	// feeds), which skip interactive safeguards such as reset arming.
+	Trusted bool `json:"-"`
+	// Replayed marks an action read back from the persisted event log.
+	Replayed bool `json:"-"`
+	// At is when the action was first applied, set when replaying a
+	// persisted event log; zero means now.
+	At time.Time `json:"-"`
+}
+
+// Worth returns what an "increment" or "decrement" is worth as sent:
+// Points, or Value from clients that predate it.
+func (m Message) Worth() float64 {
+	if m.Points != 0 {
+		return m.Points
+	}
+	return m.Value
+}
+
+// Time returns when the action takes effect.
+func (m Message) Time() time.Time {
+	if m.At.IsZero() {
+		return time.Now().UTC()
+	}
+	return m.At
+}
+
+// Apply performs the action msg and bumps the version. On error the state
+// is left unchanged.
+func (gs *GameState) Apply(msg Message) error {
+	if err := gs.apply(msg); err != nil {
+		return err
+	}
+	gs.Version++
+	return nil
+}
+
+// apply performs a single action without touching the version.
+func (gs *GameState) apply(msg Message) error {
+	switch msg.Action {
+	case "read_only", "expire":
+		if !msg.Trusted {
+			return ErrAdminOnly
+		}
+		if msg.Action == "expire" {
+			gs.expire(msg.Time())
+		} else {
+			gs.ReadOnly = msg.ReadOnly
+		}
+		return nil
+	}
+	if gs.ReadOnly {
+		return ErrMatchReadOnly
+	}
+	if gs.Finished && msg.Action != "reset" && msg.Action != "configure" && msg.Action != "new_game" && msg.Action != "new_series" && !(msg.Action == "declare_winner" && msg.Force) {
+		return ErrMatchFinished
+	}
+	if gs.NotStarted && !msg.Trusted && msg.Action != "start" {
+		return ErrNotStarted
+	}
+	switch msg.Action {
+	case "start":
+		gs.NotStarted = false
+	case "increment":
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return msg.noop(ErrUnknownTeam)
+		}
+		points, err := gs.Options.ValidPoints(msg.Worth())
+		if err != nil {
+			return err
+		}
+		score, err := gs.Options.increment(t.Score, points)
+		if err != nil {
+			return err
+		}
+		gs.remember(t)
+		t.Score = score
+		gs.checkWin(t, msg.Time())
+	case "decrement":
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return msg.noop(ErrUnknownTeam)
+		}
+		if t.Score == 0 {
+			return msg.noop(ErrScoreZero)
+		}
+		points, err := gs.Options.ValidPoints(msg.Worth())
+		if err != nil {
+			return err
+		}
+		gs.remember(t)
+		// Scores never go negative.
+		t.Score = max(gs.Options.round(t.Score-points), 0)
+	case "set":
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return ErrUnknownTeam
+		}
+		score, err := gs.Options.ValidScore(msg.Value)
+		if err != nil {
+			return err
+		}
+		gs.remember(t)
+		t.Score = score
+		gs.checkWin(t, msg.Time())
+	case "undo":
+		return gs.undo()
+	case "reset_arm":
+		gs.ResetArmedUntil = msg.Time().Add(gs.Options.ResetArmWindow)
+	case "reset":
+		if gs.Options.RequireResetArm && !msg.Trusted {
+			if msg.Time().After(gs.ResetArmedUntil) {
+				return ErrResetNotArmed
+			}
+			gs.ResetArmedUntil = time.Time{}
+		}
+		return gs.Reset(msg.Scope)
+	case "configure":
+		return gs.configure(msg.Preset)
+	case "finish":
+		gs.finish(msg.Time())
+	case "declare_winner":
+		return gs.declareWinner(msg.Team, msg.Time())
+	case "new_game":
+		return gs.newGame(msg.Time())
+	case "new_series":
+		gs.Series = SeriesJSON{}
+	case "correct":
+		return gs.correct(msg)
+	case "rename":
+		return gs.rename(msg.Team, msg.Name)
+	case "add_team":
+		return gs.addTeam(msg.Name)
+	case "teamset_add":
+		return gs.addTeamSet(msg.Lineup)
+	case "teamset_switch":
+		return gs.switchTeamSet(msg.Index)
+	case "level_increment":
+		return gs.levelIncrement(msg.Team, msg.Level, msg.Time())
+	case "foul_increment":
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return ErrUnknownTeam
+		}
+		t.Fouls++
+	case "foul_reset":
+		if msg.Team == "" {
+			gs.resetFouls()
+			break
+		}
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return ErrUnknownTeam
+		}
+		t.Fouls = 0
+	case "clock_start":
+		gs.Clock.Start(msg.Time())
+	case "clock_stop":
+		gs.Clock.stop(msg.Time(), gs.Options.PeriodLength)
+	case "clock_adjust":
+		gs.Clock.adjust(msg.Time(), time.Duration(msg.DeltaMs)*time.Millisecond, gs.Options.PeriodLength)
+	case "timeout_use":
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return ErrUnknownTeam
+		}
+		if t.TimeoutsLeft <= 0 {
+			return ErrNoTimeouts
+		}
+		t.TimeoutsLeft--
+		if gs.Options.TimeoutStopsClock {
+			gs.Clock.stop(msg.Time(), gs.Options.PeriodLength)
+		}
+	case "timeout_reset":
+		if msg.Team == "" {
+			gs.ResetTimeouts()
+			break
+		}
+		t := gs.Team(msg.Team)
+		if t == nil {
+			return ErrUnknownTeam
+		}
+		t.TimeoutsLeft = gs.Options.Timeouts
+	case "freeze":
+		gs.Frozen = true
+	case "unfreeze":
+		gs.Frozen = false
+	case "period_next":
+		gs.Period++
+		gs.Clock = Clock{}
+		if gs.Options.ResetFoulsOnPeriod {
+			gs.resetFouls()
+		}
+	default:
+		return msg.noop(ErrUnknownAction)
+	}
+	return nil
+}
+
+// noop returns err for an action that would do nothing, and nil for a
+// replayed one: event logs written before such actions were refused may
+// hold them, and must still replay to the versions they recorded.
+func (m Message) noop(err error) error {
+	if m.Replayed {
+		return nil
+	}
+	return err
+}
+
+// Reset scopes.
+const (
+	ResetAll   = "all"
+	ResetScore = "score"
+)
+
+// Reset zeroes the scores. The "all" scope also clears fouls, periods and
+// the clock and restores timeouts, starting a fresh game.
+func (gs *GameState) Reset(scope string) error {
+	switch scope {
+	case "", ResetAll:
+		gs.resetFouls()
+		gs.ResetTimeouts()
+		gs.Period = 1
+		gs.Clock = Clock{}
+	case ResetScore:
+	default:
+		return ErrInvalidScope
+	}
+	for i := range gs.Teams {
+		gs.Teams[i].Score = 0
+	}
+	gs.History = nil
+	gs.ResetLevels()
+	gs.Finished = false
+	gs.Winner = ""
+	return nil
+}
+
+// checkWin finishes the match at the time at once t reaches the score
+// ceiling of a match won on it (OnMaxWin).
+func (gs *GameState) checkWin(t *Team, at time.Time) {
+	if gs.Options.OnMax == OnMaxWin && gs.Options.ScoreMax > 0 && t.Score >= gs.Options.ceiling() {
+		gs.finish(at)
+	}
+}
+
+// finish ends the match at the time at, awarding it to the highest-scoring
+// team. A tie for the lead is recorded as a draw.
+func (gs *GameState) finish(at time.Time) {
+	gs.Clock.stop(at, gs.Options.PeriodLength)
+	gs.Finished = true
+	gs.Winner = ""
+	best := -1.0
+	for _, t := range gs.Teams {
+		switch {
+		case t.Score > best:
+			best = t.Score
+			gs.Winner = t.Name
+		case t.Score == best:
+			gs.Winner = ""
+		}
+	}
+}
+
+// declareWinner ends the match with the given winner regardless of score,
+// for forfeits and abandoned games. An empty team records a draw or no
+// contest. The clock stops at the time at.
+func (gs *GameState) declareWinner(team string, at time.Time) error {
+	if team != "" && gs.Team(team) == nil {
+		return ErrUnknownTeam
+	}
+	gs.Clock.stop(at, gs.Options.PeriodLength)
+	gs.Finished = true
+	gs.Winner = team
+	return nil
+}
+
+// ResetTimeouts restores every team's timeout allowance.
+func (gs *GameState) ResetTimeouts() {
+	for i := range gs.Teams {
+		gs.Teams[i].TimeoutsLeft = gs.Options.Timeouts
+	}
+}
+
+// resetFouls clears the foul count of every team.
+func (gs *GameState) resetFouls() {
+	for i := range gs.Teams {
+		gs.Teams[i].Fouls = 0
+	}
+}
+
+// addTeam appends a new team with no score, up to the team limit.
+func (gs *GameState) addTeam(name string) error {
+	if len(gs.Teams) >= gs.Options.TeamLimit() {
+		return fmt.Errorf("%w: at most %d teams", ErrTooManyTeams, gs.Options.TeamLimit())
+	}
+	name, err := ValidateTeamName(name)
+	if err != nil {
+		return err
+	}
+	if gs.Team(name) != nil {
+		return ErrTeamNameTaken
+	}
+	gs.Teams = append(gs.Teams, Team{Name: name, TimeoutsLeft: gs.Options.Timeouts})
+	gs.callPoints()
+	return nil
+}
+
+// rename changes a team's name, keeping its score.
+func (gs *GameState) rename(from, to string) error {
+	t := gs.Team(from)
+	if t == nil {
+		return ErrUnknownTeam
+	}
+	to, err := ValidateTeamName(to)
+	if err != nil {
+		return err
+	}
+	if other := gs.Team(to); other != nil && other != t {
+		return ErrTeamNameTaken
+	}
+	gs.Series.rename(t.Name, to)
+	gs.renameHistory(t.Name, to)
+	t.Name = to
+	return nil
+}
+
+// expire replaces the match with a fresh one created at now. Options and
+// the preset are kept; everything the old match accumulated goes.
+func (gs *GameState) expire(now time.Time) {
+	gs.Teams = DefaultTeams()
+	gs.ResetTimeouts()
+	gs.Period = 1
+	gs.Clock = Clock{}
+	gs.Finished = false
+	gs.Winner = ""
+	gs.ReadOnly = false
+	gs.Frozen = false
+	gs.Series = SeriesJSON{}
+	gs.Corrections = nil
+	gs.TeamSets, gs.ActiveSet = nil, 0
+	gs.ResetArmedUntil = time.Time{}
+	gs.CreatedAt = now
+}
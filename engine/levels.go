@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Errors for "level_increment".
+var (
+	// ErrNoLevels is returned when the match's preset has no score levels.
+	ErrNoLevels = errors.New("match has no score levels; configure a preset such as tennis")
+	// ErrInvalidLevel is returned for a level the preset doesn't score.
+	ErrInvalidLevel = errors.New(`level must be "points", "games" or "sets"`)
+)
+
+// LevelRules describes hierarchical scoring, where points win games, games
+// win sets and sets win the match, as in racquet sports and darts. A team's
+// Score mirrors its sets, so the flat winner logic still applies.
+type LevelRules struct {
+	// PointsPerGame is the points needed to win a game; 0 means games are
+	// awarded directly, as darts legs are.
+	PointsPerGame int `json:"pointsPerGame,omitempty"`
+	// GamesPerSet is the games needed to win a set.
+	GamesPerSet int `json:"gamesPerSet"`
+	// WinBy is the lead games and points must be won by; 0 means 1.
+	WinBy int `json:"winBy,omitempty"`
+	// TiebreakAt is the games all at which a single tiebreak game, to
+	// TiebreakPoints, decides the set; 0 means sets are played out.
+	TiebreakAt     int `json:"tiebreakAt,omitempty"`
+	TiebreakPoints int `json:"tiebreakPoints,omitempty"`
+	// SetsToWin is the sets needed to win the match; 0 leaves it open.
+	SetsToWin int `json:"setsToWin,omitempty"`
+	// TennisCalls calls points as 0, 15, 30, 40 and AD.
+	TennisCalls bool `json:"tennisCalls,omitempty"`
+}
+
+// winBy returns the winning margin.
+func (r *LevelRules) winBy() int {
+	return max(r.WinBy, 1)
+}
+
+// LevelScore is a team's position in a match with score levels.
+type LevelScore struct {
+	Sets   int `json:"sets"`
+	Games  int `json:"games"`
+	Points int `json:"points"`
+	// Call is the called score of the game, e.g. "30" or "AD", when the
+	// rules use tennis calls.
+	Call string `json:"call,omitempty"`
+	// SetGames lists the games the team won in each finished set.
+	SetGames []int `json:"setGames,omitempty"`
+}
+
+// clone returns a copy sharing nothing with l.
+func (l *LevelScore) clone() *LevelScore {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.SetGames = append([]int(nil), l.SetGames...)
+	return &c
+}
+
+// ResetLevels gives every team a fresh level score, or none when the match
+// has no levels.
+func (gs *GameState) ResetLevels() {
+	for i := range gs.Teams {
+		gs.Teams[i].Levels = nil
+		if gs.Options.Levels != nil {
+			gs.Teams[i].Levels = &LevelScore{}
+		}
+	}
+	gs.callPoints()
+}
+
+// ensureLevels gives a level score to any team without one, such as teams
+// of an imported state.
+func (gs *GameState) ensureLevels() {
+	for i := range gs.Teams {
+		if gs.Teams[i].Levels == nil {
+			gs.Teams[i].Levels = &LevelScore{}
+		}
+	}
+}
+
+// levelIncrement scores one point, game or set for the named team and rolls
+// the win up through the levels above it. A match won by it finishes at the
+// time at.
+func (gs *GameState) levelIncrement(team, level string, at time.Time) error {
+	r := gs.Options.Levels
+	if r == nil {
+		return ErrNoLevels
+	}
+	t := gs.Team(team)
+	if t == nil {
+		return ErrUnknownTeam
+	}
+	gs.ensureLevels()
+	switch {
+	case level == "points" && r.PointsPerGame > 0:
+		gs.winPoint(t, at)
+	case level == "games":
+		gs.winGame(t, at)
+	case level == "sets":
+		gs.winSet(t, at)
+	default:
+		return ErrInvalidLevel
+	}
+	gs.callPoints()
+	return nil
+}
+
+// tiebreak reports whether the game in play is a tiebreak.
+func (gs *GameState) tiebreak() bool {
+	r := gs.Options.Levels
+	if r.TiebreakAt == 0 {
+		return false
+	}
+	for _, t := range gs.Teams {
+		if t.Levels.Games != r.TiebreakAt {
+			return false
+		}
+	}
+	return true
+}
+
+// bestOther returns the highest value of level among the teams other than t.
+func (gs *GameState) bestOther(t *Team, level func(*LevelScore) int) int {
+	best := 0
+	for i := range gs.Teams {
+		if o := &gs.Teams[i]; o != t {
+			best = max(best, level(o.Levels))
+		}
+	}
+	return best
+}
+
+func (gs *GameState) winPoint(t *Team, at time.Time) {
+	r := gs.Options.Levels
+	target := r.PointsPerGame
+	if gs.tiebreak() {
+		target = r.TiebreakPoints
+	}
+	t.Levels.Points++
+	lead := t.Levels.Points - gs.bestOther(t, func(l *LevelScore) int { return l.Points })
+	if t.Levels.Points >= target && lead >= r.winBy() {
+		gs.winGame(t, at)
+	}
+}
+
+func (gs *GameState) winGame(t *Team, at time.Time) {
+	r := gs.Options.Levels
+	tiebreak := gs.tiebreak()
+	for i := range gs.Teams {
+		gs.Teams[i].Levels.Points = 0
+	}
+	t.Levels.Games++
+	lead := t.Levels.Games - gs.bestOther(t, func(l *LevelScore) int { return l.Games })
+	if tiebreak || t.Levels.Games >= r.GamesPerSet && lead >= r.winBy() {
+		gs.winSet(t, at)
+	}
+}
+
+func (gs *GameState) winSet(t *Team, at time.Time) {
+	r := gs.Options.Levels
+	for i := range gs.Teams {
+		l := gs.Teams[i].Levels
+		l.SetGames = append(l.SetGames, l.Games)
+		l.Games, l.Points = 0, 0
+	}
+	t.Levels.Sets++
+	t.Score = float64(t.Levels.Sets)
+	if r.SetsToWin > 0 && t.Levels.Sets >= r.SetsToWin {
+		gs.finish(at)
+	}
+}
+
+// tennisCalls are the calls for zero to three points.
+var tennisCalls = [...]string{"0", "15", "30", "40"}
+
+// callPoints updates each team's called score. Tiebreaks are called by
+// point count; from deuce on, the leader has the advantage.
+func (gs *GameState) callPoints() {
+	r := gs.Options.Levels
+	if r == nil || !r.TennisCalls {
+		return
+	}
+	gs.ensureLevels()
+	tiebreak := gs.tiebreak()
+	for i := range gs.Teams {
+		t := &gs.Teams[i]
+		p := t.Levels.Points
+		other := gs.bestOther(t, func(l *LevelScore) int { return l.Points })
+		switch {
+		case tiebreak:
+			t.Levels.Call = strconv.Itoa(p)
+		case p >= 3 && other >= 3:
+			t.Levels.Call = "40"
+			if p > other {
+				t.Levels.Call = "AD"
+			}
+		default:
+			t.Levels.Call = tennisCalls[min(p, 3)]
+		}
+	}
+}
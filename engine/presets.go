@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrUnknownPreset is returned by "configure" for a preset not in presets.
+var ErrUnknownPreset = errors.New("unknown preset")
+
+// Preset is a named sport layout: how a match is won, how it is divided into
+// periods and what a single scoring action is worth.
+type Preset struct {
+	// ScoreMax and OnMax set the score ceiling, as in MatchOptions.
+	ScoreMax int
+	OnMax    string
+	// Periods is the number of regulation periods; 0 means open-ended.
+	Periods int
+	// PeriodLength is the clock time of one period; 0 means untimed.
+	PeriodLength time.Duration
+	// Points lists the values an increment may carry, e.g. 1, 2 and 3 in
+	// basketball. Empty means every increment is worth one point.
+	Points             []int
+	ResetFoulsOnPeriod bool
+	// Timeouts is each team's timeout allowance; 0 disables tracking.
+	Timeouts int
+	// Levels enables hierarchical scoring with "level_increment".
+	Levels *LevelRules
+}
+
+// Presets is the sport-preset registry, keyed by the name sent in
+// "configure".
+var Presets = map[string]Preset{
+	"basketball": {Periods: 4, PeriodLength: 10 * time.Minute, Points: []int{1, 2, 3}, ResetFoulsOnPeriod: true, Timeouts: 5},
+	"football":   {Periods: 2, PeriodLength: 45 * time.Minute},
+	"hockey":     {Periods: 3, PeriodLength: 20 * time.Minute},
+	"volleyball": {ScoreMax: 25, OnMax: OnMaxReject, Periods: 5, Timeouts: 2},
+	"handball":   {Periods: 2, PeriodLength: 30 * time.Minute},
+	"tennis": {Levels: &LevelRules{PointsPerGame: 4, GamesPerSet: 6, WinBy: 2,
+		TiebreakAt: 6, TiebreakPoints: 7, SetsToWin: 2, TennisCalls: true}},
+	// Darts legs are scored as games: first to three legs takes the set.
+	"darts": {Levels: &LevelRules{GamesPerSet: 3, SetsToWin: 3}},
+}
+
+// matchConfig is the wire form of the active preset, broadcast with the
+// state so overlays can relayout.
+type matchConfig struct {
+	Preset          string `json:"preset"`
+	ScoreMax        int    `json:"scoreMax,omitempty"`
+	Periods         int    `json:"periods,omitempty"`
+	PeriodLengthSec int    `json:"periodLengthSec,omitempty"`
+	Points          []int  `json:"points,omitempty"`
+	// Levels is set for presets that score in sets, games and points.
+	Levels *LevelRules `json:"levels,omitempty"`
+}
+
+// configure switches the match to the named preset and starts a fresh game.
+// Server-level options such as reset arming and broadcast limits are kept.
+func (gs *GameState) configure(name string) error {
+	p, ok := Presets[name]
+	if !ok {
+		return ErrUnknownPreset
+	}
+	gs.Options.UsePreset(name, p)
+	return gs.Reset(ResetAll)
+}
+
+// UsePreset copies the preset's scoring rules into o.
+func (o *MatchOptions) UsePreset(name string, p Preset) {
+	o.Preset = name
+	o.ScoreMax = p.ScoreMax
+	o.OnMax = p.OnMax
+	o.Periods = p.Periods
+	o.PeriodLength = p.PeriodLength
+	o.Points = p.Points
+	o.ResetFoulsOnPeriod = p.ResetFoulsOnPeriod
+	o.Timeouts = p.Timeouts
+	o.Levels = p.Levels
+}
+
+// ValidPoints returns what an increment or decrement carrying value is
+// worth. A zero value means the default Step; with a preset, other values
+// must be listed in its Points.
+func (o MatchOptions) ValidPoints(value float64) (float64, error) {
+	if value == 0 {
+		return float64(max(o.Step, 1)), nil
+	}
+	if value < 0 || !o.exact(value) {
+		return 0, ErrInvalidValue
+	}
+	if len(o.Points) > 0 && !slices.ContainsFunc(o.Points, func(p int) bool { return float64(p) == value }) {
+		return 0, ErrInvalidValue
+	}
+	return o.round(value), nil
+}
+
+// Config returns the wire form of the active preset, or nil if none was
+// configured.
+func (o MatchOptions) Config() *matchConfig {
+	if o.Preset == "" {
+		return nil
+	}
+	return &matchConfig{
+		Preset:          o.Preset,
+		ScoreMax:        o.ScoreMax,
+		Periods:         o.Periods,
+		PeriodLengthSec: int(o.PeriodLength / time.Second),
+		Points:          o.Points,
+		Levels:          o.Levels,
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import "encoding/json"
+
+// stringScoreTeam and stringCompactTeam encode a team with its score as a
+// JSON string, for MatchOptions.StringScores.
+type stringScoreTeam struct {
+	Name         string      `json:"name"`
+	Score        float64     `json:"score,string"`
+	Color        string      `json:"color,omitempty"`
+	Fouls        int         `json:"fouls,omitempty"`
+	TimeoutsLeft int         `json:"timeoutsLeft,omitempty"`
+	Display      *float64    `json:"display,omitempty"`
+	Levels       *LevelScore `json:"levels,omitempty"`
+}
+
+type stringCompactTeam struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score,string"`
+}
+
+// MarshalJSON implements json.Marshaler, quoting scores when the match
+// asks for string scores.
+func (s StateJSON) MarshalJSON() ([]byte, error) {
+	type plain StateJSON
+	if !s.stringScores {
+		return json.Marshal(plain(s))
+	}
+	teams := make([]stringScoreTeam, len(s.Teams))
+	for i, t := range s.Teams {
+		teams[i] = stringScoreTeam(t)
+	}
+	return json.Marshal(struct {
+		Teams []stringScoreTeam `json:"teams"`
+		plain
+	}{teams, plain(s)})
+}
+
+// stringCompactState quotes the scores of a compact state.
+func stringCompactState(c compactStateJSON) any {
+	teams := make([]stringCompactTeam, len(c.Teams))
+	for i, t := range c.Teams {
+		teams[i] = stringCompactTeam(t)
+	}
+	return struct {
+		Teams   []stringCompactTeam `json:"teams"`
+		Version uint64              `json:"version"`
+		Compact bool                `json:"compact"`
+	}{teams, c.Version, c.Compact}
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting a score given as a
+// number or, as string-score matches send it, a string.
+func (t *Team) UnmarshalJSON(data []byte) error {
+	type plain Team
+	v := struct {
+		*plain
+		Score json.Number `json:"score"`
+	}{plain: (*plain)(t)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Score == "" {
+		return nil
+	}
+	score, err := v.Score.Float64()
+	if err != nil {
+		return err
+	}
+	t.Score = score
+	return nil
+}
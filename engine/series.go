@@ -0,0 +1,114 @@
+package engine
+
+import "time"
+
+// SeriesJSON is the running tally of a series of games between the same
+// teams, kept across "new_game" and broadcast with the state as "series",
+// e.g. for best-of-N formats. "new_series" clears it.
+type SeriesJSON struct {
+	// Games counts finalized games; Draws those without a winner.
+	Games int `json:"games"`
+	Draws int `json:"draws,omitempty"`
+	// Teams holds each team's wins and current winning streak, in the
+	// order the teams first appeared in the series.
+	Teams []SeriesTeam `json:"teams"`
+	// HeadToHead records, for each pairing that has produced a result,
+	// how often Team beat Opponent.
+	HeadToHead []headToHead `json:"headToHead,omitempty"`
+}
+
+type SeriesTeam struct {
+	Name   string `json:"name"`
+	Wins   int    `json:"wins"`
+	Streak int    `json:"streak,omitempty"`
+}
+
+type headToHead struct {
+	Team     string `json:"team"`
+	Opponent string `json:"opponent"`
+	Wins     int    `json:"wins"`
+}
+
+// clone returns a copy sharing no slices with s.
+func (s SeriesJSON) clone() SeriesJSON {
+	s.Teams = append([]SeriesTeam(nil), s.Teams...)
+	s.HeadToHead = append([]headToHead(nil), s.HeadToHead...)
+	return s
+}
+
+// team returns the named team's record, adding it if it's new.
+func (s *SeriesJSON) team(name string) *SeriesTeam {
+	for i := range s.Teams {
+		if s.Teams[i].Name == name {
+			return &s.Teams[i]
+		}
+	}
+	s.Teams = append(s.Teams, SeriesTeam{Name: name})
+	return &s.Teams[len(s.Teams)-1]
+}
+
+// record credits one finished game between teams to the tally. An empty
+// winner is a draw, which ends every streak.
+func (s *SeriesJSON) record(teams []Team, winner string) {
+	s.Games++
+	if winner == "" {
+		s.Draws++
+	}
+	for _, t := range teams {
+		rec := s.team(t.Name)
+		if t.Name != winner {
+			rec.Streak = 0
+			continue
+		}
+		rec.Wins++
+		rec.Streak++
+	}
+	if winner == "" {
+		return
+	}
+	for _, t := range teams {
+		if t.Name != winner {
+			s.beat(winner, t.Name)
+		}
+	}
+}
+
+// beat counts a win of team over opponent.
+func (s *SeriesJSON) beat(team, opponent string) {
+	for i := range s.HeadToHead {
+		if h := &s.HeadToHead[i]; h.Team == team && h.Opponent == opponent {
+			h.Wins++
+			return
+		}
+	}
+	s.HeadToHead = append(s.HeadToHead, headToHead{Team: team, Opponent: opponent, Wins: 1})
+}
+
+// rename carries a renamed team's record over to its new name.
+func (s *SeriesJSON) rename(from, to string) {
+	for i := range s.Teams {
+		if s.Teams[i].Name == from {
+			s.Teams[i].Name = to
+		}
+	}
+	for i := range s.HeadToHead {
+		h := &s.HeadToHead[i]
+		if h.Team == from {
+			h.Team = to
+		}
+		if h.Opponent == from {
+			h.Opponent = to
+		}
+	}
+}
+
+// newGame finalizes the current game, crediting its result to the series,
+// and starts a fresh one. A game still in progress is finished first, at
+// the time at, so the highest score wins it.
+func (gs *GameState) newGame(at time.Time) error {
+	if !gs.Finished {
+		gs.finish(at)
+	}
+	gs.Series.record(gs.Teams, gs.Winner)
+	return gs.Reset(ResetAll)
+}
@@ -0,0 +1,56 @@
+package engine
+
+import "errors"
+
+// ErrUnknownTeamSet is returned when switching to a team set that wasn't
+// registered.
+var ErrUnknownTeamSet = errors.New("unknown team set")
+
+// addTeamSet registers another line-up (names and colors) for the board.
+// The first registration also saves the current teams as set 0, so the
+// board can always switch back to where it started.
+func (gs *GameState) addTeamSet(lineup []Team) error {
+	if len(lineup) == 0 || len(lineup) > gs.Options.TeamLimit() {
+		return ErrTooManyTeams
+	}
+	set := make([]Team, len(lineup))
+	seen := make(map[string]bool, len(lineup))
+	for i, t := range lineup {
+		name, err := ValidateTeamName(t.Name)
+		if err != nil {
+			return err
+		}
+		if seen[name] {
+			return ErrTeamNameTaken
+		}
+		seen[name] = true
+		set[i] = Team{Name: name, Color: t.Color}
+	}
+	if len(gs.TeamSets) == 0 {
+		gs.TeamSets = [][]Team{lineupOf(gs.Teams)}
+	}
+	gs.TeamSets = append(gs.TeamSets, set)
+	return nil
+}
+
+// switchTeamSet makes the registered set at index the active teams and
+// starts a fresh game. Renames on the outgoing set are kept for when it
+// comes back.
+func (gs *GameState) switchTeamSet(index int) error {
+	if index < 0 || index >= len(gs.TeamSets) {
+		return ErrUnknownTeamSet
+	}
+	gs.TeamSets[gs.ActiveSet] = lineupOf(gs.Teams)
+	gs.Teams = lineupOf(gs.TeamSets[index])
+	gs.ActiveSet = index
+	return gs.Reset(ResetAll)
+}
+
+// lineupOf returns the names and colors of teams, without any scoring.
+func lineupOf(teams []Team) []Team {
+	lineup := make([]Team, len(teams))
+	for i, t := range teams {
+		lineup[i] = Team{Name: t.Name, Color: t.Color}
+	}
+	return lineup
+}
@@ -0,0 +1,55 @@
+package engine
+
+import "errors"
+
+// undoHistory bounds the score changes a match remembers for "undo"; older
+// ones can only be fixed with a "correct".
+const undoHistory = 50
+
+// ErrNothingToUndo is returned for an "undo" with no score change left to
+// revert.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// scoreChange is a team's score before an action changed it.
+type scoreChange struct {
+	team   string
+	before float64
+}
+
+// remember records t's score before an "increment", "decrement" or "set"
+// changes it, forgetting the oldest change past undoHistory.
+func (gs *GameState) remember(t *Team) {
+	if len(gs.History) == undoHistory {
+		gs.History = append(gs.History[:0], gs.History[1:]...)
+	}
+	gs.History = append(gs.History, scoreChange{team: t.Name, before: t.Score})
+}
+
+// undo reverts the latest remembered score change, putting the team back on
+// the score it had before. Resets and corrections start the history over,
+// as reverting past them would undo them too. Score levels aren't covered.
+// The history lives in memory: a snapshot restore starts it empty, while
+// the event log rebuilds it on replay.
+func (gs *GameState) undo() error {
+	n := len(gs.History)
+	if n == 0 {
+		return ErrNothingToUndo
+	}
+	change := gs.History[n-1]
+	t := gs.Team(change.team)
+	if t == nil {
+		return ErrUnknownTeam
+	}
+	t.Score = change.before
+	gs.History = gs.History[:n-1]
+	return nil
+}
+
+// renameHistory follows a team rename in the remembered score changes.
+func (gs *GameState) renameHistory(from, to string) {
+	for i := range gs.History {
+		if gs.History[i].team == from {
+			gs.History[i].team = to
+		}
+	}
+}
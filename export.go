@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"livescore/engine"
+)
+
+// exportFormat versions the match export document. Imports of any other
+// format are refused rather than guessed at.
+const exportFormat = 1
+
+// maxImportBytes bounds an import body.
+const maxImportBytes = 16 << 20
+
+// matchExport is a complete, portable copy of a match: its state, including
+// the period clock, and its retained event log. GET
+// /admin/matches/{id}/export produces one and POST /admin/import loads it,
+// for backups and for moving a game between servers.
+type matchExport struct {
+	Format     int             `json:"format"`
+	Match      string          `json:"match"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	State      StateJSON       `json:"state"`
+	Events     []archivedEvent `json:"events"`
+}
+
+// serveExport writes a match export.
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	gs, ok := lookupMatch(scopedMatchID(r, id))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	gs.mu.Lock()
+	export := matchExport{
+		Format:     exportFormat,
+		Match:      id,
+		ExportedAt: time.Now(),
+		State:      gs.Wire(gs.Teams),
+		Events:     append([]archivedEvent{}, gs.events...),
+	}
+	export.State.Config = gs.Options.Config()
+	gs.mu.Unlock()
+	for i := range export.State.Teams {
+		export.State.Teams[i].Display = nil // derived, recomputed on import
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.json"`)
+	json.NewEncoder(w).Encode(export)
+}
+
+// validate lists every problem that keeps e from being loaded under
+// options. Scores are checked against the board's current rules, or the
+// exported preset's when it names one.
+func (e *matchExport) validate(options MatchOptions) []string {
+	var problems []string
+	addf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if e.Format != exportFormat {
+		addf("format %d is not supported (want %d)", e.Format, exportFormat)
+	}
+	if e.Match != "" && e.Match != defaultMatchID {
+		addf("match %q is not hosted here (this server hosts %q)", e.Match, defaultMatchID)
+	}
+
+	s := &e.State
+	if cfg := s.Config; cfg != nil {
+		if p, ok := engine.Presets[cfg.Preset]; ok {
+			options.UsePreset(cfg.Preset, p)
+		} else {
+			addf("state.config: unknown preset %q", cfg.Preset)
+		}
+	}
+	if len(s.Teams) == 0 {
+		addf("state.teams: a match needs at least one team")
+	}
+	if len(s.Teams) > options.TeamLimit() {
+		addf("state.teams: %d teams exceeds the limit of %d", len(s.Teams), options.TeamLimit())
+	}
+	seen := make(map[string]bool, len(s.Teams))
+	for i, t := range s.Teams {
+		name, err := engine.ValidateTeamName(t.Name)
+		switch {
+		case err != nil:
+			addf("state.teams[%d]: %v %q", i, err, t.Name)
+		case name != t.Name:
+			addf("state.teams[%d]: name %q has surrounding spaces", i, t.Name)
+		case seen[name]:
+			addf("state.teams[%d]: %v: %q", i, engine.ErrTeamNameTaken, name)
+		}
+		seen[name] = true
+		if _, err := options.ValidScore(t.Score); err != nil {
+			addf("state.teams[%d]: %v %v", i, err, t.Score)
+		}
+		if t.Fouls < 0 {
+			addf("state.teams[%d]: negative fouls", i)
+		}
+		if t.TimeoutsLeft < 0 {
+			addf("state.teams[%d]: negative timeoutsLeft", i)
+		}
+	}
+	if s.Period < 1 {
+		addf("state.period: must be at least 1, got %d", s.Period)
+	}
+	if s.ElapsedMs < 0 {
+		addf("state.elapsedMs: must not be negative")
+	}
+	if s.Winner != "" {
+		if !s.Finished {
+			addf("state.winner: set on a match that isn't finished")
+		}
+		if !seen[s.Winner] {
+			addf("state.winner: %q is not one of the teams", s.Winner)
+		}
+	}
+
+	// The log must be the contiguous run of versions leading up to the
+	// state, as /diff relies on one event per version.
+	if n := len(e.Events); n > 0 {
+		first := e.Events[0].Version
+		if first == 0 {
+			addf("events[0]: version must be at least 1")
+		}
+		for i, ev := range e.Events {
+			if want := first + uint64(i); ev.Version != want {
+				addf("events[%d]: version %d breaks the sequence (want %d)", i, ev.Version, want)
+				break
+			}
+		}
+		for i, ev := range e.Events {
+			if ev.Message.Action == "" {
+				addf("events[%d]: missing action", i)
+			}
+			if i > 0 && ev.At.Before(e.Events[i-1].At) {
+				addf("events[%d]: timestamp goes back in time", i)
+			}
+		}
+		if last := e.Events[n-1].Version; last != s.Version {
+			addf("events: last version %d does not match state.version %d", last, s.Version)
+		}
+	}
+	return problems
+}
+
+// load replaces the live match in gs with the export. The clock resumes
+// from where it was exported. The caller must hold gs.mu.
+func (e *matchExport) load(gs *GameState) {
+	s := e.State
+	if cfg := s.Config; cfg != nil {
+		gs.Options.UsePreset(cfg.Preset, engine.Presets[cfg.Preset])
+	}
+	gs.Teams = s.Teams
+	for i := range gs.Teams {
+		gs.Teams[i].Display = nil
+	}
+	gs.Period = s.Period
+	gs.Version = s.Version
+	gs.Finished = s.Finished
+	gs.Winner = s.Winner
+	gs.ReadOnly = s.ReadOnly
+	gs.Series = engine.SeriesJSON{}
+	if s.Series != nil {
+		gs.Series = *s.Series
+	}
+	gs.StartsAt, gs.EndsAt = time.Time{}, time.Time{}
+	if s.StartsAt != nil {
+		gs.StartsAt = *s.StartsAt
+	}
+	if s.EndsAt != nil {
+		gs.EndsAt = *s.EndsAt
+	}
+	gs.NotStarted = s.NotStarted
+	gs.CreatedAt = time.Now()
+	if s.CreatedAt != nil {
+		gs.CreatedAt = *s.CreatedAt
+	}
+	gs.Clock = engine.Clock{Elapsed: time.Duration(s.ElapsedMs) * time.Millisecond}
+	if s.ClockRunning {
+		gs.Clock.Start(time.Now())
+	}
+	gs.events, gs.segments = e.Events, nil
+	gs.trimEvents(true)
+	// Side state of the replaced game doesn't carry over.
+	gs.Corrections = nil
+	gs.TeamSets, gs.ActiveSet = nil, 0
+	gs.ResetArmedUntil = time.Time{}
+	gs.History = nil
+}
+
+// importSummary reports the match an import loaded.
+type importSummary struct {
+	Match   string `json:"match"`
+	Version uint64 `json:"version"`
+	Events  int    `json:"events"`
+}
+
+// serveImport loads a match export, replacing the live board and
+// broadcasting it to every client. Versions only move forward, so the
+// export must be newer than the live match; import onto a fresh server or
+// after the board has been retired. Malformed exports are refused with a
+// list of every problem found.
+func serveImport(w http.ResponseWriter, r *http.Request) {
+	var export matchExport
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&export); err != nil {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "invalid export: "+err.Error())
+		return
+	}
+
+	gameState.mu.Lock()
+	if problems := export.validate(gameState.Options); len(problems) > 0 {
+		gameState.mu.Unlock()
+		writeValidationError(w, "invalid export", problems)
+		return
+	}
+	if gameState.Frozen {
+		gameState.mu.Unlock()
+		writeJSONError(w, http.StatusConflict, CodeConflict, "match is frozen")
+		return
+	}
+	if export.State.Version <= gameState.Version {
+		live := gameState.Version
+		gameState.mu.Unlock()
+		writeJSONError(w, http.StatusConflict, CodeConflict,
+			fmt.Sprintf("export version %d is not newer than the live match (version %d)", export.State.Version, live))
+		return
+	}
+	if err := persist.checkReplace(export.Events); err != nil {
+		gameState.mu.Unlock()
+		writeJSONError(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	export.load(&gameState)
+	if err := persist.replace(&gameState, export.Events); err != nil {
+		log.Printf("persist: saving import: %v", err)
+	}
+	state := gameState.clone()
+	payload := state.broadcastPayload()
+	gameState.lastBroadcast = payload
+	gameState.lastShown = state
+	gameState.lastFingerprint = nil
+	config := gameState.configChange()
+	gameState.mu.Unlock()
+
+	if config != nil {
+		hub.broadcast(config, nil, time.Time{})
+	}
+	if payload != nil {
+		publishState(&gameState, state, payload)
+		hub.broadcast(payload, state, time.Time{})
+	} else {
+		publicScore.invalidate()
+	}
+	log.Printf("Imported match %s at version %d with %d events", defaultMatchID, state.Version, len(export.Events))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(importSummary{Match: defaultMatchID, Version: state.Version, Events: len(export.Events)})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// FeedSource supplies authoritative score updates from an external system.
+// While a feed is configured, controller actions are rejected.
+type FeedSource interface {
+	// Fetch returns the actions that bring the board in line with the feed.
+	Fetch(ctx context.Context) ([]Message, error)
+}
+
+// feed is the active external feed, or nil when scores are kept manually.
+// It is configured by FEED_URL.
+var feed = feedFromEnv()
+
+// feedFromEnv returns an HTTPFeed for FEED_URL, or nil if it is unset.
+func feedFromEnv() FeedSource {
+	url := envString("FEED_URL", "")
+	if url == "" {
+		return nil
+	}
+	return &HTTPFeed{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// HTTPFeed polls a URL serving {"teams":[{"name":"A","score":3},...]}. Teams
+// are matched to the board by name.
+type HTTPFeed struct {
+	URL    string
+	Client *http.Client
+}
+
+// Fetch implements FeedSource.
+func (f *HTTPFeed) Fetch(ctx context.Context) ([]Message, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	var body struct {
+		Teams []Team `json:"teams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding feed: %w", err)
+	}
+	msgs := make([]Message, 0, len(body.Teams))
+	for _, t := range body.Teams {
+		msgs = append(msgs, Message{Action: "set", Team: t.Name, Value: t.Score, Actor: "feed", Trusted: true})
+	}
+	return msgs, nil
+}
+
+// runFeed polls source every interval and applies its updates until ctx is
+// cancelled.
+func runFeed(ctx context.Context, source FeedSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		msgs, err := source.Fetch(ctx)
+		if err != nil {
+			log.Printf("feed error: %v", err)
+		} else if len(msgs) > 0 {
+			if err := applyAndBroadcast(msgs...); err != nil {
+				log.Printf("feed update rejected: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
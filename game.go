@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"livescore/engine"
+)
+
+// The scoring rules live in package engine; the server serves its types
+// under the same names.
+type (
+	Message      = engine.Message
+	Team         = engine.Team
+	MatchOptions = engine.MatchOptions
+	StateJSON    = engine.StateJSON
+)
+
+// GameState is a match as the server runs it: the engine's state, guarded
+// by mu, and what the server keeps about the match besides.
+type GameState struct {
+	mu sync.Mutex
+	engine.GameState
+
+	// id is the match ID of a room; it is empty for the default match.
+	id string
+	// setup is the setup a room opened with, resolved against the default
+	// match's options; zero for the default match.
+	setup roomSetup
+	// scores caches a room's state for /score; the default match uses
+	// publicScore.
+	scores *scoreCache
+	// lastBroadcast is the most recent state sent to clients, lastShown
+	// the state it encodes, and lastFingerprint its fingerprint when
+	// dedupBroadcasts is on.
+	lastBroadcast   []byte
+	lastShown       *GameState
+	lastFingerprint []byte
+	// lastLayout is the encoded layout of the last config message
+	// broadcast.
+	lastLayout []byte
+	// events is the log of recent actions, one per version, oldest first.
+	// See eventRetention.
+	events []archivedEvent
+	// segments name the archive segments holding the events trimmed from
+	// the log since the match last finished; see trimEvents.
+	segments []string
+}
+
+// clone returns a private copy of the state for use outside the lock. The
+// caller must hold gs.mu.
+func (gs *GameState) clone() *GameState {
+	return &GameState{id: gs.id, GameState: *gs.GameState.Clone()}
+}
+
+// snapshot returns the state a newly joined client should see. The caller
+// must hold gs.mu.
+func (gs *GameState) snapshot() []byte {
+	if gs.Frozen && gs.Options.SnapshotLastWhileFrozen && gs.lastBroadcast != nil {
+		return gs.lastBroadcast
+	}
+	state, _ := json.Marshal(gs)
+	return state
+}
+
+// joinState returns the state a newly joined client should see, as
+// snapshot encodes it: gs itself, or the last broadcast state during a
+// freeze with SnapshotLastWhileFrozen set. The caller must hold gs.mu.
+func (gs *GameState) joinState() *GameState {
+	if gs.Frozen && gs.Options.SnapshotLastWhileFrozen && gs.lastShown != nil {
+		return gs.lastShown
+	}
+	return gs
+}
+
+// visible returns the state connected clients see: a copy of the live
+// state, or while frozen the last one broadcast, so the edits being
+// composed stay hidden. The caller must hold gs.mu.
+func (gs *GameState) visible() *GameState {
+	if gs.Frozen && gs.lastShown != nil {
+		return gs.lastShown
+	}
+	return gs.clone()
+}
+
+// applyAction mutates the game state according to msg and bumps its version.
+// The caller must hold gs.mu. On error the state is left unchanged.
+func applyAction(gs *GameState, msg Message) error {
+	if err := gs.Apply(msg); err != nil {
+		return err
+	}
+	gs.observeScoreInterval(msg)
+	return nil
+}
+
+// teamNames are the teams a new match starts with, from TEAMS as a
+// comma-separated list, e.g. "Lakers,Celtics".
+var teamNames = teamNamesFromEnv()
+
+func teamNamesFromEnv() []string {
+	names, err := engine.ParseTeamNames(envString("TEAMS", "A,B"))
+	if err != nil {
+		log.Printf("invalid TEAMS: %v, using A and B", err)
+		return []string{"A", "B"}
+	}
+	return names
+}
+
+// The engine encodes every match with the server's settings.
+func init() {
+	engine.TeamNames = teamNames
+	engine.Display = displayScore
+	engine.SendConfig = stateConfig
+	engine.SendCreatedAt = matchMaxLifetime > 0
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"livescore/engine"
+)
+
+func TestScoreCeiling(t *testing.T) {
+	for _, tc := range []struct {
+		onMax string
+		// step is the second increment, from a score of 2.
+		step float64
+		want float64
+		err  string
+	}{
+		{engine.OnMaxCap, 2, 3, ""},
+		{engine.OnMaxWrap, 2, 0, ""},
+		// 5 wraps to 1 on a counter of 0 to 3.
+		{engine.OnMaxWrap, 3, 1, ""},
+		{engine.OnMaxReject, 2, 2, engine.ErrScoreMax.Error()},
+	} {
+		t.Run(fmt.Sprintf("%s+%v", tc.onMax, tc.step), func(t *testing.T) {
+			withOptions(t, func(o *MatchOptions) { o.ScoreMax, o.OnMax = 3, tc.onMax })
+			srv := newTestServer(t)
+
+			conn := dialControl(t, srv, "")
+			readState(t, conn)
+			send(t, conn, Message{Action: "increment", Team: "A", Value: 2})
+			readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+			send(t, conn, Message{Action: "increment", Team: "A", Value: tc.step})
+			if tc.err != "" {
+				if text := readError(t, conn); !strings.Contains(text, tc.err) {
+					t.Errorf("error = %q, want %q", text, tc.err)
+				}
+			} else {
+				readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 2 })
+			}
+			if got := scoreOf(&gameState, "A"); got != tc.want {
+				t.Errorf("A = %v past the ceiling, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenameKeepsScoreAndRefusesCollision(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 2})
+	send(t, conn, Message{Action: "rename", Team: "A", Name: "Lions"})
+	s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 2 })
+	if score(t, s, "Lions") != 2 {
+		t.Errorf("Lions = %v after the rename, want A's 2", score(t, s, "Lions"))
+	}
+
+	send(t, conn, Message{Action: "rename", Team: "Lions", Name: "B"})
+	if text := readError(t, conn); text != engine.ErrTeamNameTaken.Error() {
+		t.Errorf("colliding rename: %q, want %q", text, engine.ErrTeamNameTaken)
+	}
+	// History follows the rename, so the increment can still be undone.
+	send(t, conn, Message{Action: "undo"})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 3 }); score(t, s, "Lions") != 0 || score(t, s, "B") != 0 {
+		t.Errorf("after undo: %+v, want Lions and B at 0", s.Teams)
+	}
+}
+
+func TestFoulsResetAtPeriodBoundary(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reset=%t", reset), func(t *testing.T) {
+			withOptions(t, func(o *MatchOptions) { o.ResetFoulsOnPeriod = reset })
+			srv := newTestServer(t)
+
+			conn := dialControl(t, srv, "")
+			readState(t, conn)
+			send(t, conn, Message{Action: "foul_increment", Team: "A"})
+			send(t, conn, Message{Action: "foul_increment", Team: "A"})
+			send(t, conn, Message{Action: "increment", Team: "A"})
+			s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 3 })
+			if fouls := s.Teams[0].Fouls; fouls != 2 {
+				t.Fatalf("A fouls = %d, want 2", fouls)
+			}
+
+			send(t, conn, Message{Action: "period_next"})
+			s = readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 4 })
+			want := 2
+			if reset {
+				want = 0
+			}
+			if fouls := s.Teams[0].Fouls; fouls != want || s.Period != 2 {
+				t.Errorf("period %d with A fouls %d, want period 2 with %d", s.Period, fouls, want)
+			}
+			if score(t, s, "A") != 1 {
+				t.Errorf("A = %v, want the score kept", score(t, s, "A"))
+			}
+		})
+	}
+}
+
+func TestStateMarshalsByteStable(t *testing.T) {
+	gs := &GameState{GameState: engine.GameState{Teams: engine.TeamsNamed([]string{"Lions", "Tigers", "Bears", "Wolves"}), Period: 2, Options: baseOptions}}
+	gs.Teams[1].Score, gs.Teams[2].Fouls = 3, 1
+	filter := map[string]bool{"Wolves": true, "Lions": true, "Bears": true}
+
+	first, err := json.Marshal(gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstFiltered := gs.MarshalFiltered(filter)
+	for i := 0; i < 20; i++ {
+		if again, _ := json.Marshal(gs); !bytes.Equal(again, first) {
+			t.Fatalf("marshal %d differs:\n%s\n%s", i, again, first)
+		}
+		if again := gs.MarshalFiltered(filter); !bytes.Equal(again, firstFiltered) {
+			t.Fatalf("filtered marshal %d differs:\n%s\n%s", i, again, firstFiltered)
+		}
+	}
+}
+
+func TestResetScopes(t *testing.T) {
+	for _, tc := range []struct {
+		scope  string
+		period int
+		fouls  int
+	}{
+		{engine.ResetScore, 2, 1},
+		{engine.ResetAll, 1, 0},
+		{"", 1, 0},
+	} {
+		t.Run("scope="+tc.scope, func(t *testing.T) {
+			srv := newTestServer(t)
+
+			conn := dialControl(t, srv, "")
+			readState(t, conn)
+			for _, msg := range []Message{
+				{Action: "increment", Team: "A", Value: 3},
+				{Action: "foul_increment", Team: "B"},
+				{Action: "period_next"},
+				{Action: "reset", Scope: tc.scope},
+			} {
+				send(t, conn, msg)
+			}
+			s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 4 })
+			if score(t, s, "A") != 0 {
+				t.Errorf("A = %v after the reset, want 0", score(t, s, "A"))
+			}
+			if s.Period != tc.period || s.Teams[1].Fouls != tc.fouls {
+				t.Errorf("period %d with B fouls %d, want period %d with %d", s.Period, s.Teams[1].Fouls, tc.period, tc.fouls)
+			}
+		})
+	}
+
+	srv := newTestServer(t)
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "reset", Scope: "everything"})
+	if text := readError(t, conn); text != engine.ErrInvalidScope.Error() {
+		t.Errorf("unknown scope: %q, want %q", text, engine.ErrInvalidScope)
+	}
+}
+
+func TestDecrementMirrorsStepAndClampsAtZero(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.Step = 2 })
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	steps := []struct {
+		msg  Message
+		want float64
+	}{
+		{Message{Action: "increment", Team: "A"}, 2},
+		{Message{Action: "increment", Team: "A", Value: 3}, 5},
+		{Message{Action: "decrement", Team: "A"}, 3},
+		// Crossing zero clamps rather than going negative.
+		{Message{Action: "decrement", Team: "A", Value: 5}, 0},
+	}
+	for i, step := range steps {
+		send(t, conn, step.msg)
+		s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == uint64(i+1) })
+		if score(t, s, "A") != step.want {
+			t.Errorf("after %+v: A = %v, want %v", step.msg, score(t, s, "A"), step.want)
+		}
+	}
+	send(t, conn, Message{Action: "decrement", Team: "A"})
+	if text := readError(t, conn); text != engine.ErrScoreZero.Error() {
+		t.Errorf("decrement at zero: %q, want %q", text, engine.ErrScoreZero)
+	}
+}
+
+func TestStringScoresEncodeAsJSONStrings(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.StringScores, o.ScoreMax = true, 0 })
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: engine.MaxExactScore})
+	f := readFrame(t, conn, func(f frame) bool { return f.isState() && f["version"] == 1.0 })
+	teams := f["teams"].([]any)
+	if got := teams[0].(map[string]any)["score"]; got != "9007199254740991" {
+		t.Errorf("A's score encodes as %#v, want the string \"9007199254740991\"", got)
+	}
+	if got := teams[1].(map[string]any)["score"]; got != "0" {
+		t.Errorf("B's score encodes as %#v, want the string \"0\"", got)
+	}
+
+	// Polled state quotes scores too, and still decodes to the same state.
+	waitFor(t, "/score to reach version 1", func() bool {
+		_, body := get(t, srv, "/score", nil)
+		return strings.Contains(string(body), `"version":1`)
+	})
+	_, body := get(t, srv, "/score", nil)
+	if !strings.Contains(string(body), `"score":"9007199254740991"`) {
+		t.Errorf("/score does not quote A's score: %s", body)
+	}
+	var s StateJSON
+	if err := json.Unmarshal(body, &s); err != nil || score(t, s, "A") != engine.MaxExactScore {
+		t.Errorf("decoding /score: %v, A = %v", err, s.Teams)
+	}
+}
+
+func TestTeamLimit(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.MaxTeams = 3 })
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "add_team", Name: "C"})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 }); len(s.Teams) != 3 {
+		t.Errorf("%d teams after adding up to the limit, want 3", len(s.Teams))
+	}
+	send(t, conn, Message{Action: "add_team", Name: "D"})
+	if text := readError(t, conn); !strings.Contains(text, engine.ErrTooManyTeams.Error()) {
+		t.Errorf("adding past the limit: %q", text)
+	}
+
+	// Rooms are held to it at creation.
+	dialControl(t, srv, "match=three&teams=A,B,C")
+	if status := dialStatus(t, srv, "/control?token="+testControllerToken+"&match=four&teams=A,B,C,D"); status != http.StatusBadRequest {
+		t.Errorf("room with too many teams: status %d, want 400", status)
+	}
+}
+
+func TestTimeoutsRejectAtZero(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.Timeouts, o.TimeoutStopsClock = 2, true })
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	if s := readState(t, conn); s.Teams[0].TimeoutsLeft != 2 {
+		t.Fatalf("A starts with %d timeouts, want 2", s.Teams[0].TimeoutsLeft)
+	}
+	send(t, conn, Message{Action: "clock_start"})
+	send(t, conn, Message{Action: "timeout_use", Team: "A"})
+	s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 2 })
+	if s.Teams[0].TimeoutsLeft != 1 || s.ClockRunning {
+		t.Errorf("after a timeout: %d left, clock running %t; want 1 left and the clock stopped", s.Teams[0].TimeoutsLeft, s.ClockRunning)
+	}
+	send(t, conn, Message{Action: "timeout_use", Team: "A"})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 3 })
+	send(t, conn, Message{Action: "timeout_use", Team: "A"})
+	if text := readError(t, conn); text != engine.ErrNoTimeouts.Error() {
+		t.Errorf("timeout at zero: %q, want %q", text, engine.ErrNoTimeouts)
+	}
+
+	send(t, conn, Message{Action: "timeout_reset", Team: "A"})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 4 }); s.Teams[0].TimeoutsLeft != 2 || s.Teams[1].TimeoutsLeft != 2 {
+		t.Errorf("after timeout_reset: %+v, want 2 each", s.Teams)
+	}
+}
+
+func TestDeclareWinner(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	// A forfeit goes to the trailing team, and stops the clock.
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 3})
+	send(t, conn, Message{Action: "clock_start"})
+	send(t, conn, Message{Action: "declare_winner", Team: "B"})
+	s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 3 })
+	if !s.Finished || s.Winner != "B" || s.ClockRunning {
+		t.Errorf("forfeit: finished %t, winner %q, clock running %t; want B winning with the clock stopped", s.Finished, s.Winner, s.ClockRunning)
+	}
+
+	send(t, conn, Message{Action: "declare_winner", Team: "A"})
+	if text := readError(t, conn); text != engine.ErrMatchFinished.Error() {
+		t.Errorf("second declaration: %q, want %q", text, engine.ErrMatchFinished)
+	}
+	// Forced, it can become a no-contest.
+	send(t, conn, Message{Action: "declare_winner", Force: true})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 4 }); !s.Finished || s.Winner != "" {
+		t.Errorf("forced draw: finished %t with winner %q, want finished with none", s.Finished, s.Winner)
+	}
+}
+
+func TestTeamSetSwitching(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 2})
+	send(t, conn, Message{Action: "teamset_add", Lineup: []Team{{Name: "C", Color: "#c8102e"}, {Name: "D", Color: "#003087"}}})
+	send(t, conn, Message{Action: "teamset_switch", Index: 1})
+	s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 3 })
+	if len(s.Teams) != 2 || s.Teams[0].Name != "C" || s.Teams[0].Color != "#c8102e" || s.Teams[1].Name != "D" {
+		t.Fatalf("after switching to set 1: %+v, want C and D with their colors", s.Teams)
+	}
+	if score(t, s, "C") != 0 {
+		t.Errorf("C = %v on switching, want a fresh game", score(t, s, "C"))
+	}
+
+	send(t, conn, Message{Action: "increment", Team: "C"})
+	send(t, conn, Message{Action: "teamset_switch", Index: 0})
+	s = readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 5 })
+	if len(s.Teams) != 2 || s.Teams[0].Name != "A" || s.Teams[1].Name != "B" || score(t, s, "A") != 0 {
+		t.Errorf("after switching back to set 0: %+v, want A and B at 0", s.Teams)
+	}
+	send(t, conn, Message{Action: "teamset_switch", Index: 1})
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 6 }); s.Teams[1].Name != "D" || s.Teams[1].Color != "#003087" {
+		t.Errorf("set 1 came back as %+v", s.Teams)
+	}
+
+	send(t, conn, Message{Action: "teamset_switch", Index: 2})
+	if text := readError(t, conn); text != engine.ErrUnknownTeamSet.Error() {
+		t.Errorf("switching to an unknown set: %q, want %q", text, engine.ErrUnknownTeamSet)
+	}
+}
+
+func TestBestOfThreeSeries(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	version := uint64(0)
+	play := func(msgs ...Message) StateJSON {
+		t.Helper()
+		for _, msg := range msgs {
+			send(t, conn, msg)
+		}
+		version += uint64(len(msgs))
+		return readStateWith(t, conn, func(s StateJSON) bool { return s.Version == version })
+	}
+	play(Message{Action: "increment", Team: "A", Value: 3}, Message{Action: "increment", Team: "B"}, Message{Action: "new_game"})
+	play(Message{Action: "increment", Team: "B", Value: 2}, Message{Action: "new_game"})
+	s := play(Message{Action: "increment", Team: "A"}, Message{Action: "new_game"})
+
+	if score(t, s, "A") != 0 || score(t, s, "B") != 0 {
+		t.Errorf("scores after new_game: %+v, want a fresh game", s.Teams)
+	}
+	if s.Series == nil {
+		t.Fatal("no series tally after three games")
+	}
+	if s.Series.Games != 3 || s.Series.Draws != 0 {
+		t.Errorf("series has %d games and %d draws, want 3 and 0", s.Series.Games, s.Series.Draws)
+	}
+	want := []engine.SeriesTeam{{Name: "A", Wins: 2, Streak: 1}, {Name: "B", Wins: 1}}
+	if len(s.Series.Teams) != 2 || s.Series.Teams[0] != want[0] || s.Series.Teams[1] != want[1] {
+		t.Errorf("series teams = %+v, want %+v", s.Series.Teams, want)
+	}
+	h2h := map[string]int{}
+	for _, h := range s.Series.HeadToHead {
+		h2h[h.Team+">"+h.Opponent] = h.Wins
+	}
+	if h2h["A>B"] != 2 || h2h["B>A"] != 1 {
+		t.Errorf("head to head = %v, want A>B 2 and B>A 1", h2h)
+	}
+
+	if s := play(Message{Action: "new_series"}); s.Series != nil && s.Series.Games != 0 {
+		t.Errorf("series after new_series: %+v, want it cleared", s.Series)
+	}
+}
+
+func TestServerActionsAreValidated(t *testing.T) {
+	newTestServer(t)
+
+	// Admin, feed and timer actions get the errors clients do.
+	for _, tc := range []struct {
+		msg  Message
+		want error
+	}{
+		{Message{Action: "increment", Team: "Z", Trusted: true}, engine.ErrUnknownTeam},
+		{Message{Action: "decrement", Team: "A", Trusted: true}, engine.ErrScoreZero},
+		{Message{Action: "frobnicate", Trusted: true}, engine.ErrUnknownAction},
+	} {
+		if err := applyAndBroadcast(tc.msg); err != tc.want {
+			t.Errorf("%s %s: %v, want %v", tc.msg.Action, tc.msg.Team, err, tc.want)
+		}
+	}
+	gameState.mu.Lock()
+	defer gameState.mu.Unlock()
+	if gameState.Version != 0 {
+		t.Errorf("refused actions moved the version to %d", gameState.Version)
+	}
+
+	// A legacy event log holding such an action still replays to the
+	// version it recorded.
+	if err := applyAction(&gameState, Message{Action: "increment", Team: "Z", Trusted: true, Replayed: true}); err != nil {
+		t.Errorf("replaying an increment of an unknown team: %v", err)
+	}
+	if gameState.Version != 1 {
+		t.Errorf("replayed no-op left the version at %d, want 1", gameState.Version)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// ready is set once startup has finished. Until then /readyz reports 503 so
+// load balancers hold traffic back from a half-initialised server.
+var ready atomic.Bool
+
+// healthStatus is the JSON body of /healthz.
+type healthStatus struct {
+	Status    string `json:"status"`
+	Simulator string `json:"simulator"`
+}
+
+// serveHealthz is the liveness probe: the process is up and serving HTTP.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(healthStatus{Status: "ok", Simulator: sim.status()})
+}
+
+// serveReadyz is the readiness probe.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "starting")
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// apiError is the body of every REST error response:
+// {"error":{"code":"not_found","message":"unknown match"}}. Code is a stable
+// machine-readable identifier; Message is for humans and may change.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details lists each problem found when a request fails validation in
+	// more than one place.
+	Details []string `json:"details,omitempty"`
+}
+
+// REST error codes.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeRateLimited      = "rate_limited"
+	CodeUpgradeRequired  = "upgrade_required"
+	CodeUnavailable      = "unavailable"
+	CodeInternal         = "internal"
+)
+
+// writeJSONError replies with status and a JSON error body, in place of
+// http.Error.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// writeValidationError replies 400 with every problem found in a request
+// body, so a client can fix them all in one go.
+func writeValidationError(w http.ResponseWriter, message string, problems []string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(apiError{Error: apiErrorDetail{Code: CodeBadRequest, Message: message, Details: problems}})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRESTErrorShape(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	bearer := http.Header{"Authorization": {"Bearer " + testAdminToken}}
+	for _, tc := range []struct {
+		method, path string
+		header       http.Header
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/score?match=nope", nil, http.StatusNotFound, CodeNotFound},
+		{http.MethodGet, "/admin/reset-all", nil, http.StatusUnauthorized, CodeUnauthorized},
+		{http.MethodGet, "/admin/reset-all", bearer, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{http.MethodPost, "/admin/merge", bearer, http.StatusBadRequest, CodeBadRequest},
+	} {
+		resp, body := do(t, srv, tc.method, tc.path, tc.header, "")
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: Content-Type %q", tc.method, tc.path, ct)
+		}
+		var e apiError
+		if err := json.Unmarshal(body, &e); err != nil || e.Error.Code != tc.code || e.Error.Message == "" {
+			t.Errorf("%s %s: body %s, want code %q and a message", tc.method, tc.path, body, tc.code)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"livescore/engine"
+)
+
+func TestTennisGameToSetProgression(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "configure", Preset: "tennis"})
+	last := readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+	win := func(team, level string, times int) {
+		t.Helper()
+		for range times {
+			send(t, conn, Message{Action: "level_increment", Team: team, Level: level})
+			last = readStateWith(t, conn, func(s StateJSON) bool { return s.Version > last.Version })
+		}
+	}
+	levels := func(team string) engine.LevelScore {
+		t.Helper()
+		for _, tm := range last.Teams {
+			if tm.Name == team && tm.Levels != nil {
+				return *tm.Levels
+			}
+		}
+		t.Fatalf("no levels for team %s in %+v", team, last.Teams)
+		return engine.LevelScore{}
+	}
+
+	// A love game: four points win it and clear the points.
+	win("A", "points", 3)
+	if l := levels("A"); l.Points != 3 || l.Call != "40" {
+		t.Errorf("after three points A has %+v, want 3 points called 40", l)
+	}
+	win("A", "points", 1)
+	if l := levels("A"); l.Games != 1 || l.Points != 0 || l.Call != "0" {
+		t.Errorf("after a love game A has %+v, want 1 game and no points", l)
+	}
+
+	// Through deuce, advantage and back, a game is won by two.
+	win("A", "points", 3)
+	win("B", "points", 3)
+	if a, b := levels("A"), levels("B"); a.Call != "40" || b.Call != "40" {
+		t.Errorf("at deuce the calls are %q and %q, want 40 all", a.Call, b.Call)
+	}
+	win("A", "points", 1)
+	if a, b := levels("A"), levels("B"); a.Call != "AD" || b.Call != "40" || a.Games != 1 {
+		t.Errorf("advantage A: %+v against %+v", a, b)
+	}
+	win("B", "points", 1)
+	if a := levels("A"); a.Call != "40" || a.Games != 1 {
+		t.Errorf("back to deuce: A has %+v", a)
+	}
+	win("A", "points", 2)
+	if a := levels("A"); a.Games != 2 || a.Points != 0 {
+		t.Errorf("after winning from deuce A has %+v, want 2 games", a)
+	}
+
+	// Six games to love take the first set, which the score counts.
+	win("A", "games", 4)
+	a, b := levels("A"), levels("B")
+	if a.Sets != 1 || a.Games != 0 || !slices.Equal(a.SetGames, []int{6}) || !slices.Equal(b.SetGames, []int{0}) {
+		t.Errorf("after the first set: A %+v, B %+v", a, b)
+	}
+	if score(t, last, "A") != 1 {
+		t.Errorf("A's score = %v after a set, want 1", score(t, last, "A"))
+	}
+
+	// At six games all a tiebreak to seven points decides the second set
+	// and, best of three, the match.
+	for range 6 {
+		win("A", "games", 1)
+		win("B", "games", 1)
+	}
+	if a, b := levels("A"), levels("B"); a.Games != 6 || b.Games != 6 || a.Sets != 1 {
+		t.Errorf("at six all: A %+v, B %+v", a, b)
+	}
+	win("A", "points", 6)
+	if a := levels("A"); a.Call != "6" || a.Games != 6 {
+		t.Errorf("six tiebreak points: A has %+v, want them called 6", a)
+	}
+	win("A", "points", 1)
+	a, b = levels("A"), levels("B")
+	if a.Sets != 2 || !slices.Equal(a.SetGames, []int{6, 7}) || !slices.Equal(b.SetGames, []int{0, 6}) {
+		t.Errorf("after the tiebreak: A %+v, B %+v", a, b)
+	}
+	if !last.Finished || last.Winner != "A" {
+		t.Errorf("finished %v with winner %q, want A to win the match", last.Finished, last.Winner)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"livescore/engine"
+)
+
+// matchMaxLifetime is a hard limit on how long a match lives from creation,
+// however active it is, so forgotten boards don't run forever. When it
+// passes, the match is finished (and archived, with ARCHIVE_DIR), its
+// clients are closed, and a fresh match takes its place. 0, the default,
+// means no limit.
+var matchMaxLifetime = envDuration("MATCH_MAX_LIFETIME", 0)
+
+// scheduleExpiry arms the lifetime limit for the current match, and each
+// match that replaces it, until ctx is cancelled.
+func scheduleExpiry(ctx context.Context) {
+	if matchMaxLifetime > 0 {
+		armExpiry(ctx, matchMaxLifetime)
+	}
+}
+
+// armExpiry retires the current match once it is lifetime old.
+func armExpiry(ctx context.Context, lifetime time.Duration) {
+	if ctx.Err() != nil {
+		return
+	}
+	gameState.mu.Lock()
+	expiresAt := gameState.CreatedAt.Add(lifetime)
+	gameState.mu.Unlock()
+
+	log.Printf("Match expires at %s", expiresAt.Format(time.RFC3339))
+	timer := time.AfterFunc(time.Until(expiresAt), func() { expireMatch(ctx, lifetime) })
+	context.AfterFunc(ctx, func() { timer.Stop() })
+}
+
+// expireMatch retires a match that reached its lifetime.
+func expireMatch(ctx context.Context, lifetime time.Duration) {
+	if ctx.Err() != nil {
+		return
+	}
+	err := applyAndBroadcast(Message{Action: "finish", Actor: "system", Trusted: true})
+	if err != nil && !errors.Is(err, engine.ErrMatchFinished) && !errors.Is(err, engine.ErrMatchReadOnly) {
+		log.Printf("finishing expired match: %v", err)
+	}
+	hub.closeAll(5*time.Second, clientCloseTimeout, "match expired")
+	if err := applyAndBroadcast(Message{Action: "expire", Actor: "system", Trusted: true}); err != nil {
+		log.Printf("replacing expired match: %v", err)
+		return
+	}
+	log.Printf("Match reached its %s lifetime and was replaced", lifetime)
+	armExpiry(ctx, lifetime)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMatchExpiresAtItsLifetime(t *testing.T) {
+	setVar(t, &matchMaxLifetime, 150*time.Millisecond)
+	setVar(t, &archiveDir, t.TempDir())
+	srv := newTestServer(t)
+	logs := captureLog(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 3})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+	createdAt := time.Now()
+	scheduleExpiry(ctx)
+
+	// However active it is, the match is closed at its lifetime, its
+	// clients told why.
+	var reason any
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err == nil {
+			if strings.Contains(string(payload), `"reconnect"`) {
+				var f frame
+				json.Unmarshal(payload, &f)
+				reason = f["reason"]
+			}
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+			t.Errorf("connection ended with %v, want a going-away close", err)
+		}
+		break
+	}
+	if since := time.Since(createdAt); since > time.Second {
+		t.Errorf("match closed %v after it was created, want about its 150ms lifetime", since)
+	}
+	if reason != "match expired" {
+		t.Errorf("reconnect hint gives reason %v, want match expired", reason)
+	}
+
+	// It was finished, and so archived, before it went.
+	var archived matchArchive
+	waitFor(t, "the expired match's archive", func() bool {
+		path, ok := archivePath(defaultMatchID)
+		if !ok {
+			return false
+		}
+		data, err := os.ReadFile(path)
+		return err == nil && json.Unmarshal(data, &archived) == nil
+	})
+	var final StateJSON
+	json.Unmarshal(archived.State, &final)
+	if !final.Finished || final.Winner != "A" || score(t, final, "A") != 3 {
+		t.Errorf("expired match archived finished %v with winner %q and A=%v, want A to win on 3", final.Finished, final.Winner, score(t, final, "A"))
+	}
+
+	// A fresh match takes its place.
+	waitFor(t, "the match to be replaced and its expiry armed", func() bool {
+		return strings.Count(logs(), "Match expires at") == 2
+	})
+	late := dial(t, srv, "/ws")
+	if s := readState(t, late); s.Finished || score(t, s, "A") != 0 {
+		t.Errorf("replacement match: finished %v with A=%v, want a fresh one", s.Finished, score(t, s, "A"))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"livescore/engine"
+)
+
+// ErrInvalidLocale is returned by "setlocale" for a malformed locale tag.
+var ErrInvalidLocale = errors.New("locale must be a language tag such as de-DE")
+
+// validLocale checks the shape of a BCP 47 tag: letters, digits and
+// hyphens, at most 35 characters. Underscores, as in POSIX locales, are
+// accepted and normalised to hyphens.
+func validLocale(tag string) (string, error) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if len(tag) > 35 {
+		return "", ErrInvalidLocale
+	}
+	for _, r := range tag {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return "", ErrInvalidLocale
+		}
+	}
+	return tag, nil
+}
+
+// numberSeparators returns the digit grouping and decimal separators of a
+// locale's language. Unknown languages get the English conventions.
+func numberSeparators(locale string) (group, decimal string) {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	switch lang {
+	case "de", "es", "it", "nl", "pt", "da", "id", "tr", "el":
+		return ".", ","
+	case "fr", "ru", "pl", "sv", "nb", "no", "fi", "cs", "sk", "uk", "hu", "bg":
+		return " ", ","
+	default:
+		return ",", "."
+	}
+}
+
+// formatScore renders v with the given number of decimals in the
+// conventions of locale, e.g. 1234.5 as "1.234,5" for de-DE.
+func formatScore(v float64, precision int, locale string) string {
+	group, decimal := numberSeparators(locale)
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(v, 'f', precision, 64), ".")
+	var b strings.Builder
+	if strings.HasPrefix(whole, "-") {
+		b.WriteByte('-')
+		whole = whole[1:]
+	}
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(d)
+	}
+	if frac != "" {
+		b.WriteString(decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// formatClock renders d as MM:SS, or H:MM:SS from an hour up.
+func formatClock(d time.Duration) string {
+	s := int64(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// formatted returns the display strings of teams for locale.
+func (gs *GameState) formatted(teams []Team, locale string) *engine.FormattedJSON {
+	f := &engine.FormattedJSON{Locale: locale, Scores: make([]string, len(teams))}
+	for i, t := range teams {
+		f.Scores[i] = formatScore(t.Score, gs.Options.Precision, locale)
+	}
+	f.Clock = formatClock(gs.Clock.At(time.Now(), gs.Options.PeriodLength))
+	return f
+}
+
+// setLocale stores a client's locale and sends it a snapshot formatted
+// for it. An empty locale stops the formatted strings.
+func (h *Hub) setLocale(client *Client, locale string) error {
+	locale, err := validLocale(locale)
+	if err != nil {
+		return err
+	}
+
+	game := client.game.Load()
+	game.mu.Lock()
+	state := game.clone()
+	game.mu.Unlock()
+	messageType, payload := client.source(state).encodeFor(client, client.filter, locale)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	client.locale = locale
+	client.writeFrame(messageType, payload)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"livescore/engine"
+)
+
+func TestLocalesFormatPerClient(t *testing.T) {
+	withOptions(t, func(o *MatchOptions) { o.Precision = 1 })
+	srv := newTestServer(t)
+
+	console := dialControl(t, srv, "")
+	readState(t, console)
+	viewers := map[string]*websocket.Conn{}
+	for _, locale := range []string{"de-DE", "en_US"} {
+		conn := dial(t, srv, "/ws")
+		readState(t, conn)
+		send(t, conn, Message{Action: "setlocale", Locale: locale})
+		readStateWith(t, conn, func(s StateJSON) bool { return s.Formatted != nil })
+		viewers[locale] = conn
+	}
+
+	send(t, console, Message{Action: "increment", Team: "A", Value: 1234.5})
+	send(t, console, Message{Action: "clock_adjust", DeltaMs: 75000})
+	for locale, want := range map[string]engine.FormattedJSON{
+		"de-DE": {Locale: "de-DE", Scores: []string{"1.234,5", "0,0"}, Clock: "01:15"},
+		"en_US": {Locale: "en-US", Scores: []string{"1,234.5", "0.0"}, Clock: "01:15"},
+	} {
+		s := readStateWith(t, viewers[locale], func(s StateJSON) bool { return s.Version == 2 })
+		got := s.Formatted
+		if got == nil || got.Locale != want.Locale || !slices.Equal(got.Scores, want.Scores) || got.Clock != want.Clock {
+			t.Errorf("%s client got %+v, want %+v", locale, got, want)
+		}
+		if score(t, s, "A") != 1234.5 {
+			t.Errorf("%s client: raw A = %v, want 1234.5 alongside the strings", locale, score(t, s, "A"))
+		}
+	}
+	// A client that set no locale gets no strings.
+	if s := readStateWith(t, console, func(s StateJSON) bool { return s.Version == 2 }); s.Formatted != nil {
+		t.Errorf("client without a locale got %+v", s.Formatted)
+	}
+}
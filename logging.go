@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// debugLogging enables verbose protocol logging when LOG_LEVEL=debug. It is
+// off by default: broadcast payloads are noisy and can be large.
+var debugLogging = strings.EqualFold(envString("LOG_LEVEL", "info"), "debug")
+
+// debugPayloadBytes caps how much of a payload a debug line includes.
+var debugPayloadBytes = envInt("LOG_PAYLOAD_BYTES", 512)
+
+// debugf logs only at debug level.
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
+
+// truncatePayload shortens payload to debugPayloadBytes for logging.
+func truncatePayload(payload []byte) string {
+	if debugPayloadBytes > 0 && len(payload) > debugPayloadBytes {
+		return string(payload[:debugPayloadBytes]) + "…(truncated)"
+	}
+	return string(payload)
+}
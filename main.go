@@ -1,146 +1,1467 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"livescore/engine"
 )
 
 // Upgrader converts HTTP connections to WebSocket connections.
+// Compression (permessage-deflate) is offered when WS_COMPRESSION is set and
+// only used for clients that advertise the extension.
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:       func(r *http.Request) bool { return true },
+	EnableCompression: envBool("WS_COMPRESSION", true),
+	Subprotocols:      []string{subprotocolJSON, subprotocolBinary},
+	Error:             upgradeError,
+}
+
+// upgradeError answers a failed handshake with a JSON error, so plain HTTP
+// clients hitting a WebSocket path learn what went wrong.
+func upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	log.Printf("upgrade of %s from %s failed (%d): %v", r.URL.Path, clientIP(r), status, reason)
+	switch {
+	case status == http.StatusForbidden:
+		writeJSONError(w, status, CodeForbidden, "origin not allowed")
+	case status == http.StatusMethodNotAllowed || r.Method != http.MethodGet:
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "WebSocket handshakes must use GET")
+	case !websocket.IsWebSocketUpgrade(r):
+		w.Header().Set("Upgrade", "websocket")
+		writeJSONError(w, http.StatusUpgradeRequired, CodeUpgradeRequired, "this endpoint only speaks WebSocket")
+	default:
+		writeJSONError(w, status, CodeBadRequest, reason.Error())
+	}
+}
+
+// offersDeflate reports whether the handshake advertises permessage-deflate.
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Client represents a single connected user.
 type Client struct {
 	conn *websocket.Conn
+	// id identifies the connection in admin listings.
+	id          uint64
+	connectedAt time.Time
+	// lastActive is the UnixNano time of the last message from the client.
+	lastActive atomic.Int64
+	// ctx is cancelled when the client disconnects or is dropped; cancelling
+	// it closes the connection. Per-connection goroutines should exit on it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// readDone is closed when the read loop exits, e.g. on the peer's
+	// close frame.
+	readDone chan struct{}
+	// role is RoleViewer, RoleController or RoleScorekeeper, fixed at
+	// connect time.
+	role string
+	// ip is the remote address the client connected from.
+	ip string
+	// filter limits broadcasts to the named teams; nil means all teams.
+	// Guarded by hub.mutex.
+	filter map[string]bool
+	// name is the viewer's display name in the roster. Guarded by hub.mutex.
+	name string
+	// locale, when set, adds display strings formatted for it to each
+	// state. Guarded by hub.mutex.
+	locale string
+	// reactions rate-limits the viewer's reactions; it is made on the first
+	// one and used only by the client's read loop.
+	reactions *tokenBucket
+	// muted stops broadcasts to the client, for testing stale-data
+	// handling. Guarded by hub.mutex.
+	muted bool
+	// resumeToken lets the client pick its session back up after a drop.
+	resumeToken string
+	// binary is set when the client negotiated subprotocolBinary, so state
+	// goes out as binary frames.
+	binary bool
+	// tags are the connection's analytics labels; see clientTagKeys.
+	tags map[string]string
+	// echo is set for controllers that connected with ?echo=1: each action
+	// they send is answered with the resulting state before it is
+	// broadcast. The broadcast carries the same version, so the client can
+	// drop it as already seen.
+	echo bool
+	// tenant is the tenant the client connected under, "" for none.
+	tenant string
+	// view is the broadcast view the client selected with ?view=, fixed at
+	// connect time; nil means the live state.
+	view *viewChannel
+	// game is the match the client is on: &gameState for the default
+	// match, or a room's state. It is set at connect time and only changes
+	// when an admin merges the client's room into another match.
+	game atomic.Pointer[GameState]
+	// subscriptions are the matches the client follows besides game, set
+	// at connect time and changed only by merges. Guarded by hub.mutex.
+	subscriptions []subscription
+	// closing is set once the server starts the close handshake, so
+	// frames from the client no longer push back its read deadline.
+	closing atomic.Bool
+	// closeBy is the shutdown handshake's deadline in Unix nanoseconds,
+	// zero until it starts; no write may run past it.
+	closeBy atomic.Int64
+	// send buffers the frames waiting for writePump.
+	send chan outFrame
+	// writeMu serializes writes; gorilla allows one concurrent writer.
+	writeMu sync.Mutex
+}
+
+// actorName identifies the client in audit records: its display name if it
+// has one, otherwise its role and address.
+func (c *Client) actorName() string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if c.name != "" {
+		return c.name + " (" + c.ip + ")"
+	}
+	return c.role + "@" + c.ip
+}
+
+// sendBuffer is how many frames may wait for a client's write pump. A
+// client that falls this far behind is dropped rather than allowed to hold
+// up the others.
+var sendBuffer = max(envInt("CLIENT_SEND_BUFFER", 64), 1)
+
+// ErrSlowClient is why a client whose send buffer filled up was dropped.
+var ErrSlowClient = errors.New("client too slow, send buffer full")
+
+// outFrame is a frame waiting in a client's send buffer.
+type outFrame struct {
+	messageType int
+	payload     []byte
+	// prepared, when set, is sent in place of messageType and payload.
+	prepared *websocket.PreparedMessage
+	// committed is when the state a broadcast frame carries was
+	// committed, for the latency stats; zero for other frames.
+	committed time.Time
+}
+
+// writePrepared queues a frame prepared once for many clients.
+func (c *Client) writePrepared(pm *websocket.PreparedMessage) error {
+	return c.enqueue(outFrame{prepared: pm})
+}
+
+// write queues a single text frame to the client.
+func (c *Client) write(payload []byte) error {
+	return c.writeFrame(websocket.TextMessage, payload)
+}
+
+// writeFrame queues a single frame of the given message type.
+func (c *Client) writeFrame(messageType int, payload []byte) error {
+	return c.enqueue(outFrame{messageType: messageType, payload: payload})
 }
 
-// GameState holds the current score. The mutex ensures safe concurrent access.
-type GameState struct {
-	mu     sync.Mutex
-	ScoreA int `json:"scoreA"`
-	ScoreB int `json:"scoreB"`
+// enqueue hands f to the client's write pump without blocking. A client
+// whose buffer is full is dropped, and ErrSlowClient returned.
+func (c *Client) enqueue(f outFrame) error {
+	if c.ctx.Err() != nil {
+		return net.ErrClosed
+	}
+	select {
+	case c.send <- f:
+		return nil
+	default:
+		slowClientDrops.Add(1)
+		c.drop("send failed", ErrSlowClient)
+		return ErrSlowClient
+	}
+}
+
+// writePump writes the client's queued frames until it disconnects, so a
+// slow connection only ever holds itself up. A failed write drops the
+// client, after the retries of WS_WRITE_RETRIES if it stalled.
+func (c *Client) writePump() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case f := <-c.send:
+			if err := c.writeNow(f); err != nil {
+				c.drop("write failed", err)
+				return
+			}
+			if !f.committed.IsZero() {
+				broadcastLatency.observe(time.Since(f.committed))
+			}
+		}
+	}
+}
+
+// writeNow writes f to the connection at once, ahead of anything queued.
+// Only the write pump and the shutdown handshake use it.
+func (c *Client) writeNow(f outFrame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.boundWrite()
+	defer c.watchStall()()
+	if f.prepared != nil {
+		return c.conn.WritePreparedMessage(f.prepared)
+	}
+	return c.conn.WriteMessage(f.messageType, f.payload)
+}
+
+// drop evicts the client after a failed write, closing its connection. It
+// is idempotent. Writes to a client that is already closing (its read loop
+// ended, or the server sent a close frame) or whose peer just hung up fail
+// as a matter of course, so those are only logged at debug level.
+func (c *Client) drop(what string, err error) {
+	if c.ctx.Err() != nil || peerGone(err) {
+		debugf("%s: %v", what, err)
+	} else {
+		log.Printf("%s: %v", what, err)
+	}
+	c.cancel()
 }
 
-// Message represents an incoming command from a client.
-type Message struct {
-	Action string `json:"action"` // e.g., "increment", "decrement", "reset"
-	Team   string `json:"team"`   // e.g., "A", "B"
+// peerGone reports whether a write failed because the connection was
+// already closed on either side.
+func peerGone(err error) bool {
+	return errors.Is(err, websocket.ErrCloseSent) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
 }
 
+// nextClientID numbers connections for admin listings.
+var nextClientID atomic.Uint64
+
 // Hub maintains the set of active clients and broadcasts messages.
+// When the viewer cap is reached and queueing is enabled, extra clients wait
+// in queue (FIFO) and are promoted as live clients disconnect.
 type Hub struct {
 	clients map[*Client]bool
-	mutex   sync.Mutex
+	queue   []*Client
+	// lingering holds the roster names of recently dropped clients during
+	// the disconnect grace, keyed by name.
+	lingering map[string]*Client
+	mutex     sync.Mutex
+	// fanout serializes broadcasts; it is never taken under mutex.
+	fanout sync.Mutex
+	// sentVersion is the newest state version broadcast on each match.
+	// Guarded by fanout.
+	sentVersion map[*GameState]uint64
 }
 
-var hub = Hub{clients: make(map[*Client]bool)}
-var gameState = GameState{ScoreA: 0, ScoreB: 0}
+// queuedMessage tells a waiting client its 1-based position in the queue.
+type queuedMessage struct {
+	Type     string `json:"type"`
+	Position int    `json:"position"`
+}
 
-// broadcast sends a message to all connected clients.
-func (h *Hub) broadcast(message []byte) {
+// errorMessage is sent to a single client whose message couldn't be processed.
+type errorMessage struct {
+	Error string `json:"error"`
+	// Action is the action that was refused, when the message had one.
+	Action string `json:"action,omitempty"`
+}
+
+// noticeMessage is an operator notification, such as a pending confirmation.
+type noticeMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// ackMessage confirms an action to its sender when it left the state as it
+// was, so there was no broadcast to confirm it.
+type ackMessage struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	// Match echoes the action's "match" field.
+	Match   string `json:"match,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+var hub = Hub{clients: make(map[*Client]bool), lingering: make(map[string]*Client), sentVersion: make(map[*GameState]uint64)}
+var gameState = GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 1, StartsAt: envTime("MATCH_STARTS_AT"), EndsAt: envTime("MATCH_ENDS_AT"), Options: MatchOptions{
+	ScoreMax:                envInt("SCORE_MAX", 0),
+	OnMax:                   envString("ON_MAX", engine.OnMaxCap),
+	Step:                    envInt("SCORE_STEP", 1),
+	Precision:               min(max(envInt("SCORE_PRECISION", 0), 0), 3),
+	StringScores:            envBool("SCORE_STRINGS", false),
+	MaxTeams:                envInt("MAX_TEAMS", engine.DefaultMaxTeams),
+	ResetFoulsOnPeriod:      envBool("FOULS_RESET_ON_PERIOD", false),
+	Timeouts:                envInt("TIMEOUTS", 0),
+	TimeoutStopsClock:       envBool("TIMEOUT_STOPS_CLOCK", true),
+	MaxBroadcastBytes:       envInt("MAX_BROADCAST_BYTES", 0),
+	RequireResetArm:         envBool("RESET_REQUIRE_ARM", false),
+	ResetArmWindow:          envDuration("RESET_ARM_WINDOW", 5*time.Second),
+	ResetOnEmpty:            envBool("RESET_ON_EMPTY", false),
+	SnapshotLastWhileFrozen: envBool("FREEZE_SNAPSHOT_LAST", false),
+}}}
+
+// defaultMatchID names the board that connections without a ?match=
+// parameter join, so single-board clients keep working unchanged.
+var defaultMatchID = envString("DEFAULT_MATCH", "default")
+
+// matchID returns the match a request addresses, falling back to the
+// default, scoped to the request's tenant if it names one.
+func matchID(r *http.Request) string {
+	id := r.URL.Query().Get("match")
+	if id == "" {
+		id = defaultMatchID
+	}
+	return scopedMatchID(r, id)
+}
+
+// maxClients caps the number of live clients; 0 means unlimited.
+var maxClients = envInt("MAX_CLIENTS", 0)
+
+// queueWhenFull holds clients over the cap in a waiting queue instead of
+// rejecting them.
+var queueWhenFull = envBool("QUEUE_WHEN_FULL", false)
+
+// broadcast sends a message to every client of the default match; see
+// broadcastTo.
+func (h *Hub) broadcast(message []byte, state *GameState, committed time.Time) {
+	h.broadcastTo(&gameState, message, state, committed)
+}
+
+// broadcastTo sends a message to all clients connected to the match gs.
+// Clients with a team filter get a view rendered from state instead, when
+// one is given. If committed is set, the delay from that moment to each
+// client's send is recorded in the broadcast latency stats.
+//
+// The shared payload is framed (and compressed) once as a PreparedMessage and
+// reused for every connection; if preparing fails each client is written
+// individually.
+//
+// The client set is copied under h.mutex and the frames are queued after it
+// is released, so registration never waits on a fan-out; each client's
+// write pump sends them, and a client too slow to keep up is dropped
+// instead of delaying the rest. h.fanout keeps broadcasts from overtaking
+// each other on the way to a client.
+func (h *Hub) broadcastTo(gs *GameState, message []byte, state *GameState, committed time.Time) {
+	prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, message)
+	if err != nil {
+		log.Printf("preparing broadcast: %v", err)
+		prepared = nil
+	}
+
+	h.fanout.Lock()
+	defer h.fanout.Unlock()
+	// Actions commit in version order under the game lock, but their
+	// broadcasts race for fanout once it is released. A state that lost
+	// the race to a newer one is dropped, so clients never see the version
+	// go backward; the newer state already includes its change.
+	if state != nil {
+		if sent := h.sentVersion[gs]; state.Version < sent {
+			debugf("broadcast of version %d dropped, %d already sent", state.Version, sent)
+			return
+		}
+		h.sentVersion[gs] = state.Version
+	}
+
+	type target struct {
+		client *Client
+		filter map[string]bool
+		locale string
+	}
+	h.mutex.Lock()
+	targets := make([]target, 0, len(h.clients))
+	var subscribers []*Client
+	for client := range h.clients {
+		if client.muted {
+			continue
+		}
+		// Views send states themselves; everything else goes to all.
+		if client.game.Load() == gs {
+			if client.view == nil || state == nil {
+				targets = append(targets, target{client, client.filter, client.locale})
+			}
+		} else if _, ok := client.subscribedTo(gs); ok {
+			subscribers = append(subscribers, client)
+		}
+	}
+	h.mutex.Unlock()
+	if state != nil && gs == &gameState {
+		for _, ch := range viewChannels {
+			ch.enqueue(state, committed)
+		}
+	}
+	if debugLogging {
+		debugf("broadcast match=%s clients=%d bytes=%d payload=%s", gs.matchID(), len(targets), len(message), truncatePayload(message))
+	}
+
+	// With a transform each role, and without one each locale, gets its
+	// own rendering of the state, made on first use.
+	views := make(map[string][]byte)
+	// Binary clients share one encoding, made on first use.
+	var binaryState []byte
+	var failed []*Client
+	for _, t := range targets {
+		client := t.client
+		frame := outFrame{messageType: websocket.TextMessage, committed: committed}
+		switch {
+		case client.binary && broadcastTransform == nil && state != nil && state.fitsBinary(t.filter):
+			payload := binaryState
+			if t.filter != nil {
+				payload = state.marshalBinary(t.filter)
+			} else if payload == nil {
+				payload = state.marshalBinary(nil)
+				binaryState = payload
+			}
+			frame.messageType, frame.payload = websocket.BinaryMessage, payload
+		case t.filter != nil && state != nil:
+			frame.payload = state.view(client.role, t.filter, t.locale)
+		case state != nil && (broadcastTransform != nil || t.locale != ""):
+			key := client.role + "\x00" + t.locale
+			payload, ok := views[key]
+			if !ok {
+				payload = state.view(client.role, nil, t.locale)
+				views[key] = payload
+			}
+			frame.payload = payload
+		case prepared != nil:
+			frame.prepared = prepared
+		default:
+			frame.payload = message
+		}
+		if err := client.enqueue(frame); err != nil {
+			client.drop("broadcast error", err)
+			failed = append(failed, client)
+		}
+	}
+	if len(subscribers) > 0 {
+		payload := tagged(gs, message)
+		for _, client := range subscribers {
+			if err := client.enqueue(outFrame{messageType: websocket.TextMessage, payload: payload, committed: committed}); err != nil {
+				client.drop("broadcast error", err)
+				failed = append(failed, client)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		h.mutex.Lock()
+		for _, client := range failed {
+			delete(h.clients, client)
+		}
+		h.mutex.Unlock()
+	}
+}
+
+// broadcastToRole sends message only to live clients of the match gs with
+// the given role, for operator notes that viewers shouldn't see.
+// Subscribers holding the role on gs get it tagged.
+func (h *Hub) broadcastToRole(gs *GameState, role string, message []byte) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	var tag []byte
 	for client := range h.clients {
-		err := client.conn.WriteMessage(websocket.TextMessage, message)
-		if err != nil {
-			log.Printf("broadcast error: %v", err)
-			client.conn.Close()
+		if client.muted {
+			continue
+		}
+		payload := message
+		if client.game.Load() != gs {
+			if sub, ok := client.subscribedTo(gs); !ok || sub != role {
+				continue
+			}
+			if tag == nil {
+				tag = tagged(gs, message)
+			}
+			payload = tag
+		} else if client.role != role {
+			continue
+		}
+		if err := client.write(payload); err != nil {
+			client.drop("broadcast error", err)
 			delete(h.clients, client)
 		}
 	}
 }
 
+// notifyControllers sends every controller of the match gs a notice.
+func (h *Hub) notifyControllers(gs *GameState, text string) {
+	payload, _ := json.Marshal(noticeMessage{Type: "notice", Message: text})
+	h.broadcastToRole(gs, RoleController, payload)
+}
+
+// forget drops what the hub keeps about a closed room.
+func (h *Hub) forget(gs *GameState) {
+	h.fanout.Lock()
+	delete(h.sentVersion, gs)
+	h.fanout.Unlock()
+}
+
+// full reports whether the live viewer cap has been reached. Controllers
+// don't count towards it. The caller must hold h.mutex.
+func (h *Hub) full() bool {
+	if maxClients <= 0 {
+		return false
+	}
+	viewers := 0
+	for c := range h.clients {
+		if c.role == RoleViewer {
+			viewers++
+		}
+	}
+	return viewers >= maxClients
+}
+
+// clientsOn counts the live clients of the match gs. The caller must hold
+// h.mutex.
+func (h *Hub) clientsOn(gs *GameState) int {
+	n := 0
+	for c := range h.clients {
+		if c.game.Load() == gs {
+			n++
+		}
+	}
+	return n
+}
+
+// isQueued reports whether the client is waiting for a live slot.
+func (h *Hub) isQueued(client *Client) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, c := range h.queue {
+		if c == client {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyQueue sends every waiting client its current position. The caller
+// must hold h.mutex.
+func (h *Hub) notifyQueue() {
+	for i, c := range h.queue {
+		payload, _ := json.Marshal(queuedMessage{Type: "queued", Position: i + 1})
+		if err := c.write(payload); err != nil {
+			c.drop("queue notify error", err)
+		}
+	}
+}
+
+// unregister removes a client from the hub. If it held a live slot, the
+// longest-waiting queued client is promoted and sent the current state. It
+// returns how many clients, live or queued, remain on the client's match.
+func (h *Hub) unregister(client *Client) int {
+	gameState.mu.Lock()
+	state := gameState.snapshot()
+	if broadcastTransform != nil {
+		// Only viewers are ever queued.
+		state = gameState.joinState().view(RoleViewer, nil, "")
+	}
+	live := gameState.joinState().clone()
+	gameState.mu.Unlock()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+	} else {
+		for i, c := range h.queue {
+			if c == client {
+				h.queue = append(h.queue[:i], h.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	for len(h.queue) > 0 && !h.full() {
+		next := h.queue[0]
+		h.queue = h.queue[1:]
+		h.clients[next] = true
+		if next.binary || next.locale != "" || next.view != nil {
+			next.writeFrame(next.source(live).encodeFor(next, nil, next.locale))
+		} else {
+			next.write(state)
+		}
+		log.Println("Queued client promoted")
+	}
+	h.notifyQueue()
+	game := client.game.Load()
+	remaining := h.clientsOn(game)
+	if game == &gameState {
+		// Only the default match queues clients.
+		remaining += len(h.queue)
+	}
+	return remaining
+}
+
+// sendError replies to a single client with an error frame.
+func (h *Hub) sendError(client *Client, text string) {
+	h.sendActionError(client, "", text)
+}
+
+// sendActionError replies to a single client with an error frame naming the
+// action it refused, so a client can tell which of its messages failed.
+func (h *Hub) sendActionError(client *Client, action, text string) {
+	ops.clientEvent("error", client, text)
+	payload, _ := json.Marshal(errorMessage{Error: text, Action: action})
+	if err := client.write(payload); err != nil {
+		client.drop("error reply failed", err)
+	}
+}
+
+// setMuted pauses or resumes broadcasts to the live client with the given
+// ID. An unmuted client is sent a fresh snapshot.
+func (h *Hub) setMuted(id uint64, muted bool) error {
+	// The client's match is only known under h.mutex, and its state can't
+	// be locked there, so look the client up first.
+	h.mutex.Lock()
+	var target *Client
+	for c := range h.clients {
+		if c.id == id {
+			target = c
+		}
+	}
+	h.mutex.Unlock()
+	if target == nil {
+		return ErrUnknownClient
+	}
+	game := target.game.Load()
+	game.mu.Lock()
+	state := game.visible()
+	game.mu.Unlock()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for c := range h.clients {
+		if c != target {
+			continue
+		}
+		if c.muted && !muted {
+			c.writeFrame(c.source(state).encodeFor(c, c.filter, c.locale))
+		}
+		c.muted = muted
+		log.Printf("Client %d muted=%t", id, muted)
+		return nil
+	}
+	return ErrUnknownClient
+}
+
+// setFilter stores a client's team filter and sends it a matching snapshot.
+// An empty team list clears the filter.
+func (h *Hub) setFilter(client *Client, teams []string) {
+	var filter map[string]bool
+	if len(teams) > 0 {
+		filter = make(map[string]bool, len(teams))
+		for _, name := range teams {
+			filter[name] = true
+		}
+	}
+
+	game := client.game.Load()
+	game.mu.Lock()
+	state := game.visible()
+	game.mu.Unlock()
+	messageType, payload := client.source(state).encodeFor(client, filter, client.locale)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	client.filter = filter
+	client.writeFrame(messageType, payload)
+}
+
+// strictMessages refuses client messages carrying fields Message doesn't
+// know, so a typo such as "acton" gets an error frame instead of silently
+// doing nothing. Turn it off to let clients send fields from newer
+// protocol versions to older servers.
+var strictMessages = envBool("STRICT_MESSAGES", true)
+
+// errUnknownField wraps the error for a message with an unknown field.
+var errUnknownField = errors.New("unknown field")
+
+// decodeMessage decodes a client message, checking its fields when
+// strictMessages is set.
+func decodeMessage(payload []byte) (Message, error) {
+	var msg Message
+	if !strictMessages {
+		return msg, json.Unmarshal(payload, &msg)
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&msg)
+	// The decoder has no typed error for this; its message names the field.
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return msg, fmt.Errorf("%w %s", errUnknownField, field)
+	}
+	return msg, err
+}
+
+// connections tracks upgraded connections until their cleanup in
+// handleMessages has finished, so shutdown can wait for it.
+var connections sync.WaitGroup
+
 // handleMessages processes incoming messages from a client.
 func handleMessages(client *Client) {
+	defer connections.Done()
 	defer func() {
+		remaining := hub.unregister(client)
+		client.cancel()
+		// Once unregistered the client can't be moved to another match.
+		game := client.game.Load()
 		hub.mutex.Lock()
-		delete(hub.clients, client)
+		subs := client.subscriptions
+		client.subscriptions = nil
 		hub.mutex.Unlock()
-		client.conn.Close()
+		leaveSubscriptions(subs)
+		if game != &gameState {
+			rooms.leave(game)
+		} else if remaining == 0 && gameState.Options.ResetOnEmpty {
+			// Kiosk boards start each session fresh.
+			if err := applyAndBroadcast(Message{Action: "reset", Actor: "system", Trusted: true}); err != nil {
+				log.Printf("reset on empty: %v", err)
+			} else {
+				log.Println("Last client left, score reset")
+			}
+		}
+		hub.mutex.Lock()
+		resumeTokens.save(client.resumeToken, session{filter: client.filter, name: client.name, locale: client.locale, role: client.role, tenant: client.tenant})
+		name := client.name
+		hub.mutex.Unlock()
+		if name != "" && !hub.linger(client, name) {
+			hub.broadcastRoster(game)
+		}
+		ops.clientEvent("client_disconnected", client, "")
 		log.Println("Client disconnected")
 	}()
+	// Runs first, so a shutdown waiting on the close handshake isn't held
+	// up by the cleanup above.
+	defer close(client.readDone)
+
+	// Any frame from the client, pongs included, shows it is alive.
+	client.extendRead()
+	client.conn.SetPongHandler(func(string) error {
+		client.extendRead()
+		return nil
+	})
+	go client.heartbeat()
 
 	for {
-		_, payload, err := client.conn.ReadMessage()
+		messageType, payload, err := client.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && client.ctx.Err() == nil {
+				log.Printf("Client %d timed out after %s of silence", client.id, pongWait)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("read error: %v", err)
 			}
 			break
 		}
+		client.extendRead()
+		client.lastActive.Store(time.Now().UnixNano())
 
-		var msg Message
-		if err := json.Unmarshal(payload, &msg); err != nil {
+		// Some proxies relay text as binary frames, so accept JSON in either.
+		if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+			log.Printf("unexpected frame type %d from client, ignoring", messageType)
+			hub.sendError(client, "unsupported frame type")
+			continue
+		}
+
+		// Controller actions must be signed when a signing key is set.
+		if client.role != RoleViewer {
+			if payload, err = verifySigned(payload); err != nil {
+				log.Printf("rejected action from %s: %v", client.ip, err)
+				hub.sendError(client, err.Error())
+				continue
+			}
+		}
+
+		msg, err := decodeMessage(payload)
+		if errors.Is(err, errUnknownField) {
+			hub.sendError(client, err.Error())
+			continue
+		}
+		if err != nil {
 			log.Printf("json unmarshal error: %v", err)
+			hub.sendError(client, "invalid JSON: "+err.Error())
+			continue
+		}
+
+		// Queued clients only watch their position until promoted.
+		if hub.isQueued(client) {
+			continue
+		}
+
+		// The match the action is for, as of this message, and the
+		// client's role there.
+		game, role := client.game.Load(), client.role
+		if msg.Match != "" {
+			if game, role, err = client.target(msg.Match); err != nil {
+				hub.sendActionError(client, msg.Action, err.Error())
+				continue
+			}
+		}
+
+		// Viewers and controllers connect on separate paths; each only
+		// accepts its own kind of action.
+		if role == RoleViewer && isMutation(msg.Action) {
+			hub.sendActionError(client, msg.Action, "viewers cannot change the score; connect to /control")
+			continue
+		}
+		if client.role != RoleViewer && !isMutation(msg.Action) {
+			hub.sendActionError(client, msg.Action, "controller connections only accept scoring actions")
+			continue
+		}
+		if !roleAllows(role, msg.Action) {
+			hub.sendActionError(client, msg.Action, fmt.Sprintf("role %s may not send %q", role, msg.Action))
+			continue
+		}
+		if msg.Match != "" && !isMutation(msg.Action) {
+			hub.sendActionError(client, msg.Action, fmt.Sprintf("%q applies to the connection, not a match", msg.Action))
+			continue
+		}
+
+		// Filters only affect what this client receives.
+		if msg.Action == "filter" {
+			hub.setFilter(client, msg.Teams)
 			continue
 		}
 
-		// Lock the game state while we modify it
-		gameState.mu.Lock()
-		switch msg.Action {
-		case "increment":
-			if msg.Team == "A" {
-				gameState.ScoreA++
-			} else if msg.Team == "B" {
-				gameState.ScoreB++
+		if msg.Action == "setname" {
+			if err := hub.setName(client, msg.Name); err != nil {
+				hub.sendError(client, err.Error())
 			}
-		case "decrement":
-			if msg.Team == "A" && gameState.ScoreA > 0 {
-				gameState.ScoreA--
-			} else if msg.Team == "B" && gameState.ScoreB > 0 {
-				gameState.ScoreB--
+			continue
+		}
+
+		// Overlays run on the default match only.
+		if game != &gameState && (msg.Action == "react" || msg.Action == "countdown" || msg.Action == "countdown_cancel") {
+			hub.sendError(client, fmt.Sprintf("%q is only available on the %s match", msg.Action, defaultMatchID))
+			continue
+		}
+
+		if msg.Action == "react" {
+			if err := client.react(msg.Emoji); err != nil {
+				hub.sendError(client, err.Error())
+			}
+			continue
+		}
+
+		if msg.Action == "setlocale" {
+			if err := hub.setLocale(client, msg.Locale); err != nil {
+				hub.sendError(client, err.Error())
+			}
+			continue
+		}
+
+		// Muting only changes delivery, not the game.
+		if msg.Action == "mute_client" || msg.Action == "unmute_client" {
+			if err := hub.setMuted(msg.Client, msg.Action == "mute_client"); err != nil {
+				hub.sendError(client, err.Error())
 			}
-		case "reset":
-			gameState.ScoreA = 0
-			gameState.ScoreB = 0
+			continue
 		}
 
-		// Marshal the updated state to JSON
-		updatedState, _ := json.Marshal(gameState)
-		gameState.mu.Unlock()
+		// Countdowns are overlay timers outside the match state.
+		if msg.Action == "countdown" {
+			if err := countdown.start(time.Duration(msg.Ms) * time.Millisecond); err != nil {
+				hub.sendError(client, err.Error())
+			}
+			continue
+		}
+		if msg.Action == "countdown_cancel" {
+			countdown.cancel()
+			continue
+		}
 
-		// Broadcast the new state to everyone
-		hub.broadcast(updatedState)
-		log.Printf("Processed message: %+v. New state: %s", msg, updatedState)
+		// An authoritative feed owns the score; manual edits would be
+		// overwritten on its next poll anyway.
+		if feed != nil && game == &gameState {
+			hub.sendError(client, "score is controlled by an external feed")
+			continue
+		}
+
+		if !actionThrottle.allow() {
+			hub.sendError(client, ErrServerBusy.Error())
+			continue
+		}
+
+		msg.Actor = client.actorName()
+		sender := client
+		// Echoes are untagged, so only actions on the client's own match
+		// get one; a subscribed match's tagged broadcast follows anyway.
+		if !client.echo || game != client.game.Load() {
+			sender = nil
+		}
+		unchanged, err := applyActions(game, sender, msg)
+		if err != nil {
+			hub.sendActionError(client, msg.Action, err.Error())
+			continue
+		}
+		if unchanged {
+			game.mu.Lock()
+			version := game.Version
+			game.mu.Unlock()
+			payload, _ := json.Marshal(ackMessage{Type: "ack", Action: msg.Action, Match: msg.Match, Version: version})
+			if err := client.write(payload); err != nil {
+				client.drop("ack failed", err)
+			}
+		}
+		if msg.Action == "reset_arm" {
+			hub.notifyControllers(game, fmt.Sprintf("reset armed by %s, confirm within %s", msg.Actor, game.Options.ResetArmWindow))
+		}
 	}
 }
 
-func serveWs(w http.ResponseWriter, r *http.Request) {
+// applyAndBroadcast applies msgs to the game state in order and broadcasts the
+// result. It stops at the first failing action; anything applied before it is
+// still broadcast.
+func applyAndBroadcast(msgs ...Message) error {
+	_, err := applyActions(&gameState, nil, msgs...)
+	return err
+}
+
+// applyActions is applyAndBroadcast on the match gs, also reporting whether
+// the broadcast was dropped because the actions left the visible state as
+// last sent. A non-nil sender is sent the new state ahead of everyone else.
+// Rooms are only snapshotted, and aren't reported to event sinks.
+func applyActions(gs *GameState, sender *Client, msgs ...Message) (unchanged bool, err error) {
+	// Lock the game state while we modify it
+	gs.mu.Lock()
+	applied, err := gs.applyLocked(msgs)
+	if applied == 0 {
+		gs.mu.Unlock()
+		return false, err
+	}
+	return broadcastLocked(gs, sender, msgs[:applied]), err
+}
+
+// applyLocked applies msgs to gs in order, logging and persisting each, and
+// returns how many were applied before the first that failed. The caller
+// must hold gs.mu and broadcast the result.
+func (gs *GameState) applyLocked(msgs []Message) (applied int, err error) {
+	for _, msg := range msgs {
+		// One timestamp per action, shared by its audit and persisted records.
+		msg.At = msg.Time()
+		if err = actionValidator(gs, msg); err != nil {
+			break
+		}
+		wasFinished := gs.Finished
+		if err = applyAction(gs, msg); err != nil {
+			break
+		}
+		gs.recordEvent(msg, wasFinished)
+		if gs == &gameState {
+			persist.record(gs, msg)
+			emitEvent(newScoreEvent(gs, msg))
+		} else {
+			persist.saveRoom(gs)
+		}
+		applied++
+	}
+	return applied, err
+}
+
+// broadcastLocked sends the state of the match gs after msgs were applied to
+// every client, and first to sender if it is non-nil. It reports whether
+// the broadcast was dropped because the visible state is unchanged. A nil
+// msgs marks the coalesced broadcast of a rate-capped match, which the cap
+// lets through. The caller must hold gs.mu, which is released.
+func broadcastLocked(gs *GameState, sender *Client, msgs []Message) (unchanged bool) {
+	if gs.Frozen {
+		// Viewers see the combined result on "unfreeze".
+		gs.mu.Unlock()
+		if msgs != nil {
+			log.Printf("Applied while frozen: %+v", msgs)
+		}
+		return false
+	}
+	var fingerprint []byte
+	if dedupBroadcasts && gs.implausible() == "" {
+		fingerprint = gs.fingerprint()
+		if bytes.Equal(fingerprint, gs.lastFingerprint) {
+			// Clients already show this state; only the version moved.
+			gs.mu.Unlock()
+			dedupedBroadcasts.Add(1)
+			debugf("broadcast skipped, state unchanged: %+v", msgs)
+			return msgs != nil
+		}
+	}
+	// Listener checks and the rate cap only concern the default match; a
+	// room always has clients.
+	isDefault := gs == &gameState
+	if isDefault && skipEmptyBroadcasts && !hasListeners() {
+		// Nobody would receive it: skip encoding. /score and snapshots
+		// render the live state on demand instead.
+		gs.lastBroadcast = nil
+		gs.lastShown = nil
+		gs.lastFingerprint = nil
+		gs.mu.Unlock()
+		publicScore.invalidate()
+		skippedBroadcasts.Add(1)
+		return false
+	}
+	if isDefault && msgs != nil && !broadcastCap.admit(time.Now()) {
+		// The cap's next broadcast carries this state.
+		state := gs.clone()
+		gs.mu.Unlock()
+		coalescedBroadcasts.Add(1)
+		if sender != nil {
+			sender.echoState(state, state.broadcastPayload())
+		}
+		return false
+	}
+	committed := time.Now()
+
+	// Marshal the updated state to JSON; the clone lets filtered views be
+	// rendered without holding the lock.
+	state := gs.clone()
+	updatedState := state.broadcastPayload()
+	if updatedState == nil {
+		// Clients keep the last state they were sent; snapshots render
+		// the live one on demand.
+		gs.lastBroadcast = nil
+		gs.lastShown = nil
+		gs.lastFingerprint = nil
+		gs.mu.Unlock()
+		gs.public().invalidate()
+		return false
+	}
+	gs.lastBroadcast = updatedState
+	gs.lastShown = state
+	gs.lastFingerprint = fingerprint
+	config := gs.configChange()
+	gs.mu.Unlock()
+	if config != nil {
+		hub.broadcastTo(gs, config, nil, time.Time{})
+	}
+	if sender != nil {
+		sender.echoState(state, updatedState)
+	}
+	publishState(gs, state, updatedState)
+
+	// Broadcast the new state to everyone
+	hub.broadcastTo(gs, updatedState, state, committed)
+	if msgs != nil {
+		log.Printf("Processed messages on %s: %+v. New state: %s", gs.matchID(), msgs, updatedState)
+	} else {
+		debugf("Sent coalesced state: %s", updatedState)
+	}
+	return false
+}
+
+// echoState sends an echo controller the state its action produced, as
+// broadcast in payload. A nil payload, for a state too large to
+// broadcast, sends nothing.
+func (c *Client) echoState(state *GameState, payload []byte) {
+	if payload == nil {
+		return
+	}
+	messageType := websocket.TextMessage
+	if c.binary || broadcastTransform != nil {
+		messageType, payload = state.encodeFor(c, nil, "")
+	}
+	if err := c.writeFrame(messageType, payload); err != nil {
+		c.drop("echo failed", err)
+	}
+}
+
+// skipEmptyBroadcasts skips encoding a state nobody is connected to
+// receive, which saves CPU on feed-driven matches without live viewers.
+var skipEmptyBroadcasts = envBool("SKIP_EMPTY_BROADCASTS", true)
+
+// dedupBroadcasts drops the broadcast of actions that change nothing
+// clients can see, such as a decrement clamped at zero or a set to the
+// current score. The version still moves and the action is still logged.
+var dedupBroadcasts = envBool("BROADCAST_DEDUP", true)
+
+// fingerprint encodes what a broadcast of the state shows, apart from the
+// version, for comparison with the last broadcast. The clock is taken as
+// its raw elapsed time and start, so a running clock alone reads as
+// unchanged. The caller must hold gs.mu.
+func (gs *GameState) fingerprint() []byte {
+	s := gs.Wire(gs.Teams)
+	s.Version = 0
+	s.ElapsedMs = gs.Clock.Elapsed.Milliseconds()
+	payload, _ := json.Marshal(s)
+	return strconv.AppendInt(payload, gs.Clock.StartedAt.UnixNano(), 10)
+}
+
+// hasListeners reports whether any connection, long poller or publisher
+// would receive a broadcast of the default match. The caller may hold
+// gameState.mu.
+func hasListeners() bool {
+	// A broadcast delay always listens, as /score serves what it delivers.
+	if mqtt != nil || publicScore.hasWaiters() || viewChannels[""] != nil {
+		return true
+	}
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return hub.clientsOn(&gameState) > 0
+}
+
+// Backstops against states no broadcast should carry, whatever let them
+// in: a state with more than broadcastMaxTeams teams isn't encoded at all,
+// and one whose payload still exceeds broadcastMaxBytes after the compact
+// fallback isn't sent.
+var (
+	broadcastMaxTeams = envInt("BROADCAST_MAX_TEAMS", 1000)
+	broadcastMaxBytes = envInt("BROADCAST_MAX_BYTES", 1<<20)
+)
+
+// implausible explains why the state is too large to broadcast, or returns
+// "" if it isn't.
+func (gs *GameState) implausible() string {
+	if broadcastMaxTeams > 0 && len(gs.Teams) > broadcastMaxTeams {
+		return fmt.Sprintf("%d teams exceeds BROADCAST_MAX_TEAMS of %d", len(gs.Teams), broadcastMaxTeams)
+	}
+	return ""
+}
+
+// broadcastPayload encodes the state for a broadcast, falling back to the
+// compact form when it exceeds MaxBroadcastBytes. It returns nil, logging
+// why, for a state too large to broadcast at all.
+func (gs *GameState) broadcastPayload() []byte {
+	if reason := gs.implausible(); reason != "" {
+		log.Printf("broadcast refused: %s", reason)
+		refusedBroadcasts.Add(1)
+		return nil
+	}
+	payload, _ := json.Marshal(gs)
+	if max := gs.Options.MaxBroadcastBytes; max > 0 && len(payload) > max {
+		log.Printf("broadcast of %d bytes exceeds %d, sending compact state", len(payload), max)
+		oversizedBroadcasts.Add(1)
+		payload = gs.MarshalCompact()
+	}
+	if broadcastMaxBytes > 0 && len(payload) > broadcastMaxBytes {
+		log.Printf("broadcast refused: %d bytes exceeds BROADCAST_MAX_BYTES of %d", len(payload), broadcastMaxBytes)
+		refusedBroadcasts.Add(1)
+		return nil
+	}
+	return payload
+}
+
+// serveClient upgrades a connection with the given role, restoring sess if
+// the client resumed. Connections name their board with ?match=; without it
+// they join the default match, and with any other ID a room (see maxRooms).
+// Controllers are never held back by the viewer cap.
+func serveClient(w http.ResponseWriter, r *http.Request, role string, sess session, resumed bool) {
+	tags, err := connectionTags(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	id := matchID(r)
+	setup, err := parseRoomSetup(r, id, role)
+	if err != nil {
+		status, code := http.StatusBadRequest, CodeBadRequest
+		if errors.Is(err, ErrSetupForbidden) {
+			status, code = http.StatusForbidden, CodeForbidden
+		}
+		writeJSONError(w, status, code, err.Error())
+		return
+	}
+	view := defaultView(role)
+	if id != defaultMatchID {
+		view = nil
+	}
+	if name := r.URL.Query().Get("view"); name != "" {
+		if id != defaultMatchID {
+			writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "views are only offered on the "+defaultMatchID+" match")
+			return
+		}
+		if view = viewChannels[name]; view == nil {
+			writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "unknown view "+strconv.Quote(name))
+			return
+		}
+	}
+
+	capped := role == RoleViewer
+	queueable := id == defaultMatchID && queueWhenFull
+	hub.mutex.Lock()
+	reject := capped && hub.full() && !queueable
+	hub.mutex.Unlock()
+	if reject {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryHint()))
+		writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "too many clients")
+		return
+	}
+
+	game := &gameState
+	if id != defaultMatchID {
+		if game, err = rooms.join(id, setup); err != nil {
+			writeJoinError(w, err)
+			return
+		}
+	}
+	subs, err := subscribe(r, game, role)
+	if err != nil {
+		if game != &gameState {
+			rooms.leave(game)
+		}
+		writeJoinError(w, err)
+		return
+	}
+	// leave gives the rooms back if the client never makes it in.
+	leave := func() {
+		leaveSubscriptions(subs)
+		if game != &gameState {
+			rooms.leave(game)
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		// upgradeError has already replied and logged.
+		leave()
 		return
 	}
-	client := &Client{conn: conn}
+	// Done by handleMessages, or below if the client is turned away.
+	connections.Add(1)
+	tuneConn(conn)
+	// Clients that don't offer the extension get plain frames.
+	compressed := upgrader.EnableCompression && offersDeflate(r)
+	conn.EnableWriteCompression(compressed)
+	log.Printf("Connection from %s, compression=%t", r.RemoteAddr, compressed)
+
+	// The request context ends when this handler returns, so the
+	// connection gets its own, cancelled on disconnect.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	context.AfterFunc(ctx, func() { conn.Close() })
+	client := &Client{
+		conn:          conn,
+		id:            nextClientID.Add(1),
+		connectedAt:   time.Now(),
+		ctx:           ctx,
+		cancel:        cancel,
+		readDone:      make(chan struct{}),
+		role:          role,
+		tenant:        requestTenant(r),
+		ip:            clientIP(r).String(),
+		resumeToken:   newResumeToken(),
+		binary:        conn.Subprotocol() == subprotocolBinary,
+		echo:          role != RoleViewer && r.URL.Query().Get("echo") == "1",
+		tags:          tags,
+		view:          view,
+		subscriptions: subs,
+		send:          make(chan outFrame, sendBuffer),
+	}
+	client.game.Store(game)
+	go client.writePump()
+	if resumed {
+		client.filter = sess.filter
+		client.name = sess.name
+		client.locale = sess.locale
+		log.Println("Client resumed session")
+	}
+
 	hub.mutex.Lock()
+	if capped && hub.full() {
+		// The cap may have been reached while we were upgrading.
+		if !queueable {
+			hub.mutex.Unlock()
+			hint, reason := reconnectPayloads(retryHint(), "too many clients")
+			// The write pump is running, so these go through the
+			// client's write lock.
+			client.writeNow(outFrame{messageType: websocket.TextMessage, payload: hint})
+			client.writeNow(outFrame{messageType: websocket.CloseMessage,
+				payload: websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)})
+			cancel()
+			leave()
+			connections.Done()
+			return
+		}
+		hub.queue = append(hub.queue, client)
+		position := len(hub.queue)
+		hub.notifyQueue()
+		hub.mutex.Unlock()
+		log.Printf("New client queued at position %d", position)
+		ops.clientEvent("client_connected", client, "queued")
+		go handleMessages(client)
+		return
+	}
+	if client.name != "" {
+		// A resumed client takes back its lingering roster entry.
+		delete(hub.lingering, client.name)
+		client.name = hub.uniqueName(client, client.name)
+	}
 	hub.clients[client] = true
 	hub.mutex.Unlock()
 
-	log.Println("New client connected")
+	log.Printf("New %s connected%s", role, tagString(client.tags))
+	sim.clientConnected()
+	ops.clientEvent("client_connected", client, "")
 
-	// Send the initial state to the newly connected client
-	gameState.mu.Lock()
-	initialState, _ := json.Marshal(gameState)
-	gameState.mu.Unlock()
-	client.conn.WriteMessage(websocket.TextMessage, initialState)
+	session, _ := json.Marshal(sessionMessage{
+		Type:        "session",
+		ResumeToken: client.resumeToken,
+		ExpiresInMs: resumeWindow.Milliseconds(),
+	})
+	client.write(session)
+
+	// Send the initial state to the newly connected client, unless it
+	// resumed and already holds the current version.
+	game.mu.Lock()
+	shown := game.joinState()
+	if view != nil {
+		shown = view.latest()
+	}
+	current := resumed && r.URL.Query().Get("version") == strconv.FormatUint(shown.Version, 10)
+	config := game.configPayload()
+	initialType, initialState := websocket.TextMessage, game.snapshot()
+	if client.filter != nil || client.binary || client.locale != "" || broadcastTransform != nil || view != nil {
+		initialType, initialState = shown.encodeFor(client, client.filter, client.locale)
+	}
+	game.mu.Unlock()
+	client.write(config)
+	if !current {
+		client.writeFrame(initialType, initialState)
+	}
+	for _, sub := range subs {
+		sub.game.mu.Lock()
+		snapshot := sub.game.snapshot()
+		sub.game.mu.Unlock()
+		client.write(tagged(sub.game, snapshot))
+	}
+
+	// Anonymous viewers don't change the roster, so only they need to see it.
+	if client.name != "" {
+		hub.broadcastRoster(game)
+	} else {
+		client.write(hub.roster(game))
+	}
 
 	// Listen for messages from this client in a new goroutine
 	go handleMessages(client)
 }
 
+// writeJoinError replies to a connection that couldn't join its match.
+func writeJoinError(w http.ResponseWriter, err error) {
+	status, code := http.StatusBadRequest, CodeBadRequest
+	switch {
+	case errors.Is(err, ErrTooManyRooms):
+		status, code = http.StatusServiceUnavailable, CodeUnavailable
+	case errors.Is(err, ErrSetupConflict), errors.Is(err, ErrMatchExists):
+		status, code = http.StatusConflict, CodeConflict
+	}
+	writeJSONError(w, status, code, err.Error())
+}
+
+// initialize prepares the game before traffic is accepted, then marks the
+// server ready once the READY_DELAY warmup has passed. Background workers
+// stop when ctx is cancelled.
+func initialize(ctx context.Context) {
+	scheduleStart()
+	scheduleEnd()
+	scheduleExpiry(ctx)
+	if feed != nil {
+		go runFeed(ctx, feed, envDuration("FEED_INTERVAL", 5*time.Second))
+		log.Println("Following external score feed")
+	}
+	if sim != nil {
+		go sim.run(ctx)
+		log.Printf("Simulator scoring every %s", sim.interval)
+	}
+	if mqtt != nil {
+		go mqtt.run(ctx)
+		log.Printf("Publishing state to MQTT broker %s", mqtt.addr)
+	}
+	if webhook != nil {
+		go webhook.run(ctx)
+		log.Printf("Posting score events to %s", webhook.url)
+	}
+
+	time.Sleep(envDuration("READY_DELAY", 0))
+	ready.Store(true)
+	log.Println("Server ready")
+}
+
+// routes registers the server's endpoints on mux.
+func routes(mux *http.ServeMux) {
+	mux.HandleFunc("/ws", serveWs)
+	mux.HandleFunc("/control", serveControl)
+	mux.HandleFunc("/score", serveScore)
+	mux.HandleFunc("/next", serveNext)
+	mux.HandleFunc("GET /diff", serveDiff)
+	mux.HandleFunc("GET /replay", serveReplay)
+	mux.HandleFunc("/stats", serveStats)
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", serveReadyz)
+	mux.HandleFunc("/version", serveVersion)
+	mux.HandleFunc("/corrections", requireMatchAdmin(serveCorrections))
+	mux.HandleFunc("GET /archive/{id}", requireAdmin(serveArchive))
+	mux.HandleFunc("/admin/reset-all", requireMatchAdmin(serveResetAll))
+	mux.HandleFunc("GET /admin/matches/{id}/clients", requireMatchAdmin(serveMatchClients))
+	mux.HandleFunc("POST /admin/matches/{id}/rebroadcast", requireMatchAdmin(serveRebroadcast))
+	mux.HandleFunc("GET /admin/matches/{id}/export", requireMatchAdmin(serveExport))
+	mux.HandleFunc("POST /admin/matches/{id}/read-only", requireMatchAdmin(serveReadOnly))
+	mux.HandleFunc("POST /admin/import", requireAdmin(serveImport))
+	mux.HandleFunc("POST /admin/merge", requireMatchAdmin(serveMerge))
+	mux.HandleFunc("/admin/sim/", requireAdmin(serveSimulator))
+	mux.HandleFunc("/admin/ws", requireAdmin(serveOps))
+	mux.HandleFunc("GET /debug/stats", requireAdmin(serveDebugStats))
+	registerPprof(mux)
+	mux.Handle("/", staticHandler())
+}
+
 func main() {
-	http.HandleFunc("/ws", serveWs)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "index.html")
-	})
+	gameState.ResetTimeouts()
+	if err := persist.restore(&gameState); err != nil {
+		log.Fatalf("restoring match: %v", err)
+	}
+	warnRoomLimits()
+	// Decided from the clock at boot, so a restart around the start time
+	// opens the match exactly when it should.
+	gameState.NotStarted = gameState.StartsAt.After(time.Now())
+	if gameState.CreatedAt.IsZero() {
+		gameState.CreatedAt = time.Now()
+	}
+
+	routes(http.DefaultServeMux)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	startViews(ctx)
+	go initialize(ctx)
+
+	srv := newServer(":8080")
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		ready.Store(false)
+		grace := envDuration("SHUTDOWN_GRACE", 10*time.Second)
+		log.Printf("Shutting down, grace %s", grace)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		// Shutdown doesn't track hijacked WebSocket connections, so close
+		// those ourselves.
+		closed := make(chan struct{})
+		go func() {
+			hub.closeAll(grace, clientCloseTimeout, "server shutting down")
+			close(closed)
+		}()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		<-closed
+		connections.Wait()
+	}()
 
 	log.Println("Server starting on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe: ", err)
 	}
+	<-stopped
+	log.Println("Server stopped")
 }
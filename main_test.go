@@ -0,0 +1,942 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"livescore/engine"
+)
+
+func TestMain(m *testing.M) {
+	// The server logs every action; keep test output to the failures.
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// testControllerToken is the CONTROLLER_TOKEN of every test server.
+const testControllerToken = "controller-secret"
+
+// testAdminToken is the ADMIN_TOKEN set by withAdmin.
+const testAdminToken = "admin-secret"
+
+// withAdmin enables the admin endpoints for the test. Like setVar, call it
+// before newTestServer.
+func withAdmin(t *testing.T) {
+	setVar(t, &adminToken, testAdminToken)
+}
+
+// baseOptions are the match options the environment gave the default match,
+// restored before each test.
+var baseOptions = gameState.Options
+
+// withOptions changes the match options of the test's default match. Like
+// setVar, call it before newTestServer.
+func withOptions(t *testing.T, change func(*MatchOptions)) {
+	t.Helper()
+	opts := baseOptions
+	change(&opts)
+	setVar(t, &baseOptions, opts)
+}
+
+// setVar sets *p to v for the rest of the test. Call it before
+// newTestServer, so the old value is only restored once the server's
+// goroutines have stopped.
+func setVar[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// resetState puts the server's global state back to a fresh default match
+// with no clients or rooms.
+func resetState() {
+	gameState = GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 1, Options: baseOptions, CreatedAt: time.Now()}}
+	// As main does before restoring.
+	gameState.ResetTimeouts()
+	hub = Hub{clients: make(map[*Client]bool), lingering: make(map[string]*Client), sentVersion: make(map[*GameState]uint64)}
+	rooms = &roomRegistry{rooms: make(map[string]*room)}
+	publicScore = &scoreCache{}
+	resumeTokens = &resumeStore{sessions: make(map[string]session)}
+	resyncLimiter = &diffLimiter{}
+	viewChannels = nil
+}
+
+// newTestServer starts the server's routes on a fresh state. The server is
+// closed, and its connections waited for, when the test ends.
+func newTestServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	resetState()
+	setVar(t, &controllerToken, testControllerToken)
+	mux := http.NewServeMux()
+	routes(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.CloseClientConnections()
+		srv.Close()
+		// Every connection must have cleaned up before the next test
+		// resets the state it uses.
+		connections.Wait()
+	})
+	return srv
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// wsURL turns the test server's URL and path into a WebSocket URL.
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+// dial opens a WebSocket connection to path, failing the test if the
+// handshake is refused.
+func dial(t testing.TB, srv *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(srv, path), nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dialing %s: %v (status %d)", path, err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialStatus attempts a handshake that is expected to be refused and
+// returns the HTTP status it got.
+func dialStatus(t *testing.T, srv *httptest.Server, path string) int {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(srv, path), nil)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("dialing %s: handshake succeeded", path)
+	}
+	if resp == nil {
+		t.Fatalf("dialing %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+// dialControl opens a controller connection with the test token. query is
+// appended to the URL, e.g. "match=final".
+func dialControl(t testing.TB, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	path := "/control?token=" + testControllerToken
+	if query != "" {
+		path += "&" + query
+	}
+	return dial(t, srv, path)
+}
+
+// frame is a decoded JSON frame from the server.
+type frame map[string]any
+
+// isState reports whether f is a match state rather than a typed message
+// or an error.
+func (f frame) isState() bool {
+	_, ok := f["teams"]
+	return ok && f["type"] == nil
+}
+
+// readFrame reads frames until one satisfies match, failing the test if
+// none arrives within two seconds.
+func readFrame(t testing.TB, conn *websocket.Conn, match func(frame) bool) frame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading frame: %v", err)
+		}
+		var f frame
+		if json.Unmarshal(payload, &f) == nil && match(f) {
+			return f
+		}
+	}
+}
+
+// readState reads frames until the next match state and decodes it.
+func readState(t testing.TB, conn *websocket.Conn) StateJSON {
+	t.Helper()
+	f := readFrame(t, conn, frame.isState)
+	payload, _ := json.Marshal(f)
+	var s StateJSON
+	if err := json.Unmarshal(payload, &s); err != nil {
+		t.Fatalf("decoding state: %v", err)
+	}
+	return s
+}
+
+// readStateWith reads states until one satisfies match.
+func readStateWith(t *testing.T, conn *websocket.Conn, match func(StateJSON) bool) StateJSON {
+	t.Helper()
+	for {
+		if s := readState(t, conn); match(s) {
+			return s
+		}
+	}
+}
+
+// readError reads frames until an error reply and returns its text.
+func readError(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	f := readFrame(t, conn, func(f frame) bool { return f["error"] != nil })
+	return f["error"].(string)
+}
+
+// send writes msg to the connection as JSON.
+func send(t *testing.T, conn *websocket.Conn, msg any) {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatalf("sending %v: %v", msg, err)
+	}
+}
+
+// score returns the score of the named team in s, failing the test if
+// there is no such team.
+func score(t *testing.T, s StateJSON, team string) float64 {
+	t.Helper()
+	for _, tm := range s.Teams {
+		if tm.Name == team {
+			return tm.Score
+		}
+	}
+	t.Fatalf("no team %q in %+v", team, s.Teams)
+	return 0
+}
+
+// scoreOf returns the score of the named team on the match gs, read under
+// its lock.
+func scoreOf(gs *GameState, team string) float64 {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.Team(team).Score
+}
+
+// get performs a GET against the test server, returning the response and
+// its body.
+func get(t *testing.T, srv *httptest.Server, path string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	return do(t, srv, http.MethodGet, path, header, "")
+}
+
+// admin performs an admin request with the test token.
+func admin(t *testing.T, srv *httptest.Server, method, path, body string) (*http.Response, []byte) {
+	t.Helper()
+	return do(t, srv, method, path, http.Header{"Authorization": {"Bearer " + testAdminToken}}, body)
+}
+
+// do performs a request against the test server, returning the response
+// and its body.
+func do(t *testing.T, srv *httptest.Server, method, path string, header http.Header, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, path, err)
+	}
+	return resp, b
+}
+
+func TestConnectWhileBroadcasting(t *testing.T) {
+	srv := newTestServer(t)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			// Paced so the clients keep up rather than being dropped
+			// as too slow.
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+			if err := applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true}); err != nil {
+				t.Errorf("increment: %v", err)
+				return
+			}
+		}
+	}()
+
+	// Each connection's initial state is written as broadcasts reach it;
+	// the race detector flags any unserialized write. Rooms are opened and
+	// closed alongside, competing for the same hub.
+	for i := 0; i < 20; i++ {
+		conn := dial(t, srv, "/ws")
+		readState(t, conn)
+		room := dialControl(t, srv, fmt.Sprintf("match=room-%d", i%3))
+		send(t, room, Message{Action: "increment", Team: "A"})
+		readState(t, room)
+		conn.Close()
+		room.Close()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestBinaryFrameActionIsApplied(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	payload, _ := json.Marshal(Message{Action: "increment", Team: "A"})
+	if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		t.Fatal(err)
+	}
+	if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version > 0 }); score(t, s, "A") != 1 {
+		t.Errorf("A = %v after a binary-framed increment, want 1", score(t, s, "A"))
+	}
+}
+
+func TestDisconnectDuringBroadcasts(t *testing.T) {
+	srv := newTestServer(t)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+		}
+	}()
+
+	// Clients hang up, cleanly or not, while broadcasts are queued for
+	// them; nothing may send on a closed client or race its cleanup.
+	var clients sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		clients.Add(1)
+		go func(i int) {
+			defer clients.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "/ws"), nil)
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			conn.ReadMessage()
+			if i%2 == 0 {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			}
+			conn.Close()
+		}(i)
+	}
+	clients.Wait()
+	close(stop)
+	wg.Wait()
+	waitFor(t, "every client to unregister", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 0
+	})
+}
+
+func TestConnectionGoroutinesExitOnDisconnect(t *testing.T) {
+	srv := newTestServer(t)
+	before := runtime.NumGoroutine()
+
+	var conns []*websocket.Conn
+	for i := 0; i < 5; i++ {
+		conn := dial(t, srv, "/ws")
+		readState(t, conn)
+		conns = append(conns, dialControl(t, srv, "match=room"), conn)
+	}
+	if n := runtime.NumGoroutine(); n <= before {
+		t.Fatalf("%d goroutines with 10 connections, %d without", n, before)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	// The read loop, write pump and heartbeat of each connection all end
+	// with its context.
+	waitFor(t, "connection goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestResetOnEmpty(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reset=%t", reset), func(t *testing.T) {
+			withOptions(t, func(o *MatchOptions) { o.ResetOnEmpty = reset })
+			srv := newTestServer(t)
+
+			conn := dialControl(t, srv, "")
+			readState(t, conn)
+			send(t, conn, Message{Action: "increment", Team: "A", Value: 2})
+			readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+			conn.Close()
+			waitFor(t, "the client to leave", func() bool {
+				hub.mutex.Lock()
+				defer hub.mutex.Unlock()
+				return len(hub.clients) == 0
+			})
+
+			want := 2.0
+			if reset {
+				want = 0
+				waitFor(t, "the reset", func() bool { return scoreOf(&gameState, "A") == 0 })
+			}
+			again := dial(t, srv, "/ws")
+			if s := readState(t, again); score(t, s, "A") != want {
+				t.Errorf("A = %v on reconnecting, want %v", score(t, s, "A"), want)
+			}
+		})
+	}
+}
+
+func TestPlainHTTPOnWebSocketPaths(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/ws", http.StatusUpgradeRequired, CodeUpgradeRequired},
+		{http.MethodGet, "/control?token=" + testControllerToken, http.StatusUpgradeRequired, CodeUpgradeRequired},
+		{http.MethodPost, "/ws", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	} {
+		resp, body := do(t, srv, tc.method, tc.path, nil, "")
+		var e apiError
+		json.Unmarshal(body, &e)
+		if resp.StatusCode != tc.status || e.Error.Code != tc.code {
+			t.Errorf("%s %s: %d %s, want %d with code %q", tc.method, tc.path, resp.StatusCode, body, tc.status, tc.code)
+		}
+	}
+	if resp, _ := get(t, srv, "/ws", nil); resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("plain GET /ws: Upgrade header %q, want websocket", resp.Header.Get("Upgrade"))
+	}
+}
+
+// captureLog collects the server's log output for the rest of the test.
+func captureLog(t *testing.T) func() string {
+	var mu sync.Mutex
+	var buf strings.Builder
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}))
+	t.Cleanup(func() {
+		if testing.Verbose() {
+			log.SetOutput(os.Stderr)
+		} else {
+			log.SetOutput(io.Discard)
+		}
+	})
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.String()
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestDropIsIdempotent(t *testing.T) {
+	logs := captureLog(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{ctx: ctx, cancel: cancel}
+
+	c.drop("broadcast error", errors.New("disk on fire"))
+	c.drop("broadcast error", errors.New("disk on fire"))
+	if ctx.Err() == nil {
+		t.Error("drop left the client's context live")
+	}
+	if n := strings.Count(logs(), "disk on fire"); n != 1 {
+		t.Errorf("dropping twice logged %d errors, want 1:\n%s", n, logs())
+	}
+	for _, err := range []error{websocket.ErrCloseSent, net.ErrClosed, syscall.EPIPE, syscall.ECONNRESET} {
+		if !peerGone(fmt.Errorf("write: %w", err)) {
+			t.Errorf("peerGone(%v) = false", err)
+		}
+	}
+}
+
+func TestEvictionRacingCleanupIsQuiet(t *testing.T) {
+	logs := captureLog(t)
+	srv := newTestServer(t)
+
+	var conns []*websocket.Conn
+	for i := 0; i < 20; i++ {
+		conn := dial(t, srv, "/ws")
+		readState(t, conn)
+		conns = append(conns, conn)
+	}
+	hub.mutex.Lock()
+	var clients []*Client
+	for c := range hub.clients {
+		clients = append(clients, c)
+	}
+	hub.mutex.Unlock()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+		}
+	}()
+	// Half the connections close from the server's side, as their read
+	// loop's cleanup would, and half from the peer's, while broadcasts
+	// keep writing to them.
+	for i := range conns {
+		if i%2 == 0 {
+			clients[i].cancel()
+		} else {
+			conns[i].Close()
+		}
+	}
+	waitFor(t, "every client to unregister", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 0
+	})
+	close(stop)
+	wg.Wait()
+
+	if strings.Contains(logs(), "broadcast error") {
+		t.Errorf("evicting closed clients logged errors:\n%s", logs())
+	}
+}
+
+func TestNoOpActionIsAckedNotBroadcast(t *testing.T) {
+	for _, dedup := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dedup=%v", dedup), func(t *testing.T) {
+			setVar(t, &dedupBroadcasts, dedup)
+			srv := newTestServer(t)
+
+			console := dialControl(t, srv, "")
+			readState(t, console)
+			viewer := dial(t, srv, "/ws")
+			readState(t, viewer)
+
+			send(t, console, Message{Action: "increment", Team: "A"})
+			readState(t, viewer)
+			// A client's decrement at zero is refused, so the no-op is a
+			// set to the score the team has.
+			send(t, console, Message{Action: "set", Team: "A", Value: 1})
+			if dedup {
+				ack := readFrame(t, console, func(f frame) bool { return f["type"] == "ack" })
+				if ack["action"] != "set" || ack["version"] != 2.0 {
+					t.Errorf("ack = %v, want the set at version 2", ack)
+				}
+			}
+			send(t, console, Message{Action: "increment", Team: "A"})
+			s := readState(t, viewer)
+			switch {
+			case dedup && s.Version != 3:
+				t.Errorf("viewer's next state is version %d, want 3: the no-op was broadcast", s.Version)
+			case !dedup && s.Version != 2:
+				t.Errorf("viewer's next state is version %d, want the no-op's 2", s.Version)
+			}
+		})
+	}
+}
+
+func TestEchoPrecedesBroadcastWithItsVersion(t *testing.T) {
+	srv := newTestServer(t)
+
+	echo := dialControl(t, srv, "echo=1")
+	readState(t, echo)
+	plain := dialControl(t, srv, "")
+	readState(t, plain)
+
+	send(t, echo, Message{Action: "increment", Team: "A", Value: 2})
+	first, second := readState(t, echo), readState(t, echo)
+	if first.Version != 1 || score(t, first, "A") != 2 {
+		t.Errorf("echo at version %d with A=%v, want version 1 with A=2", first.Version, score(t, first, "A"))
+	}
+	if second.Version != first.Version {
+		t.Errorf("broadcast after the echo at version %d, want the echo's %d", second.Version, first.Version)
+	}
+
+	// Other connections, and actions without ?echo=1, get the broadcast
+	// alone.
+	send(t, plain, Message{Action: "increment", Team: "B"})
+	if s := readState(t, plain); s.Version != 1 {
+		t.Errorf("plain controller's first state at version %d, want 1", s.Version)
+	}
+	if s := readState(t, plain); s.Version != 2 {
+		t.Errorf("plain controller's own action arrived at version %d, want 2 and once", s.Version)
+	}
+	if s := readState(t, echo); s.Version != 2 {
+		t.Errorf("echo controller got version %d for another's action, want 2", s.Version)
+	}
+}
+
+func TestConcurrentResetAndIncrement(t *testing.T) {
+	const workers, actions = 8, 100
+	// Room for every broadcast, so the viewer isn't dropped as too slow.
+	setVar(t, &sendBuffer, workers*actions+1)
+	srv := newTestServer(t)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range actions {
+				msg := Message{Action: "increment", Team: "A", Trusted: true}
+				if w%2 == 0 {
+					msg = Message{Action: "reset", Trusted: true}
+				}
+				if err := applyAndBroadcast(msg); err != nil {
+					t.Errorf("%s: %v", msg.Action, err)
+					return
+				}
+			}
+		}()
+	}
+	// A reset of a zeroed score isn't broadcast, so the hammering ends
+	// on an increment everyone sees.
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		wg.Wait()
+		applyAndBroadcast(Message{Action: "increment", Team: "B", Trusted: true})
+	}()
+	// The last broadcast must be done with the hub before the next test
+	// resets it.
+	defer func() { <-finished }()
+
+	const total = workers*actions + 1
+	var version uint64
+	last := readStateWith(t, viewer, func(s StateJSON) bool {
+		if s.Version < version {
+			t.Fatalf("viewer got version %d after %d", s.Version, version)
+		}
+		version = s.Version
+		return s.Version == total
+	})
+	if a := scoreOf(&gameState, "A"); score(t, last, "A") != a || score(t, last, "B") != 1 {
+		t.Errorf("last broadcast A = %v B = %v, live state A = %v B = 1", score(t, last, "A"), score(t, last, "B"), a)
+	}
+
+	// Every action, resets included, was logged at its own version.
+	gameState.mu.Lock()
+	defer gameState.mu.Unlock()
+	if len(gameState.events) != total {
+		t.Fatalf("%d events logged, want %d", len(gameState.events), total)
+	}
+	for i, ev := range gameState.events {
+		if ev.Version != uint64(i+1) {
+			t.Fatalf("event %d (%s) at version %d, want %d", i, ev.Message.Action, ev.Version, i+1)
+		}
+	}
+}
+
+func TestStaleBroadcastIsDropped(t *testing.T) {
+	srv := newTestServer(t)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+
+	// Committed states whose broadcasts reach the fan-out out of
+	// order, as they may once the game lock is released.
+	states := make([]*GameState, 3)
+	for i := range states {
+		applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+		readState(t, viewer)
+		gameState.mu.Lock()
+		states[i] = gameState.clone()
+		gameState.mu.Unlock()
+	}
+	for _, i := range []int{2, 1, 0} {
+		hub.broadcastTo(&gameState, states[i].broadcastPayload(), states[i], time.Time{})
+	}
+	applyAndBroadcast(Message{Action: "increment", Team: "B", Trusted: true})
+
+	if s := readState(t, viewer); s.Version != 3 {
+		t.Errorf("first resent state at version %d, want 3", s.Version)
+	}
+	if s := readState(t, viewer); s.Version != 4 {
+		t.Errorf("next state at version %d, want 4: an older state went out after version 3", s.Version)
+	}
+}
+
+func TestUnknownMessageFields(t *testing.T) {
+	const newer = `{"action":"increment","team":"A","priority":"high"}`
+	t.Run("strict", func(t *testing.T) {
+		srv := newTestServer(t)
+		conn := dialControl(t, srv, "")
+		readState(t, conn)
+
+		for payload, field := range map[string]string{
+			`{"acton":"increment","team":"A"}`: `"acton"`,
+			newer:                              `"priority"`,
+		} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+				t.Fatal(err)
+			}
+			if text := readError(t, conn); !strings.Contains(text, "unknown field "+field) {
+				t.Errorf("sending %s: error %q, want it to name %s", payload, text, field)
+			}
+		}
+		if scoreOf(&gameState, "A") != 0 {
+			t.Error("a message with an unknown field was applied")
+		}
+	})
+	t.Run("lenient", func(t *testing.T) {
+		setVar(t, &strictMessages, false)
+		srv := newTestServer(t)
+		conn := dialControl(t, srv, "")
+		readState(t, conn)
+
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(newer)); err != nil {
+			t.Fatal(err)
+		}
+		if s := readStateWith(t, conn, func(s StateJSON) bool { return s.Version > 0 }); score(t, s, "A") != 1 {
+			t.Errorf("A = %v after an increment with a newer field, want 1", score(t, s, "A"))
+		}
+	})
+}
+
+// benchClients is the audience of the fan-out benchmarks.
+const benchClients = 5000
+
+// dialAudience connects n viewers that negotiate compression and returns a
+// count of the frames they have all received since.
+func dialAudience(b *testing.B, srv *httptest.Server, n int) *atomic.Int64 {
+	b.Helper()
+	dialer := websocket.Dialer{EnableCompression: true}
+	received := new(atomic.Int64)
+	for range n {
+		conn, _, err := dialer.Dial(wsURL(srv, "/ws"), nil)
+		if err != nil {
+			b.Fatalf("dialing viewer: %v", err)
+		}
+		b.Cleanup(func() { conn.Close() })
+		readState(b, conn)
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				received.Add(1)
+			}
+		}()
+	}
+	waitFor(b, "the audience to register", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == n
+	})
+	return received
+}
+
+// awaitDelivery waits until received has counted want frames.
+func awaitDelivery(b *testing.B, received *atomic.Int64, want int64) {
+	deadline := time.Now().Add(30 * time.Second)
+	for received.Load() < want {
+		if time.Now().After(deadline) {
+			b.Fatalf("delivered %d of %d frames", received.Load(), want)
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+// BenchmarkBroadcastFanout broadcasts a state to benchClients viewers with
+// permessage-deflate, framed and compressed once as a PreparedMessage as
+// broadcastTo does, against the per-client writes it falls back to.
+func BenchmarkBroadcastFanout(b *testing.B) {
+	setVar(b, &upgrader.EnableCompression, true)
+	srv := newTestServer(b)
+	received := dialAudience(b, srv, benchClients)
+	gameState.mu.Lock()
+	message := gameState.broadcastPayload()
+	gameState.mu.Unlock()
+
+	fanouts := []struct {
+		name string
+		send func()
+	}{
+		{"prepared", func() { hub.broadcastTo(&gameState, message, nil, time.Time{}) }},
+		{"per-client", func() {
+			hub.mutex.Lock()
+			clients := make([]*Client, 0, len(hub.clients))
+			for c := range hub.clients {
+				clients = append(clients, c)
+			}
+			hub.mutex.Unlock()
+			for _, c := range clients {
+				c.enqueue(outFrame{messageType: websocket.TextMessage, payload: message})
+			}
+		}},
+	}
+	for _, f := range fanouts {
+		b.Run(f.name, func(b *testing.B) {
+			for range b.N {
+				want := received.Load() + benchClients
+				f.send()
+				awaitDelivery(b, received, want)
+			}
+		})
+	}
+}
+
+// BenchmarkRegistrationUnderBroadcast times a client joining and leaving
+// the hub, the h.mutex sections of serveClient and its cleanup, with the
+// hub idle and while states fan out to benchClients viewers back to back.
+// The fan-out holds h.mutex only to copy the client set, so the two should
+// stay close.
+func BenchmarkRegistrationUnderBroadcast(b *testing.B) {
+	srv := newTestServer(b)
+	received := dialAudience(b, srv, benchClients)
+	// Muted, so broadcasts pass it over as they would a client still
+	// sending its snapshot.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &Client{ctx: ctx, cancel: cancel, role: RoleViewer, muted: true}
+	client.game.Store(&gameState)
+
+	for _, load := range []bool{false, true} {
+		name := "idle"
+		if load {
+			name = "broadcasting"
+		}
+		b.Run(name, func(b *testing.B) {
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for load {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					// Paced by delivery, so the audience keeps up
+					// rather than being dropped as too slow.
+					want := received.Load() + benchClients
+					applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+					awaitDelivery(b, received, want)
+				}
+			}()
+			for range b.N {
+				hub.mutex.Lock()
+				hub.clients[client] = true
+				hub.mutex.Unlock()
+				hub.mutex.Lock()
+				delete(hub.clients, client)
+				hub.mutex.Unlock()
+			}
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
+
+func TestViewerCapTurnsRacersAway(t *testing.T) {
+	setVar(t, &maxClients, 1)
+	srv := newTestServer(t)
+
+	// Viewers racing for the last place may pass the check before the
+	// upgrade and be turned away after it, with a close frame.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var admitted, refused int
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "/ws"), nil)
+			if err != nil {
+				mu.Lock()
+				refused++
+				mu.Unlock()
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			for {
+				_, payload, err := conn.ReadMessage()
+				if websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+					mu.Lock()
+					refused++
+					mu.Unlock()
+					return
+				}
+				if err != nil {
+					t.Errorf("reading: %v", err)
+					return
+				}
+				if strings.Contains(string(payload), `"teams"`) {
+					mu.Lock()
+					admitted++
+					mu.Unlock()
+					// Held open until every racer is answered.
+					conn.SetReadDeadline(time.Now().Add(time.Second))
+					conn.ReadMessage()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted != 1 || refused != 9 {
+		t.Errorf("%d admitted and %d refused, want 1 and 9", admitted, refused)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"livescore/engine"
+)
+
+// Merge modes: "sum" adds the source's scores to the target's, "replace"
+// gives the target the source's scores.
+const (
+	MergeSum     = "sum"
+	MergeReplace = "replace"
+)
+
+// Errors for merges that can't be made.
+var (
+	ErrMergeTeams    = errors.New("matches have different teams")
+	ErrMergeBusy     = errors.New("source match has connections in progress, retry")
+	ErrMergeReadOnly = errors.New("target match is read-only")
+)
+
+// mergeSummary reports what a merge moved into the target.
+type mergeSummary struct {
+	From    string `json:"from"`
+	Into    string `json:"into"`
+	Mode    string `json:"mode"`
+	Clients int    `json:"clients"`
+	Events  int    `json:"events"`
+}
+
+// serveMerge consolidates two boards started by mistake for the same game:
+// POST /admin/merge?from=a&into=b&mode=sum folds the room a into the match
+// b, then closes a. With ?tenant= both are the tenant's matches. See
+// roomRegistry.merge.
+func serveMerge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fromID, intoID := q.Get("from"), q.Get("into")
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = MergeSum
+	}
+	switch {
+	case fromID == "" || intoID == "":
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "from and into name the matches to merge")
+		return
+	case fromID == intoID:
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "a match can't be merged into itself")
+		return
+	case fromID == defaultMatchID && requestTenant(r) == "":
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("the %s match can't be merged away", defaultMatchID))
+		return
+	case mode != MergeSum && mode != MergeReplace:
+		writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("mode must be %q or %q, got %q", MergeSum, MergeReplace, mode))
+		return
+	}
+	from, ok := rooms.lookup(scopedMatchID(r, fromID))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match "+fromID)
+		return
+	}
+	into, ok := lookupMatch(scopedMatchID(r, intoID))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match "+intoID)
+		return
+	}
+
+	summary, err := rooms.merge(from, into, mode)
+	switch {
+	case errors.Is(err, ErrMergeTeams), errors.Is(err, ErrMergeBusy), errors.Is(err, ErrMergeReadOnly):
+		writeJSONError(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	case errors.Is(err, engine.ErrInvalidValue):
+		writeJSONError(w, http.StatusConflict, CodeConflict, "merged scores don't fit the target match: "+err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	log.Printf("Merged match %s into %s (%s): %d clients, %d events", fromID, intoID, mode, summary.Clients, summary.Events)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// merge folds the room from into the match into, which must have the same
+// teams. The target's scores become the sum of both, or the source's, set
+// through trusted "set" actions after the source's event log is appended
+// to the target's, so replaying the target ends on the merged score. The
+// source's clients move to the target, which broadcasts to them all, and
+// the source room closes; in snapshot mode its saved state is reset so it
+// reopens fresh. Subscriptions to the source follow it to the target.
+//
+// Everything happens under one hold of r.mu, from.mu and into.mu. A
+// connection the source room counted but the hub doesn't list yet, or no
+// longer, couldn't be moved, so the merge is refused while there is one.
+func (r *roomRegistry) merge(from, into *GameState, mode string) (mergeSummary, error) {
+	summary := mergeSummary{From: from.id, Into: into.matchID(), Mode: mode}
+	r.mu.Lock()
+	rm, ok := r.rooms[from.id]
+	if !ok || rm.state != from {
+		r.mu.Unlock()
+		return summary, fmt.Errorf("match %s closed", from.id)
+	}
+	from.mu.Lock()
+	into.mu.Lock()
+	fail := func(err error) (mergeSummary, error) {
+		into.mu.Unlock()
+		from.mu.Unlock()
+		r.mu.Unlock()
+		return summary, err
+	}
+
+	if into.ReadOnly {
+		return fail(ErrMergeReadOnly)
+	}
+	names := func(gs *GameState) []string {
+		var names []string
+		for _, t := range gs.Teams {
+			names = append(names, t.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	if !slices.Equal(names(from), names(into)) {
+		return fail(fmt.Errorf("%w: %v and %v", ErrMergeTeams, names(from), names(into)))
+	}
+	sets := make([]Message, 0, len(into.Teams))
+	for _, t := range into.Teams {
+		score := from.Team(t.Name).Score
+		if mode == MergeSum {
+			score += t.Score
+		}
+		if _, err := into.Options.ValidScore(score); err != nil {
+			return fail(fmt.Errorf("%s: %w", t.Name, err))
+		}
+		sets = append(sets, Message{Action: "set", Team: t.Name, Value: score, Actor: "admin merge of " + from.id, Trusted: true})
+	}
+
+	hub.mutex.Lock()
+	var moved []*Client
+	for c := range hub.clients {
+		if c.game.Load() == from {
+			moved = append(moved, c)
+		}
+	}
+	if len(moved)+hub.subscribersLocked(from) != rm.clients {
+		hub.mutex.Unlock()
+		return fail(ErrMergeBusy)
+	}
+	for _, c := range moved {
+		c.game.Store(into)
+	}
+	joined := len(moved) + hub.moveSubscriptionsLocked(from, into)
+	hub.mutex.Unlock()
+	if target, ok := r.rooms[into.id]; ok {
+		target.clients += joined
+	}
+	delete(r.rooms, from.id)
+	r.mu.Unlock()
+
+	for _, ev := range from.events {
+		into.Version++
+		ev.Version = into.Version
+		into.events = append(into.events, ev)
+	}
+	summary.Clients, summary.Events = len(moved), len(from.events)
+	from.Reset(engine.ResetAll)
+	persist.saveRoom(from)
+	from.mu.Unlock()
+
+	// The moved clients have only seen the source, so the broadcast must
+	// go out even if the target's score stays as it was.
+	into.lastFingerprint = nil
+	applied, err := into.applyLocked(sets)
+	if applied == 0 {
+		into.mu.Unlock()
+	} else {
+		broadcastLocked(into, nil, sets[:applied])
+	}
+	hub.forget(from)
+	scoreIntervals.forget(from.id)
+	hub.broadcastRoster(into)
+	ops.publish(opsEvent{Type: "match_destroyed", Match: from.id})
+	return summary, err
+}
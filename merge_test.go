@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestMergeSumsAndMovesClients(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	from := dialControl(t, srv, "match=a")
+	readState(t, from)
+	send(t, from, Message{Action: "increment", Team: "A", Value: 2})
+	readStateWith(t, from, func(s StateJSON) bool { return s.Version == 1 })
+	into := dialControl(t, srv, "match=b")
+	readState(t, into)
+	send(t, into, Message{Action: "increment", Team: "A"})
+	send(t, into, Message{Action: "increment", Team: "B", Value: 3})
+	readStateWith(t, into, func(s StateJSON) bool { return s.Version == 2 })
+
+	resp, body := admin(t, srv, http.MethodPost, "/admin/merge?from=a&into=b", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("merge: %d %s", resp.StatusCode, body)
+	}
+	var summary mergeSummary
+	json.Unmarshal(body, &summary)
+	if summary.Clients != 1 || summary.Events != 1 || summary.Mode != MergeSum {
+		t.Errorf("summary = %+v, want 1 client and 1 event summed", summary)
+	}
+
+	merged := func(s StateJSON) bool { return score(t, s, "A") == 3 && score(t, s, "B") == 3 }
+	readStateWith(t, into, merged)
+	readStateWith(t, from, merged)
+	if _, ok := rooms.lookup("a"); ok {
+		t.Error("source room still open")
+	}
+
+	// The moved connection now scores on the target.
+	send(t, from, Message{Action: "increment", Team: "B"})
+	if s := readStateWith(t, into, func(s StateJSON) bool { return score(t, s, "B") != 3 }); score(t, s, "B") != 4 {
+		t.Errorf("B = %v after the moved client scored, want 4", score(t, s, "B"))
+	}
+}
+
+func TestMergeReplaceIntoDefault(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+	applyAndBroadcast(Message{Action: "set", Team: "A", Value: 5, Trusted: true})
+
+	from := dialControl(t, srv, "match=a")
+	readState(t, from)
+	send(t, from, Message{Action: "increment", Team: "B", Value: 2})
+	readStateWith(t, from, func(s StateJSON) bool { return s.Version == 1 })
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+
+	resp, body := admin(t, srv, http.MethodPost, "/admin/merge?from=a&into="+defaultMatchID+"&mode=replace", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("merge: %d %s", resp.StatusCode, body)
+	}
+	s := readStateWith(t, viewer, func(s StateJSON) bool { return score(t, s, "B") == 2 })
+	if score(t, s, "A") != 0 {
+		t.Errorf("A = %v, want the source's 0", score(t, s, "A"))
+	}
+}
+
+func TestMergeRefusesDifferentTeams(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	readState(t, dialControl(t, srv, "match=a&teams=Lakers,Celtics"))
+	readState(t, dialControl(t, srv, "match=b"))
+
+	resp, body := admin(t, srv, http.MethodPost, "/admin/merge?from=a&into=b", "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("merge of different teams: %d %s, want 409", resp.StatusCode, body)
+	}
+	if _, ok := rooms.lookup("a"); !ok {
+		t.Error("refused merge closed the source room")
+	}
+
+	for path, want := range map[string]int{
+		"/admin/merge?from=a&into=a":                      http.StatusBadRequest,
+		"/admin/merge?from=" + defaultMatchID + "&into=a": http.StatusBadRequest,
+		"/admin/merge?from=a&into=b&mode=average":         http.StatusBadRequest,
+		"/admin/merge?from=missing&into=b":                http.StatusNotFound,
+	} {
+		if resp, _ := admin(t, srv, http.MethodPost, path, ""); resp.StatusCode != want {
+			t.Errorf("POST %s: %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// serveMetrics exposes server gauges in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	hub.mutex.Lock()
+	clients, queued := len(hub.clients), len(hub.queue)
+	hub.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP livescore_action_rate Client actions accepted in the last second, server-wide.\n")
+	fmt.Fprintf(w, "# TYPE livescore_action_rate gauge\n")
+	fmt.Fprintf(w, "livescore_action_rate %d\n", actionThrottle.currentRate())
+	fmt.Fprintf(w, "# HELP livescore_clients Live WebSocket connections.\n")
+	fmt.Fprintf(w, "# TYPE livescore_clients gauge\n")
+	fmt.Fprintf(w, "livescore_clients %d\n", clients)
+	fmt.Fprintf(w, "# HELP livescore_queued_clients Connections waiting for a live slot.\n")
+	fmt.Fprintf(w, "# TYPE livescore_queued_clients gauge\n")
+	fmt.Fprintf(w, "livescore_queued_clients %d\n", queued)
+	fmt.Fprintf(w, "# HELP livescore_rooms Matches hosted besides the default one.\n")
+	fmt.Fprintf(w, "# TYPE livescore_rooms gauge\n")
+	fmt.Fprintf(w, "livescore_rooms %d\n", rooms.count())
+	writeTagMetrics(w)
+	fmt.Fprintf(w, "# HELP livescore_oversized_broadcasts_total Broadcasts downgraded to the compact payload.\n")
+	fmt.Fprintf(w, "# TYPE livescore_oversized_broadcasts_total counter\n")
+	fmt.Fprintf(w, "livescore_oversized_broadcasts_total %d\n", oversizedBroadcasts.Load())
+	fmt.Fprintf(w, "# HELP livescore_resyncs_throttled_total /diff requests refused by the per-IP limit.\n")
+	fmt.Fprintf(w, "# TYPE livescore_resyncs_throttled_total counter\n")
+	fmt.Fprintf(w, "livescore_resyncs_throttled_total %d\n", resyncsThrottled.Load())
+	fmt.Fprintf(w, "# HELP livescore_resyncs_downgraded_total /diff requests answered with a snapshot instead of events.\n")
+	fmt.Fprintf(w, "# TYPE livescore_resyncs_downgraded_total counter\n")
+	fmt.Fprintf(w, "livescore_resyncs_downgraded_total %d\n", resyncsDowngraded.Load())
+	fmt.Fprintf(w, "# HELP livescore_broadcasts_skipped_total Broadcasts skipped because no client was connected.\n")
+	fmt.Fprintf(w, "# TYPE livescore_broadcasts_skipped_total counter\n")
+	fmt.Fprintf(w, "livescore_broadcasts_skipped_total %d\n", skippedBroadcasts.Load())
+	fmt.Fprintf(w, "# HELP livescore_broadcasts_deduped_total Broadcasts dropped because an action left the state unchanged.\n")
+	fmt.Fprintf(w, "# TYPE livescore_broadcasts_deduped_total counter\n")
+	fmt.Fprintf(w, "livescore_broadcasts_deduped_total %d\n", dedupedBroadcasts.Load())
+	fmt.Fprintf(w, "# HELP livescore_broadcasts_refused_total Broadcasts refused because the state was implausibly large.\n")
+	fmt.Fprintf(w, "# TYPE livescore_broadcasts_refused_total counter\n")
+	fmt.Fprintf(w, "livescore_broadcasts_refused_total %d\n", refusedBroadcasts.Load())
+	fmt.Fprintf(w, "# HELP livescore_broadcasts_coalesced_total Broadcasts held back by BROADCAST_RATE and merged into a later one.\n")
+	fmt.Fprintf(w, "# TYPE livescore_broadcasts_coalesced_total counter\n")
+	fmt.Fprintf(w, "livescore_broadcasts_coalesced_total %d\n", coalescedBroadcasts.Load())
+	fmt.Fprintf(w, "# HELP livescore_slow_clients_dropped_total Clients dropped because their send buffer filled up.\n")
+	fmt.Fprintf(w, "# TYPE livescore_slow_clients_dropped_total counter\n")
+	fmt.Fprintf(w, "livescore_slow_clients_dropped_total %d\n", slowClientDrops.Load())
+	fmt.Fprintf(w, "# HELP livescore_write_retries_total Write timeouts a stalled write to a client was retried past.\n")
+	fmt.Fprintf(w, "# TYPE livescore_write_retries_total counter\n")
+	fmt.Fprintf(w, "livescore_write_retries_total %d\n", writeRetryCount.Load())
+	scoreIntervals.write(w)
+}
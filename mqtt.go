@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// mqttKeepAlive is the keep-alive interval announced to the broker.
+const mqttKeepAlive = 60 * time.Second
+
+// mqttMessage is one state publication.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttPublisher mirrors broadcasts to an MQTT broker for IoT scoreboards
+// that can't speak WebSocket. It implements just enough of MQTT 3.1.1 to
+// publish retained QoS 0 messages, so boards that subscribe late still get
+// the current state. Publishing never blocks the broadcast path: messages go
+// through a small queue and the oldest are dropped if the broker falls
+// behind.
+type mqttPublisher struct {
+	addr     string
+	clientID string
+	queue    chan mqttMessage
+}
+
+// mqtt is the active publisher, or nil unless MQTT_BROKER is set (as
+// host:port or tcp://host:port).
+var mqtt = mqttFromEnv()
+
+func mqttFromEnv() *mqttPublisher {
+	broker := envString("MQTT_BROKER", "")
+	if broker == "" {
+		return nil
+	}
+	return &mqttPublisher{
+		addr:     strings.TrimPrefix(broker, "tcp://"),
+		clientID: envString("MQTT_CLIENT_ID", "livescore"),
+		queue:    make(chan mqttMessage, 16),
+	}
+}
+
+// publishState queues the serialized state of a match for the broker under
+// livescore/{match}/state. Safe on a nil publisher.
+func (p *mqttPublisher) publishState(match string, payload []byte) {
+	if p == nil {
+		return
+	}
+	msg := mqttMessage{topic: "livescore/" + match + "/state", payload: payload}
+	for {
+		select {
+		case p.queue <- msg:
+			return
+		default:
+		}
+		// Full: the newest state supersedes the oldest queued one.
+		select {
+		case <-p.queue:
+		default:
+		}
+	}
+}
+
+// run keeps a broker connection open, reconnecting with backoff, and
+// publishes queued messages until ctx is cancelled.
+func (p *mqttPublisher) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := p.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("mqtt: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// session runs one broker connection until it fails or ctx ends.
+func (p *mqttPublisher) session(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttConnectPacket(p.clientID)); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	if err := readConnack(r); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("mqtt: connected to %s", p.addr)
+
+	// Drain inbound packets (PINGRESP) so the broker never stalls, and learn
+	// when the connection drops.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, r)
+		if err == nil {
+			err = io.EOF
+		}
+		readErr <- err
+	}()
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		var packet []byte
+		select {
+		case <-ctx.Done():
+			conn.Write([]byte{0xE0, 0x00}) // DISCONNECT
+			return ctx.Err()
+		case err := <-readErr:
+			return fmt.Errorf("connection lost: %w", err)
+		case msg := <-p.queue:
+			packet = mqttPublishPacket(msg.topic, msg.payload)
+		case <-ping.C:
+			packet = []byte{0xC0, 0x00} // PINGREQ
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// mqttString encodes a length-prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket prepends the fixed header, with its variable-length remaining
+// length, to body.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// mqttConnectPacket builds a clean-session CONNECT.
+func mqttConnectPacket(clientID string) []byte {
+	body := mqttString("MQTT")
+	body = append(body, 4, 0x02) // protocol level 3.1.1, clean session
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, mqttString(clientID)...)
+	return mqttPacket(0x10, body)
+}
+
+// mqttPublishPacket builds a retained QoS 0 PUBLISH.
+func mqttPublishPacket(topic string, payload []byte) []byte {
+	return mqttPacket(0x31, append(mqttString(topic), payload...))
+}
+
+// readConnack waits for the broker's CONNACK and checks it accepted us.
+func readConnack(r *bufio.Reader) error {
+	var ack [4]byte
+	if _, err := io.ReadFull(r, ack[:]); err != nil {
+		return fmt.Errorf("reading CONNACK: %w", err)
+	}
+	if ack[0] != 0x20 || ack[1] != 0x02 {
+		return errors.New("unexpected reply to CONNECT")
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("broker refused connection (code %d)", ack[3])
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// opsEvent is one server-wide event on the /admin/ws stream.
+type opsEvent struct {
+	Type    string    `json:"type"` // client_connected, client_disconnected, error, match_created, match_destroyed
+	At      time.Time `json:"at"`
+	Match   string    `json:"match"`
+	Client  uint64    `json:"client,omitempty"`
+	Role    string    `json:"role,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// opsHub fans server events out to ops dashboards. Publishing never blocks
+// and takes only opsHub's own lock, so it is safe from any path, including
+// with the game or client hub locked.
+type opsHub struct {
+	mu   sync.Mutex
+	subs map[chan opsEvent]bool
+}
+
+var ops = &opsHub{subs: make(map[chan opsEvent]bool)}
+
+// publish sends ev to every subscriber, dropping it for those that lag.
+func (o *opsHub) publish(ev opsEvent) {
+	ev.At = time.Now().UTC()
+	if ev.Match == "" {
+		ev.Match = defaultMatchID
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for ch := range o.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// clientEvent publishes a connection event for c.
+func (o *opsHub) clientEvent(kind string, c *Client, message string) {
+	o.publish(opsEvent{Type: kind, Match: c.game.Load().matchID(), Client: c.id, Role: c.role, IP: c.ip, Message: message})
+}
+
+func (o *opsHub) subscribe() chan opsEvent {
+	ch := make(chan opsEvent, 64)
+	o.mu.Lock()
+	o.subs[ch] = true
+	o.mu.Unlock()
+	return ch
+}
+
+func (o *opsHub) unsubscribe(ch chan opsEvent) {
+	o.mu.Lock()
+	delete(o.subs, ch)
+	o.mu.Unlock()
+}
+
+// serveOps streams server-wide events to an ops dashboard until it
+// disconnects.
+func serveOps(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tuneConn(conn)
+	log.Printf("Ops dashboard connected from %s", clientIP(r))
+
+	events := ops.subscribe()
+	defer ops.unsubscribe(events)
+
+	// The dashboard only listens; reading notices when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case ev := <-events:
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	}
+}
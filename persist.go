@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Persistence strategies for PERSIST_MODE.
+const (
+	PersistSnapshot = "snapshot" // rewrite the latest state after each change
+	PersistEvents   = "events"   // append every applied action and replay it
+)
+
+// persistedEvent is one line of the event log. The unexported Message fields
+// are recorded explicitly so a replay attributes actions as they happened.
+type persistedEvent struct {
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor,omitempty"`
+	Message Message   `json:"message"`
+}
+
+// persister saves the match so it survives restarts. In snapshot mode only
+// the latest state is kept, in the Store chosen by PERSIST_STORE. In events
+// mode every applied action is appended to PERSIST_FILE as a JSON line and
+// replayed through applyAction on startup, keeping the full history
+// auditable.
+type persister struct {
+	path  string
+	mode  string
+	log   *os.File
+	store Store
+}
+
+// persist is the active persister, or nil when neither PERSIST_FILE nor
+// PERSIST_STORE is set.
+var persist = persisterFromEnv()
+
+func persisterFromEnv() *persister {
+	path := envString("PERSIST_FILE", "")
+	backend := envString("PERSIST_STORE", "")
+	if path == "" && backend == "" {
+		return nil
+	}
+	mode := envString("PERSIST_MODE", PersistSnapshot)
+	if mode != PersistSnapshot && mode != PersistEvents {
+		log.Printf("invalid PERSIST_MODE=%q, using %s", mode, PersistSnapshot)
+		mode = PersistSnapshot
+	}
+	if mode == PersistEvents {
+		if path == "" {
+			log.Fatalf("PERSIST_MODE=%s needs PERSIST_FILE for the event log", PersistEvents)
+		}
+		return &persister{path: path, mode: mode}
+	}
+	if backend == "" {
+		backend = "file"
+	}
+	newStore, ok := storeBackends[backend]
+	if !ok {
+		log.Fatalf("unknown PERSIST_STORE=%q", backend)
+	}
+	store, err := newStore()
+	if err != nil {
+		log.Fatalf("PERSIST_STORE=%s: %v", backend, err)
+	}
+	return &persister{path: path, mode: mode, store: store}
+}
+
+// restore loads the persisted match into gs, then opens the event log for
+// appending. It runs once at startup, before any client connects.
+func (p *persister) restore(gs *GameState) error {
+	if p == nil {
+		return nil
+	}
+	var err error
+	if p.mode == PersistEvents {
+		err = p.replay(gs)
+	} else {
+		err = p.loadSnapshot(gs)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if p.mode == PersistEvents {
+		p.log, err = os.OpenFile(p.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		return err
+	}
+	return nil
+}
+
+// replay rebuilds gs, and its event history, by applying every logged
+// event in order.
+func (p *persister) replay(gs *GameState) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	events := 0
+	for line := 1; scanner.Scan(); line++ {
+		var ev persistedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return fmt.Errorf("%s:%d: %w", p.path, line, err)
+		}
+		msg := ev.Message
+		msg.Actor = ev.Actor
+		msg.At = ev.At
+		// Safeguards such as reset arming were checked when the action
+		// was first applied.
+		msg.Trusted = true
+		msg.Replayed = true
+		wasFinished := gs.Finished
+		if err := applyAction(gs, msg); err != nil {
+			return fmt.Errorf("%s:%d: replaying %q: %w", p.path, line, msg.Action, err)
+		}
+		gs.logEvent(msg, wasFinished)
+		events++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("Replayed %d events from %s (version %d)", events, p.path, gs.Version)
+	return nil
+}
+
+// loadSnapshot restores gs from the store.
+func (p *persister) loadSnapshot(gs *GameState) error {
+	saved, err := p.store.Load(defaultMatchID)
+	if err != nil {
+		return err
+	}
+	gs.RestoreSaved(&saved.GameState)
+	log.Printf("Restored snapshot of match %s (version %d)", defaultMatchID, gs.Version)
+	return nil
+}
+
+// record persists msg, just applied to gs. In snapshot mode it saves gs to
+// the store. The caller must hold gs.mu. Safe on a nil
+// persister.
+func (p *persister) record(gs *GameState, msg Message) {
+	if p == nil {
+		return
+	}
+	if p.mode == PersistEvents {
+		line, _ := json.Marshal(persistedEvent{At: msg.Time(), Actor: msg.Actor, Message: msg})
+		if _, err := p.log.Write(append(line, '\n')); err != nil {
+			log.Printf("persist: appending event: %v", err)
+		}
+		return
+	}
+	if err := p.store.Save(defaultMatchID, gs); err != nil {
+		log.Printf("persist: saving snapshot: %v", err)
+	}
+}
+
+// loadRoom restores the room gs from its last snapshot, if it has one. Only
+// snapshot mode keeps rooms; the event log is the default match's. Safe on
+// a nil persister.
+func (p *persister) loadRoom(gs *GameState) {
+	if p == nil || p.mode != PersistSnapshot {
+		return
+	}
+	saved, err := p.store.Load(gs.id)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("persist: loading match %s: %v", gs.id, err)
+		}
+		return
+	}
+	gs.RestoreSaved(&saved.GameState)
+	log.Printf("Restored snapshot of match %s (version %d)", gs.id, gs.Version)
+}
+
+// saveRoom saves the room gs after a change, in snapshot mode. The caller
+// must hold gs.mu. Safe on a nil persister.
+func (p *persister) saveRoom(gs *GameState) {
+	if p == nil || p.mode != PersistSnapshot {
+		return
+	}
+	if err := p.store.Save(gs.id, gs); err != nil {
+		log.Printf("persist: saving match %s: %v", gs.id, err)
+	}
+}
+
+// rewrite atomically replaces the event log with data.
+func (p *persister) rewrite(data []byte) error {
+	tmp := filepath.Join(filepath.Dir(p.path), "."+filepath.Base(p.path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// checkReplace reports whether events can stand in for the persisted
+// history: the event log must be replayable from an empty match, so it
+// needs every event from version 1. Safe on a nil persister.
+func (p *persister) checkReplace(events []archivedEvent) error {
+	if p == nil || p.mode != PersistEvents {
+		return nil
+	}
+	if len(events) == 0 || events[0].Version != 1 {
+		return errors.New("the event log persistence mode needs the full history from version 1")
+	}
+	return nil
+}
+
+// replace swaps the persisted match for gs, whose history is events, after
+// an import. Check it first with checkReplace. The caller must hold gs.mu.
+// Safe on a nil persister.
+func (p *persister) replace(gs *GameState, events []archivedEvent) error {
+	if p == nil {
+		return nil
+	}
+	if p.mode != PersistEvents {
+		return p.store.Save(defaultMatchID, gs)
+	}
+	var data []byte
+	for _, ev := range events {
+		line, _ := json.Marshal(persistedEvent{At: ev.At, Actor: ev.Actor, Message: ev.Message})
+		data = append(append(data, line...), '\n')
+	}
+	if err := p.rewrite(data); err != nil {
+		return err
+	}
+	// The old handle points at the replaced file.
+	f, err := os.OpenFile(p.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	p.log.Close()
+	p.log = f
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"livescore/engine"
+)
+
+func TestEventLogRestoresStateAndHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	setVar(t, &persist, &persister{path: path, mode: PersistEvents})
+	srv := newTestServer(t)
+	if err := persist.restore(&gameState); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { persist.log.Close() })
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	actions := []Message{
+		{Action: "increment", Team: "A", Value: 2},
+		{Action: "set", Team: "B", Value: 5},
+		{Action: "rename", Team: "A", Name: "Lions"},
+		{Action: "foul_increment", Team: "B"},
+		{Action: "increment", Team: "Lions"},
+		{Action: "period_next"},
+		{Action: "clock_start"},
+		{Action: "clock_adjust", DeltaMs: 1500},
+		{Action: "clock_stop"},
+	}
+	for _, msg := range actions {
+		send(t, conn, msg)
+		if msg.Action == "clock_start" {
+			// Some running time for the replay to rebuild.
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == uint64(len(actions)) })
+
+	// Restart: a fresh match rebuilt from the log alone.
+	restarted := GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 1, Options: baseOptions}}
+	restarted.ResetTimeouts()
+	p := &persister{path: path, mode: PersistEvents}
+	if err := p.restore(&restarted); err != nil {
+		t.Fatal(err)
+	}
+	defer p.log.Close()
+
+	gameState.mu.Lock()
+	defer gameState.mu.Unlock()
+	now := time.Now()
+	for _, gs := range []*GameState{&gameState, &restarted} {
+		gs.CreatedAt = now
+	}
+	want, _ := json.Marshal(&gameState)
+	got, _ := json.Marshal(&restarted)
+	if !bytes.Equal(got, want) {
+		t.Errorf("restored state differs:\n got %s\nwant %s", got, want)
+	}
+	wantEvents, _ := json.Marshal(gameState.events)
+	gotEvents, _ := json.Marshal(restarted.events)
+	if !bytes.Equal(gotEvents, wantEvents) {
+		t.Errorf("restored history differs:\n got %s\nwant %s", gotEvents, wantEvents)
+	}
+	// The clock was rebuilt from the logged action times, not the time of
+	// the replay.
+	if live, got := gameState.Clock, restarted.Clock; got.Elapsed != live.Elapsed || got.Running() {
+		t.Errorf("restored clock %+v, want the live %+v", got, live)
+	}
+	if gameState.Clock.Elapsed < 1520*time.Millisecond {
+		t.Errorf("live clock at %s, want the run and the adjustment", gameState.Clock.Elapsed)
+	}
+	// The undo history came back too.
+	for _, gs := range []*GameState{&gameState, &restarted} {
+		if err := applyAction(gs, Message{Action: "undo", Trusted: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, b := gameState.Team("Lions").Score, restarted.Team("Lions").Score; a != b || a != 2 {
+		t.Errorf("after undo: Lions = %v restored, %v live, want 2", b, a)
+	}
+}
+
+func TestRestartAfterEndsAtFinalizesAtOnce(t *testing.T) {
+	store := newMemoryStore()
+	saved := &GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 2, Version: 4, EndsAt: time.Now().Add(-time.Minute)}}
+	saved.Team("A").Score = 5
+	saved.Team("B").Score = 2
+	if err := store.Save(defaultMatchID, saved); err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &persist, &persister{mode: PersistSnapshot, store: store})
+	srv := newTestServer(t)
+	logs := captureLog(t)
+
+	// As main does on boot: restore, then arm the scheduled end, which
+	// has already passed.
+	if err := persist.restore(&gameState); err != nil {
+		t.Fatal(err)
+	}
+	scheduleEnd()
+	waitFor(t, "the overdue match to finish", func() bool {
+		return strings.Contains(logs(), "Match finished at its scheduled end")
+	})
+
+	s := readState(t, dial(t, srv, "/ws"))
+	if !s.Finished || s.Winner != "A" || score(t, s, "A") != 5 {
+		t.Errorf("after restarting past EndsAt: finished %v, winner %q, A=%v, want A to win on 5", s.Finished, s.Winner, score(t, s, "A"))
+	}
+	if s.Version != 5 {
+		t.Errorf("finished at version %d, want 5, right after the restored version 4", s.Version)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// broadcastCap limits how often a match's state is broadcast, from
+// BROADCAST_RATE per second; 0, the default, leaves broadcasts unlimited.
+// Every action is still applied at once. Changes arriving faster than the
+// cap are coalesced: the state goes out once the interval has passed,
+// carrying everything applied meanwhile. This keeps a high-frequency feed
+// from driving viewers at its own rate. New connections are still sent
+// the live state when they join.
+var broadcastCap = newRateCap(envInt("BROADCAST_RATE", 0))
+
+// rateCap is a leaky bucket of one broadcast per interval.
+type rateCap struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time // when the last broadcast went out
+	pending  bool      // a coalesced broadcast is scheduled
+}
+
+func newRateCap(perSecond int) *rateCap {
+	c := &rateCap{}
+	if perSecond > 0 {
+		c.interval = time.Second / time.Duration(perSecond)
+	}
+	return c
+}
+
+// admit reports whether a broadcast may go out now. If not, one is
+// scheduled for when the interval has passed, unless one already is.
+func (c *rateCap) admit(now time.Time) bool {
+	if c.interval == 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending {
+		return false
+	}
+	if wait := c.last.Add(c.interval).Sub(now); wait > 0 {
+		c.pending = true
+		time.AfterFunc(wait, c.flush)
+		return false
+	}
+	c.last = now
+	return true
+}
+
+// flush broadcasts the latest state on behalf of the actions coalesced
+// since the last broadcast.
+func (c *rateCap) flush() {
+	c.mu.Lock()
+	c.pending = false
+	c.last = time.Now()
+	c.mu.Unlock()
+
+	gameState.mu.Lock()
+	broadcastLocked(&gameState, nil, nil)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reactionEmoji is the set of reactions viewers may send, from the
+// comma-separated REACTION_EMOJI.
+var reactionEmoji = parseReactionEmoji(envString("REACTION_EMOJI", "🔥,👏,😮,😂,❤️,👍"))
+
+// reactionWindow is how long reactions are tallied before their counts are
+// broadcast.
+var reactionWindow = envDuration("REACTION_WINDOW", 2*time.Second)
+
+// Each viewer may react REACTION_RATE times a second, in bursts of up to
+// REACTION_BURST. Reactions over the limit are dropped; a rate of 0 lifts
+// the limit.
+var (
+	reactionRate  = float64(envInt("REACTION_RATE", 2))
+	reactionBurst = float64(envInt("REACTION_BURST", 5))
+)
+
+// ErrUnknownReaction is returned for an emoji outside reactionEmoji.
+var ErrUnknownReaction = errors.New("reaction is not one of the allowed emoji")
+
+func parseReactionEmoji(list string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			allowed[e] = true
+		}
+	}
+	return allowed
+}
+
+// reactionTally aggregates viewer reactions, so a burst of them reaches
+// clients as one frame of counts per window, e.g.
+// {"type":"reactions","🔥":42,"👏":7}, instead of a message per reaction.
+// Like countdowns, reactions aren't part of the match state.
+type reactionTally struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	pending bool // a flush is scheduled
+}
+
+var reactions = &reactionTally{counts: make(map[string]int)}
+
+// add counts one reaction, scheduling a flush when the window opens.
+func (t *reactionTally) add(emoji string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[emoji]++
+	if !t.pending {
+		t.pending = true
+		time.AfterFunc(reactionWindow, t.flush)
+	}
+}
+
+// flush broadcasts the window's counts and starts a new window.
+func (t *reactionTally) flush() {
+	t.mu.Lock()
+	counts := t.counts
+	t.counts = make(map[string]int)
+	t.pending = false
+	t.mu.Unlock()
+
+	frame := make(map[string]any, len(counts)+1)
+	for emoji, n := range counts {
+		frame[emoji] = n
+	}
+	frame["type"] = "reactions"
+	payload, _ := json.Marshal(frame)
+	hub.broadcast(payload, nil, time.Time{})
+}
+
+// react records a viewer's reaction, subject to the client's rate limit.
+func (c *Client) react(emoji string) error {
+	if !reactionEmoji[emoji] {
+		return ErrUnknownReaction
+	}
+	if c.reactions == nil {
+		c.reactions = newTokenBucket(reactionRate, reactionBurst)
+	}
+	if !c.reactions.allow() {
+		debugf("reaction from client %d dropped: rate limited", c.id)
+		return nil
+	}
+	reactions.add(emoji)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Reconnect hints spread clients out after an overload or restart instead of
+// letting them all reconnect at once. A hint is reconnectBase plus a random
+// share of reconnectJitter.
+var (
+	reconnectBase   = envDuration("RECONNECT_BASE", time.Second)
+	reconnectJitter = envDuration("RECONNECT_JITTER", 5*time.Second)
+)
+
+// reconnectMessage is sent just before the server closes a connection it
+// wants retried, e.g. {"type":"reconnect","retryAfterMs":3200,"reason":
+// "server shutting down"}. Clients should wait retryAfterMs before
+// reconnecting. The same value is repeated in the close frame's reason as
+// "retryAfterMs=3200", and HTTP refusals carry it as Retry-After (seconds).
+type reconnectMessage struct {
+	Type         string `json:"type"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+	Reason       string `json:"reason"`
+}
+
+// retryHint picks a jittered reconnect delay.
+func retryHint() time.Duration {
+	d := reconnectBase
+	if reconnectJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(reconnectJitter)))
+	}
+	return d
+}
+
+// reconnectPayloads returns the pre-close message and close frame reason for
+// a connection asked to come back after hint.
+func reconnectPayloads(hint time.Duration, reason string) (message []byte, closeReason string) {
+	message, _ = json.Marshal(reconnectMessage{Type: "reconnect", RetryAfterMs: hint.Milliseconds(), Reason: reason})
+	return message, "retryAfterMs=" + strconv.FormatInt(hint.Milliseconds(), 10)
+}
+
+// retryAfterSeconds formats hint for a Retry-After header, rounding up.
+func retryAfterSeconds(hint time.Duration) string {
+	return strconv.FormatInt(int64((hint+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"livescore/engine"
+)
+
+// maxReplayGap bounds the wait between two replayed events, so a long
+// break in the match doesn't stall a recap.
+const maxReplayGap = 10 * time.Second
+
+// replayControl is a control message from a replay client:
+//
+//	{"action":"pause"}
+//	{"action":"resume"}
+//	{"action":"speed","value":4}
+//	{"action":"seek","version":120}
+//
+// Seeking shows the state as of the given version and carries on from
+// there, paused or not as before.
+type replayControl struct {
+	Action  string  `json:"action"`
+	Value   float64 `json:"value"`
+	Version uint64  `json:"version"`
+}
+
+// replayEndMessage is sent once the last event has been replayed. The
+// connection stays open for seeks.
+type replayEndMessage struct {
+	Type    string `json:"type"`
+	Version uint64 `json:"version"`
+}
+
+// replay plays an event log back onto a fresh board.
+type replay struct {
+	conn   *websocket.Conn
+	events []archivedEvent
+	base   GameState // the board before the first event
+	speed  float64   // 1 is real time; 0 replays instantly
+
+	state  *GameState
+	next   int // index of the next event to apply
+	paused bool
+}
+
+// serveReplay streams a match's event log over a WebSocket at
+// ?speed= times real time (default 1, 0 for instant), as the same config
+// and state messages live clients receive, so existing rendering code can
+// play a recap. ?archive= replays an archived match (see serveArchive);
+// otherwise the live match's retained log is replayed. The board starts
+// from the default teams under the server's current options, and actions
+// in the log such as "configure" or "rename" shape it from there. A room
+// starts from the teams it opened with.
+func serveReplay(w http.ResponseWriter, r *http.Request) {
+	id := matchID(r)
+	gs, ok := lookupMatch(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match "+strconv.Quote(id))
+		return
+	}
+	speed := 1.0
+	if v := r.URL.Query().Get("speed"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s < 0 {
+			writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "speed must be a non-negative number")
+			return
+		}
+		speed = s
+	}
+
+	gs.mu.Lock()
+	options := gs.Options
+	teams := engine.DefaultTeams()
+	if gs.setup.teams != nil {
+		teams = engine.TeamsNamed(gs.setup.teams)
+	}
+	events := append([]archivedEvent(nil), gs.events...)
+	gs.mu.Unlock()
+	if id := r.URL.Query().Get("archive"); id != "" {
+		var err error
+		if events, err = loadArchivedEvents(id); err != nil {
+			writeJSONError(w, http.StatusNotFound, CodeNotFound, err.Error())
+			return
+		}
+	}
+	if len(events) == 0 {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "no events to replay")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tuneConn(conn)
+	log.Printf("Replaying %d events to %s at speed %g", len(events), clientIP(r), speed)
+
+	rp := &replay{conn: conn, events: events, speed: speed}
+	rp.base = GameState{GameState: engine.GameState{Teams: teams, Period: 1, Options: options, Version: events[0].Version - 1}}
+	rp.base.ResetTimeouts()
+	rp.base.ResetLevels()
+	rp.run()
+}
+
+// loadArchivedEvents reads the event log of an archive, its segments first.
+func loadArchivedEvents(id string) ([]archivedEvent, error) {
+	if archiveDir == "" {
+		return nil, errArchivingDisabled
+	}
+	path, ok := archivePath(id)
+	if !ok {
+		return nil, errUnknownArchive
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a matchArchive
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	var events []archivedEvent
+	for _, name := range a.Segments {
+		data, err := os.ReadFile(filepath.Join(archiveDir, segmentDir, name))
+		if err != nil {
+			return nil, err
+		}
+		var seg eventSegment
+		if err := json.Unmarshal(data, &seg); err != nil {
+			return nil, err
+		}
+		events = append(events, seg.Events...)
+	}
+	return append(events, a.Events...), nil
+}
+
+// run replays until the client disconnects.
+func (rp *replay) run() {
+	controls := make(chan replayControl)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			var ctl replayControl
+			if err := rp.conn.ReadJSON(&ctl); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			select {
+			case controls <- ctl:
+			case <-gone:
+				return
+			}
+		}
+	}()
+
+	rp.seek(0)
+	if !rp.send(true) {
+		return
+	}
+	for {
+		var wait <-chan time.Time
+		if !rp.paused && rp.next < len(rp.events) {
+			wait = time.After(rp.delay())
+		}
+		select {
+		case <-gone:
+			return
+		case ctl := <-controls:
+			if !rp.control(ctl) {
+				return
+			}
+		case <-wait:
+			rp.apply(rp.events[rp.next])
+			rp.next++
+			if !rp.send(false) {
+				return
+			}
+		}
+	}
+}
+
+// delay returns how long to wait before the next event.
+func (rp *replay) delay() time.Duration {
+	if rp.speed == 0 || rp.next == 0 {
+		return 0
+	}
+	gap := rp.events[rp.next].At.Sub(rp.events[rp.next-1].At)
+	return min(max(time.Duration(float64(gap)/rp.speed), 0), maxReplayGap)
+}
+
+// control handles a control message, reporting false if the client is gone.
+func (rp *replay) control(ctl replayControl) bool {
+	switch ctl.Action {
+	case "pause":
+		rp.paused = true
+	case "resume":
+		rp.paused = false
+	case "speed":
+		if ctl.Value >= 0 {
+			rp.speed = ctl.Value
+		}
+	case "seek":
+		rp.seek(ctl.Version)
+		return rp.send(true)
+	}
+	return true
+}
+
+// seek rebuilds the board up to and including the event at version.
+func (rp *replay) seek(version uint64) {
+	rp.state = rp.base.clone()
+	rp.next = 0
+	for rp.next < len(rp.events) && rp.events[rp.next].Version <= version {
+		rp.apply(rp.events[rp.next])
+		rp.next++
+	}
+}
+
+// apply replays one event. Events that fail to apply, which may happen on
+// a board that doesn't match the original, still advance the version.
+func (rp *replay) apply(ev archivedEvent) {
+	msg := ev.Message
+	msg.At = ev.At
+	msg.Trusted = true
+	if err := applyAction(rp.state, msg); err != nil {
+		debugf("replay of version %d: %v", ev.Version, err)
+	}
+	rp.state.Version = ev.Version
+}
+
+// send writes the replayed state, preceded by the config when it changed
+// or when full is set, and the end marker after the last event. It
+// reports false if the write failed.
+func (rp *replay) send(full bool) bool {
+	rp.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	config := rp.state.configChange()
+	if full {
+		config = rp.state.configPayload()
+	}
+	if config != nil {
+		if err := rp.conn.WriteMessage(websocket.TextMessage, config); err != nil {
+			return false
+		}
+	}
+	if payload := rp.state.broadcastPayload(); payload != nil {
+		if err := rp.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			return false
+		}
+	}
+	if rp.next == len(rp.events) {
+		end, _ := json.Marshal(replayEndMessage{Type: "replay_end", Version: rp.state.Version})
+		if err := rp.conn.WriteMessage(websocket.TextMessage, end); err != nil {
+			return false
+		}
+	}
+	return true
+}
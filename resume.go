@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// resumeWindow is how long a disconnected client's session can be resumed.
+var resumeWindow = envDuration("RESUME_WINDOW", 30*time.Second)
+
+// maxResumeTokens bounds the number of sessions held for resumption; the
+// oldest are dropped first.
+var maxResumeTokens = envInt("RESUME_MAX_TOKENS", 10000)
+
+// sessionMessage hands a client the token it can present to resume.
+type sessionMessage struct {
+	Type        string `json:"type"`
+	ResumeToken string `json:"resumeToken"`
+	ExpiresInMs int64  `json:"expiresInMs"`
+}
+
+// session is the per-client state restored on resume.
+type session struct {
+	filter  map[string]bool
+	name    string
+	locale  string
+	role    string
+	tenant  string
+	expires time.Time
+}
+
+// resumeStore holds sessions of recently disconnected clients by token.
+type resumeStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+	order    []string // tokens in insertion order, for eviction
+}
+
+var resumeTokens = &resumeStore{sessions: make(map[string]session)}
+
+// newResumeToken returns a random, unguessable token.
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// save keeps a session resumable for resumeWindow.
+func (s *resumeStore) save(token string, sess session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	sess.expires = now.Add(resumeWindow)
+	s.sessions[token] = sess
+	s.order = append(s.order, token)
+
+	// Drop resumed or expired tokens from the front, then enforce the size
+	// bound oldest-first.
+	for len(s.order) > 0 {
+		t := s.order[0]
+		old, ok := s.sessions[t]
+		if ok && now.Before(old.expires) && len(s.sessions) <= maxResumeTokens {
+			break
+		}
+		delete(s.sessions, t)
+		s.order = s.order[1:]
+	}
+}
+
+// take returns and removes the session for token if it hasn't expired.
+func (s *resumeStore) take(token string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return session{}, false
+	}
+	delete(s.sessions, token)
+	if time.Now().After(sess.expires) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// resumeSession claims the session named by the request's ?resume= token.
+func resumeSession(r *http.Request) (session, bool) {
+	token := r.URL.Query().Get("resume")
+	if token == "" {
+		return session{}, false
+	}
+	return resumeTokens.take(token)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Connection roles. Viewers watch; controllers change the score.
+// Scorekeepers are controllers limited to the actions in roleActions.
+const (
+	RoleViewer      = "viewer"
+	RoleController  = "controller"
+	RoleScorekeeper = "scorekeeper"
+)
+
+// controllerToken must be presented to open a /control connection.
+var controllerToken = envString("CONTROLLER_TOKEN", "")
+
+// controlOpen, from CONTROL_OPEN, opens /control to anyone as a controller,
+// which suits local demos only. Without it a missing token is refused, even
+// when CONTROLLER_TOKEN is unset.
+var controlOpen = envBool("CONTROL_OPEN", false)
+
+// scorekeeperToken opens a /control connection with the scorekeeper role.
+var scorekeeperToken = envString("SCOREKEEPER_TOKEN", "")
+
+// roleActions limits what each role may send, from ROLE_ACTIONS, e.g.
+// "scorekeeper=increment,decrement,set;controller=*". Roles not listed, or
+// listed with "*", may send any action their connection accepts.
+var roleActions = parseRoleActions(envString("ROLE_ACTIONS",
+	"scorekeeper=increment,decrement,set,undo,level_increment,foul_increment,foul_reset,clock_start,clock_stop,period_next"))
+
+// parseRoleActions parses a ROLE_ACTIONS whitelist.
+func parseRoleActions(spec string) map[string]map[string]bool {
+	whitelist := make(map[string]map[string]bool)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		role, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			if entry != "" {
+				log.Printf("invalid entry %q in ROLE_ACTIONS", entry)
+			}
+			continue
+		}
+		role = strings.TrimSpace(role)
+		if strings.TrimSpace(list) == "*" {
+			delete(whitelist, role)
+			continue
+		}
+		allowed := make(map[string]bool)
+		for _, action := range strings.Split(list, ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				allowed[action] = true
+			}
+		}
+		whitelist[role] = allowed
+	}
+	return whitelist
+}
+
+// roleAllows reports whether role may send action.
+func roleAllows(role, action string) bool {
+	allowed, limited := roleActions[role]
+	return !limited || allowed[action]
+}
+
+// controllerNets lists networks (CONTROLLER_CIDRS, comma-separated) whose
+// clients may open /control without a token, e.g. a trusted office subnet.
+var controllerNets = parseCIDRs(envString("CONTROLLER_CIDRS", ""))
+
+// trustProxy makes clientIP honour X-Forwarded-For. Enable it only behind a
+// proxy that sets the header, or clients can spoof their address.
+var trustProxy = envBool("TRUST_PROXY", false)
+
+// parseCIDRs parses a comma-separated list of networks, skipping bad entries.
+func parseCIDRs(list string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("invalid CIDR %q in CONTROLLER_CIDRS: %v", entry, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// clientIP returns the remote address of a request. Behind a trusted proxy it
+// uses the left-most X-Forwarded-For entry, the original client.
+func clientIP(r *http.Request) net.IP {
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// trustedNetwork reports whether ip falls inside controllerNets.
+func trustedNetwork(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range controllerNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// viewerActions only affect what the sending connection receives, or, for
+// reactions, the crowd feed; every other action mutates the game.
+var viewerActions = map[string]bool{
+	"filter":    true,
+	"setname":   true,
+	"setlocale": true,
+	"react":     true,
+}
+
+// isMutation reports whether an action changes the game state.
+func isMutation(action string) bool {
+	return !viewerActions[action]
+}
+
+// requestToken returns the credential sent as a bearer Authorization header
+// or a ?token= query parameter.
+func requestToken(r *http.Request) string {
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// tokenMatches compares a presented token with the expected one in constant
+// time.
+func tokenMatches(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// RoleResolver maps the token a connection presents, as read by
+// requestToken, to its role. A missing or unrecognised token must map to
+// RoleViewer, which leaves the connection read-only.
+//
+// To back roles with an identity provider instead of the shared tokens,
+// assign a resolver from an init function in another file of this package:
+//
+//	func init() {
+//		roleForToken = func(r *http.Request, token string) string {
+//			if claims, err := verifyJWT(token); err == nil && claims.Scorer {
+//				return RoleScorekeeper
+//			}
+//			return RoleViewer
+//		}
+//	}
+type RoleResolver func(r *http.Request, token string) string
+
+// roleForToken is the resolver consulted by /ws and /control.
+var roleForToken RoleResolver = tokenRole
+
+// tokenRole is the default resolver: the scorekeeper and controller tokens
+// grant their roles, anything else is a viewer. Under a tenant only the
+// tenant's token counts, granting the controller role.
+func tokenRole(r *http.Request, token string) string {
+	if tenant := requestTenant(r); tenant != "" {
+		if want := tenantTokens[tenant]; token != "" && want != "" && tokenMatches(token, want) {
+			return RoleController
+		}
+		return RoleViewer
+	}
+	switch {
+	case token == "":
+		return RoleViewer
+	case scorekeeperToken != "" && tokenMatches(token, scorekeeperToken):
+		return RoleScorekeeper
+	case controllerToken != "" && tokenMatches(token, controllerToken):
+		return RoleController
+	}
+	return RoleViewer
+}
+
+// serveWs upgrades a viewer connection. Viewers receive every broadcast but
+// can't change the score. A token that resolves to a scoring role opens the
+// connection as a controller of that role instead, as on /control.
+func serveWs(w http.ResponseWriter, r *http.Request) {
+	if !knownTenant(w, r) {
+		return
+	}
+	sess, resumed := resumeSession(r)
+	role := roleForToken(r, requestToken(r))
+	if role != RoleViewer {
+		log.Printf("%s role granted to %s by token", role, clientIP(r))
+	}
+	serveClient(w, r, role, sess, resumed)
+}
+
+// serveControl upgrades a controller connection. It requires a token that
+// roleForToken resolves to a scoring role, unless open mode is on, the
+// client is on a trusted network or it resumes a controller session within
+// the resume window. The granting mechanism is logged for auditing.
+func serveControl(w http.ResponseWriter, r *http.Request) {
+	if !knownTenant(w, r) {
+		return
+	}
+	sess, resumed := resumeSession(r)
+	role := roleForToken(r, requestToken(r))
+	var grant string
+	switch {
+	case role != RoleViewer:
+		grant = "token"
+	case controlOpen:
+		role, grant = RoleController, "open mode (CONTROL_OPEN)"
+	case trustedNetwork(clientIP(r)):
+		role, grant = RoleController, "trusted network"
+	case resumed && sess.tenant == requestTenant(r) && (sess.role == RoleController || sess.role == RoleScorekeeper):
+		role, grant = sess.role, "resumed session"
+	default:
+		writeJSONError(w, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
+		return
+	}
+	log.Printf("%s role granted to %s by %s", role, clientIP(r), grant)
+	serveClient(w, r, role, sess, resumed)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestViewerAndControlPaths(t *testing.T) {
+	srv := newTestServer(t)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+	send(t, viewer, Message{Action: "increment", Team: "A"})
+	if text := readError(t, viewer); !strings.Contains(text, "viewers cannot change the score") {
+		t.Errorf("viewer increment: %q", text)
+	}
+	send(t, viewer, Message{Action: "filter", Teams: []string{"A"}})
+	if s := readState(t, viewer); len(s.Teams) != 1 || s.Teams[0].Name != "A" {
+		t.Errorf("viewer filter: got teams %+v, want only A", s.Teams)
+	}
+
+	for path, want := range map[string]int{
+		"/control":             http.StatusUnauthorized,
+		"/control?token=wrong": http.StatusUnauthorized,
+		"/control?token=":      http.StatusUnauthorized,
+	} {
+		if got := dialStatus(t, srv, path); got != want {
+			t.Errorf("dialing %s: status %d, want %d", path, got, want)
+		}
+	}
+
+	controller := dialControl(t, srv, "")
+	readState(t, controller)
+	send(t, controller, Message{Action: "filter", Teams: []string{"A"}})
+	if text := readError(t, controller); !strings.Contains(text, "only accept scoring actions") {
+		t.Errorf("controller filter: %q", text)
+	}
+	send(t, controller, Message{Action: "increment", Team: "A"})
+	readStateWith(t, controller, func(s StateJSON) bool { return s.Version == 1 })
+	// Both paths share the match.
+	if s := readState(t, viewer); s.Version != 1 || score(t, s, "A") != 1 {
+		t.Errorf("viewer got version %d with A=%v, want the controller's increment", s.Version, score(t, s, "A"))
+	}
+}
+
+func TestControllerNoticesSkipViewers(t *testing.T) {
+	srv := newTestServer(t)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+	controller := dialControl(t, srv, "")
+	readState(t, controller)
+	other := dialControl(t, srv, "")
+	readState(t, other)
+
+	send(t, controller, Message{Action: "reset_arm"})
+	for _, conn := range []*websocket.Conn{controller, other} {
+		f := readFrame(t, conn, func(f frame) bool { return f["type"] == "notice" })
+		if text, _ := f["message"].(string); !strings.Contains(text, "reset armed") {
+			t.Errorf("controller notice = %q", text)
+		}
+	}
+	// The viewer's next frames up to the following state carry no notice.
+	send(t, controller, Message{Action: "increment", Team: "A"})
+	readFrame(t, viewer, func(f frame) bool {
+		if f["type"] == "notice" {
+			t.Errorf("viewer received a controller notice: %v", f)
+		}
+		return f.isState() && f["version"].(float64) >= 2
+	})
+}
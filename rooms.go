@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"livescore/engine"
+)
+
+// maxRooms bounds the rooms open at once, from MAX_ROOMS; 0 disables them.
+var maxRooms = envInt("MAX_ROOMS", 100)
+
+// refuseDuplicateCreate, from REFUSE_DUPLICATE_CREATE, refuses a connection
+// asking with ?create=true to open a room that is already in use. By
+// default it joins the room as it is, so a retried create doesn't fail.
+var refuseDuplicateCreate = envBool("REFUSE_DUPLICATE_CREATE", false)
+
+// Errors for connections to a room that can't be joined.
+var (
+	ErrInvalidMatchID = errors.New("match IDs are 1 to 64 letters, digits, '-' or '_'")
+	ErrTooManyRooms   = errors.New("too many matches in progress")
+	ErrSetupForbidden = errors.New("only controllers can set up a match")
+	ErrSetupConflict  = errors.New("match is already open with a different setup")
+	ErrNoWinningScore = errors.New(`onMax "win" needs a scoreMax`)
+	ErrMatchExists    = errors.New("match is already open")
+)
+
+// roomSetup configures a room as it opens, from the query of the
+// connection that opens it, e.g.
+//
+//	/control?match=final&teams=Lakers,Celtics&scoreMax=21&onMax=win
+//
+// teams lists the team names, and scoreMax and onMax set the score ceiling
+// as SCORE_MAX and ON_MAX do, scoreMax=0 lifting it; whatever is left out
+// follows the default match. create=true marks a client that means to open
+// the room rather than join one in use (see refuseDuplicateCreate). Only
+// controllers may send a setup. Joining a room already open with a setup it
+// doesn't match is refused, so a client never takes another's setup for its
+// own. A room restored from a snapshot keeps its saved teams.
+type roomSetup struct {
+	teams    []string // nil when not given
+	scoreMax *int     // nil when not given
+	onMax    string   // "" when not given
+	create   bool
+}
+
+// given reports whether the setup sets anything.
+func (s roomSetup) given() bool {
+	return s.teams != nil || s.scoreMax != nil || s.onMax != ""
+}
+
+// matches reports whether every field given in want agrees with s, the
+// resolved setup of an open room.
+func (s roomSetup) matches(want roomSetup) bool {
+	return (want.teams == nil || slices.Equal(want.teams, s.teams)) &&
+		(want.scoreMax == nil || *want.scoreMax == *s.scoreMax) &&
+		(want.onMax == "" || want.onMax == s.onMax)
+}
+
+// parseRoomSetup reads the setup in r's query for a client with the given
+// role joining the match id. The default match is set up by the server.
+func parseRoomSetup(r *http.Request, id, role string) (roomSetup, error) {
+	var setup roomSetup
+	q := r.URL.Query()
+	if !q.Has("teams") && !q.Has("scoreMax") && !q.Has("onMax") && !q.Has("create") {
+		return setup, nil
+	}
+	if id == defaultMatchID {
+		return setup, fmt.Errorf("the %s match is set up by the server", defaultMatchID)
+	}
+	if role == RoleViewer {
+		return setup, ErrSetupForbidden
+	}
+	if q.Has("create") {
+		create, err := strconv.ParseBool(q.Get("create"))
+		if err != nil {
+			return setup, fmt.Errorf("create must be a boolean, got %q", q.Get("create"))
+		}
+		setup.create = create
+	}
+	if q.Has("teams") {
+		names, err := engine.ParseTeamNames(q.Get("teams"))
+		if err != nil {
+			return setup, fmt.Errorf("teams: %w", err)
+		}
+		setup.teams = names
+	}
+	if q.Has("scoreMax") {
+		n, err := strconv.Atoi(q.Get("scoreMax"))
+		if err != nil || n < 0 {
+			return setup, fmt.Errorf("scoreMax must be a non-negative integer, got %q", q.Get("scoreMax"))
+		}
+		setup.scoreMax = &n
+	}
+	switch onMax := q.Get("onMax"); onMax {
+	case "", engine.OnMaxCap, engine.OnMaxWrap, engine.OnMaxReject, engine.OnMaxWin:
+		setup.onMax = onMax
+	default:
+		return setup, fmt.Errorf("onMax must be %q, %q, %q or %q, got %q", engine.OnMaxCap, engine.OnMaxWrap, engine.OnMaxReject, engine.OnMaxWin, onMax)
+	}
+	return setup, nil
+}
+
+// room is a hosted match besides the default one, so several games can run
+// at once. A connection to /ws or /control with ?match=id joins the board
+// with that ID, made fresh under the server's match options, as changed by
+// a roomSetup, on first use and dropped when its last client leaves. In the
+// snapshot persistence mode a room's state is saved after every change and
+// restored when it opens again, across restarts too; otherwise it is lost
+// with the room. A room is scored and broadcast like the default match, to
+// its own clients only, and the HTTP match endpoints serve it while it is
+// in use. The features the server runs for a single board (the event log,
+// feeds, the simulator, schedules, webhooks, MQTT, the rate cap, broadcast
+// views and delay, reactions, countdowns and imports) stay with the default
+// match; see warnRoomLimits. Rooms have no waiting queue: a viewer finding
+// the server full is turned away.
+type room struct {
+	state *GameState
+	// clients counts the connections that joined, including those still
+	// upgrading.
+	clients int
+}
+
+// roomRegistry holds the rooms in use by match ID.
+type roomRegistry struct {
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+var rooms = &roomRegistry{rooms: make(map[string]*room)}
+
+// validMatchID reports whether id may name a room. IDs end up in archive
+// file names, so they are kept to a safe alphabet.
+func validMatchID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// warnRoomLimits logs the settings in force that only reach the default
+// match, so rooms running without them come as no surprise.
+func warnRoomLimits() {
+	if maxRooms <= 0 {
+		return
+	}
+	var only []string
+	if persist != nil && persist.mode == PersistEvents {
+		only = append(only, "the event log (PERSIST_MODE=events)")
+	}
+	if mqtt != nil {
+		only = append(only, "MQTT (MQTT_BROKER)")
+	}
+	if broadcastCap.interval > 0 {
+		only = append(only, "the rate cap (BROADCAST_RATE)")
+	}
+	if broadcastDelay > 0 {
+		only = append(only, "the broadcast delay (BROADCAST_DELAY)")
+	}
+	if len(broadcastViews) > 0 {
+		only = append(only, "broadcast views")
+	}
+	if len(only) > 0 {
+		log.Printf("Rooms don't get %s: only the %s match does", strings.Join(only, ", "), defaultMatchID)
+	}
+}
+
+// join counts a connection into the room with the given ID, creating it
+// with setup if it isn't in use, and returns its state. The board is made
+// off r.mu, as that reads the default match and may load a snapshot, and
+// the registry is checked again before it goes in, so connections racing
+// to open the same room all end up in the one inserted first. Every
+// successful join must be paired with a leave.
+func (r *roomRegistry) join(id string, setup roomSetup) (*GameState, error) {
+	if !validRoomID(id) {
+		return nil, ErrInvalidMatchID
+	}
+	r.mu.Lock()
+	if rm, ok := r.rooms[id]; ok {
+		defer r.mu.Unlock()
+		return rm.admit(setup)
+	}
+	full := len(r.rooms) >= maxRooms
+	r.mu.Unlock()
+	if full {
+		return nil, ErrTooManyRooms
+	}
+
+	state, err := newRoomState(id, setup)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm, ok := r.rooms[id]; ok {
+		return rm.admit(setup)
+	}
+	if len(r.rooms) >= maxRooms {
+		return nil, ErrTooManyRooms
+	}
+	r.rooms[id] = &room{state: state, clients: 1}
+	ops.publish(opsEvent{Type: "match_created", Match: id})
+	log.Printf("Match %s opened", id)
+	return state, nil
+}
+
+// admit counts a connection with setup into the room already open,
+// unless the setup conflicts with the room's. The caller must hold
+// rooms.mu.
+func (rm *room) admit(setup roomSetup) (*GameState, error) {
+	if setup.create && refuseDuplicateCreate {
+		return nil, ErrMatchExists
+	}
+	if setup.given() && !rm.state.setup.matches(setup) {
+		return nil, ErrSetupConflict
+	}
+	rm.clients++
+	return rm.state, nil
+}
+
+// leave counts a connection out of the room with state gs, dropping the
+// room once nobody is left.
+func (r *roomRegistry) leave(gs *GameState) {
+	r.mu.Lock()
+	rm, ok := r.rooms[gs.id]
+	if !ok || rm.state != gs {
+		r.mu.Unlock()
+		return
+	}
+	rm.clients--
+	closed := rm.clients == 0
+	if closed {
+		delete(r.rooms, gs.id)
+	}
+	r.mu.Unlock()
+	if closed {
+		hub.forget(gs)
+		scoreIntervals.forget(gs.id)
+		ops.publish(opsEvent{Type: "match_destroyed", Match: gs.id})
+		log.Printf("Match %s closed", gs.id)
+	}
+}
+
+// remove takes the room gs out of use for an admin, whoever is on it: it is
+// reset, and saved so, leaving nothing for a room reopened under its ID to
+// restore, and dropped from the registry. Clients following it from other
+// matches stop; the caller closes those on it. A read-only room refuses.
+func (r *roomRegistry) remove(gs *GameState) error {
+	r.mu.Lock()
+	rm, ok := r.rooms[gs.id]
+	if !ok || rm.state != gs {
+		r.mu.Unlock()
+		return fmt.Errorf("match %s closed", gs.id)
+	}
+	gs.mu.Lock()
+	if gs.ReadOnly {
+		gs.mu.Unlock()
+		r.mu.Unlock()
+		return engine.ErrMatchReadOnly
+	}
+	delete(r.rooms, gs.id)
+	r.mu.Unlock()
+	gs.Reset(engine.ResetAll)
+	persist.saveRoom(gs)
+	gs.mu.Unlock()
+
+	hub.mutex.Lock()
+	for c := range hub.clients {
+		// A fresh slice, as the connection gives back the one it joined.
+		c.subscriptions = slices.DeleteFunc(slices.Clone(c.subscriptions), func(sub subscription) bool { return sub.game == gs })
+	}
+	hub.mutex.Unlock()
+	hub.forget(gs)
+	scoreIntervals.forget(gs.id)
+	ops.publish(opsEvent{Type: "match_destroyed", Match: gs.id})
+	log.Printf("Match %s removed", gs.id)
+	return nil
+}
+
+// lookupMatch returns the match with the given ID: the default match, or a
+// room in use.
+func lookupMatch(id string) (*GameState, bool) {
+	if id == defaultMatchID {
+		return &gameState, true
+	}
+	return rooms.lookup(id)
+}
+
+// lookup returns the state of the room with the given ID, if it is in use.
+func (r *roomRegistry) lookup(id string) (*GameState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[id]
+	if !ok {
+		return nil, false
+	}
+	return rm.state, true
+}
+
+// all returns the state of every room in use, ordered by match ID.
+func (r *roomRegistry) all() []*GameState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := make([]*GameState, 0, len(r.rooms))
+	for _, rm := range r.rooms {
+		states = append(states, rm.state)
+	}
+	slices.SortFunc(states, func(a, b *GameState) int { return strings.Compare(a.id, b.id) })
+	return states
+}
+
+// count returns how many rooms are in use.
+func (r *roomRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.rooms)
+}
+
+// newRoomState returns the board for the room id, under the options the
+// default match has now as changed by setup: as last saved, or fresh.
+func newRoomState(id string, setup roomSetup) (*GameState, error) {
+	gameState.mu.Lock()
+	options := gameState.Options
+	gameState.mu.Unlock()
+	if setup.teams != nil && len(setup.teams) > options.TeamLimit() {
+		return nil, engine.ErrTooManyTeams
+	}
+	if setup.scoreMax != nil {
+		options.ScoreMax = *setup.scoreMax
+	}
+	if setup.onMax != "" {
+		options.OnMax = setup.onMax
+	}
+	if setup.given() && options.OnMax == engine.OnMaxWin && options.ScoreMax == 0 {
+		return nil, ErrNoWinningScore
+	}
+	// Kept resolved, so later joins compare against what the room has.
+	resolved := roomSetup{teams: setup.teams, scoreMax: &options.ScoreMax, onMax: options.OnMax}
+	if resolved.teams == nil {
+		resolved.teams = teamNames
+	}
+	gs := &GameState{id: id, setup: resolved, GameState: engine.GameState{Teams: engine.TeamsNamed(resolved.teams), Period: 1, Options: options, CreatedAt: time.Now()}}
+	gs.scores = &scoreCache{match: gs}
+	gs.ResetTimeouts()
+	gs.ResetLevels()
+	persist.loadRoom(gs)
+	return gs, nil
+}
+
+// matchID returns the ID of the match gs holds.
+func (gs *GameState) matchID() string {
+	if gs.id == "" {
+		return defaultMatchID
+	}
+	return gs.id
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestJoinCreatesRoomOnce(t *testing.T) {
+	resetState()
+	const joins = 50
+	states := make([]*GameState, joins)
+	var wg sync.WaitGroup
+	for i := range states {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gs, err := rooms.join("final", roomSetup{create: true})
+			if err != nil {
+				t.Errorf("join: %v", err)
+			}
+			states[i] = gs
+		}(i)
+	}
+	wg.Wait()
+
+	if n := rooms.count(); n != 1 {
+		t.Fatalf("rooms in use = %d, want 1", n)
+	}
+	for i, gs := range states {
+		if gs != states[0] {
+			t.Fatalf("join %d got a different match than join 0", i)
+		}
+	}
+	if n := rooms.rooms["final"].clients; n != joins {
+		t.Errorf("room clients = %d, want %d", n, joins)
+	}
+	for _, gs := range states {
+		rooms.leave(gs)
+	}
+	if _, ok := rooms.lookup("final"); ok {
+		t.Error("room still open after every client left")
+	}
+}
+
+func TestDuplicateCreateRefused(t *testing.T) {
+	setVar(t, &refuseDuplicateCreate, true)
+	srv := newTestServer(t)
+
+	const dials = 10
+	statuses := make(chan int, dials)
+	var wg sync.WaitGroup
+	for range dials {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL(srv, "/control?token="+testControllerToken+"&match=final&create=true"), nil)
+			if err != nil {
+				if resp == nil {
+					t.Errorf("dial: %v", err)
+					return
+				}
+				statuses <- resp.StatusCode
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusSwitchingProtocols] != 1 || counts[http.StatusConflict] != dials-1 {
+		t.Errorf("statuses = %v, want one 101 and %d 409", counts, dials-1)
+	}
+}
+
+func TestDuplicateCreateJoinsByDefault(t *testing.T) {
+	srv := newTestServer(t)
+
+	first := dialControl(t, srv, "match=final&create=true")
+	readState(t, first)
+	send(t, first, Message{Action: "increment", Team: "A"})
+	readStateWith(t, first, func(s StateJSON) bool { return s.Version > 0 })
+
+	second := dialControl(t, srv, "match=final&create=true")
+	if s := readState(t, second); score(t, s, "A") != 1 {
+		t.Errorf("second creator sees A=%v, want the first's 1", score(t, s, "A"))
+	}
+}
+
+func TestNoMatchParamJoinsDefault(t *testing.T) {
+	srv := newTestServer(t)
+
+	plain := dial(t, srv, "/ws")
+	readState(t, plain)
+	named := dial(t, srv, "/ws?match="+defaultMatchID)
+	readState(t, named)
+	if err := applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{plain, named} {
+		if s := readState(t, conn); score(t, s, "A") != 1 {
+			t.Errorf("A = %v on the default match, want 1", score(t, s, "A"))
+		}
+	}
+	if n := rooms.count(); n != 0 {
+		t.Errorf("%d rooms opened for default-match connections", n)
+	}
+}
+
+func TestRoomLimitsAreLogged(t *testing.T) {
+	logs := captureLog(t)
+	setVar(t, &maxRooms, 10)
+	setVar(t, &broadcastDelay, time.Second)
+	setVar(t, &broadcastCap, newRateCap(5))
+	warnRoomLimits()
+	for _, want := range []string{"the rate cap (BROADCAST_RATE)", "the broadcast delay (BROADCAST_DELAY)"} {
+		if !strings.Contains(logs(), want) {
+			t.Errorf("log doesn't say rooms lack %s: %q", want, logs())
+		}
+	}
+	if strings.Contains(logs(), "MQTT") {
+		t.Errorf("log names MQTT, which isn't configured: %q", logs())
+	}
+
+	// Without rooms there is nothing to warn about.
+	setVar(t, &maxRooms, 0)
+	before := logs()
+	warnRoomLimits()
+	if logs() != before {
+		t.Errorf("warned with rooms disabled: %q", strings.TrimPrefix(logs(), before))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDisplayNameLen caps viewer display names.
+const maxDisplayNameLen = 24
+
+// disconnectGrace keeps a dropped client in the roster this long, so a quick
+// reconnect with its resume token doesn't make it flap. Zero removes clients
+// immediately.
+var disconnectGrace = envDuration("DISCONNECT_GRACE", 0)
+
+// ErrDisplayName is returned for empty, overlong or unprintable names.
+var ErrDisplayName = errors.New("invalid display name")
+
+// ErrUnknownClient is returned when an action targets a connection that
+// isn't live.
+var ErrUnknownClient = errors.New("unknown client")
+
+// rosterMessage lists the display names of everyone watching.
+type rosterMessage struct {
+	Type  string   `json:"type"`
+	Users []string `json:"users"`
+}
+
+// validateDisplayName normalises a viewer-chosen name.
+func validateDisplayName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxDisplayNameLen {
+		return "", ErrDisplayName
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", ErrDisplayName
+		}
+	}
+	return name, nil
+}
+
+// uniqueName returns name, or name with a "-2", "-3", ... suffix if another
+// client already uses it. The caller must hold h.mutex.
+func (h *Hub) uniqueName(client *Client, name string) string {
+	taken := make(map[string]bool, len(h.clients)+len(h.lingering))
+	for c := range h.clients {
+		if c != client && c.name != "" {
+			taken[c.name] = true
+		}
+	}
+	for lingering := range h.lingering {
+		taken[lingering] = true
+	}
+	candidate := name
+	for n := 2; taken[candidate]; n++ {
+		candidate = name + "-" + strconv.Itoa(n)
+	}
+	return candidate
+}
+
+// setName validates and stores a client's display name, then broadcasts the
+// updated roster.
+func (h *Hub) setName(client *Client, name string) error {
+	name, err := validateDisplayName(name)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	client.name = h.uniqueName(client, name)
+	h.mutex.Unlock()
+	h.broadcastRoster(client.game.Load())
+	return nil
+}
+
+// roster returns the encoded, sorted list of display names on the match gs.
+func (h *Hub) roster(gs *GameState) []byte {
+	h.mutex.Lock()
+	users := make([]string, 0, len(h.clients)+len(h.lingering))
+	for c := range h.clients {
+		if c.game.Load() == gs && c.name != "" {
+			users = append(users, c.name)
+		}
+	}
+	for name, c := range h.lingering {
+		if c.game.Load() == gs {
+			users = append(users, name)
+		}
+	}
+	h.mutex.Unlock()
+	sort.Strings(users)
+
+	payload, _ := json.Marshal(rosterMessage{Type: "roster", Users: users})
+	return payload
+}
+
+// broadcastRoster sends every live client of the match gs its roster.
+func (h *Hub) broadcastRoster(gs *GameState) {
+	h.broadcastTo(gs, h.roster(gs), nil, time.Time{})
+}
+
+// linger keeps a dropped client's name in the roster for disconnectGrace,
+// broadcasting its departure only if it hasn't resumed by then. It reports
+// false when there is no grace, leaving the caller to update the roster.
+func (h *Hub) linger(client *Client, name string) bool {
+	if disconnectGrace <= 0 {
+		return false
+	}
+	h.mutex.Lock()
+	h.lingering[name] = client
+	h.mutex.Unlock()
+
+	time.AfterFunc(disconnectGrace, func() {
+		h.mutex.Lock()
+		expired := h.lingering[name] == client
+		if expired {
+			delete(h.lingering, name)
+		}
+		h.mutex.Unlock()
+		if expired {
+			h.broadcastRoster(client.game.Load())
+		}
+	})
+	return true
+}
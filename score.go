@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scoreCache keeps the last broadcast state of a match serialized for
+// /score pollers, so high-traffic boards don't marshal on every request.
+type scoreCache struct {
+	// match is the match cached; nil means the default match.
+	match *GameState
+
+	mu      sync.RWMutex
+	payload []byte
+	etag    string
+	version uint64
+	// changed is closed and replaced on every store, waking long pollers.
+	changed chan struct{}
+}
+
+var publicScore = &scoreCache{}
+
+// public returns the /score cache of the match gs.
+func (gs *GameState) public() *scoreCache {
+	if gs.scores != nil {
+		return gs.scores
+	}
+	return publicScore
+}
+
+// stateETag derives a strong ETag from the state version.
+func stateETag(version uint64) string {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], version)
+	h.Write(b[:])
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// store replaces the cached state; it is called on every broadcast.
+// Broadcasts can finish out of order, so older versions are ignored.
+func (c *scoreCache) store(payload []byte, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.payload != nil && version < c.version {
+		return
+	}
+	c.payload = payload
+	c.version = version
+	c.etag = stateETag(version)
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+// invalidate drops the cached payload, so the next load renders the live
+// state.
+func (c *scoreCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payload = nil
+	c.etag = ""
+}
+
+// hasWaiters reports whether a long poller is waiting for the next state.
+func (c *scoreCache) hasWaiters() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.changed != nil
+}
+
+// next waits until the cached state is newer than since and returns it. It
+// reports false if ctx ends first.
+func (c *scoreCache) next(ctx context.Context, since uint64) ([]byte, string, bool) {
+	c.load()
+	for {
+		c.mu.Lock()
+		if c.version > since {
+			payload, etag := c.payload, c.etag
+			c.mu.Unlock()
+			return payload, etag, true
+		}
+		if c.changed == nil {
+			c.changed = make(chan struct{})
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, "", false
+		}
+	}
+}
+
+// load returns the cached state, filling the cache from the live game if
+// nothing has been broadcast yet. Under a broadcast delay the default match
+// is filled from the state the delay currently shows.
+func (c *scoreCache) load() ([]byte, string) {
+	c.mu.RLock()
+	payload, etag := c.payload, c.etag
+	c.mu.RUnlock()
+	if payload != nil {
+		return payload, etag
+	}
+
+	gs := c.match
+	if gs == nil {
+		if ch := viewChannels[""]; ch != nil {
+			state := ch.latest()
+			payload, _ = json.Marshal(state)
+			payload = state.publicPayload(payload)
+			c.store(payload, state.Version)
+			return payload, stateETag(state.Version)
+		}
+		gs = &gameState
+	}
+	gs.mu.Lock()
+	payload, version := gs.joinState().publicPayload(gs.snapshot()), gs.Version
+	gs.mu.Unlock()
+	c.store(payload, version)
+	return payload, stateETag(version)
+}
+
+// etagMatches reports whether an If-None-Match header covers etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// longPollTimeout bounds how long /next holds a request open.
+var longPollTimeout = envDuration("LONGPOLL_TIMEOUT", 25*time.Second)
+
+// serveNext long-polls for the next state of a match: it answers as soon as
+// the version exceeds ?since=, or with 204 No Content once longPollTimeout
+// passes, so clients get live updates over plain HTTP where sockets can't be
+// kept open.
+func serveNext(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(matchID(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "since must be a state version")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), longPollTimeout)
+	defer cancel()
+	payload, etag, ok := gs.public().next(ctx, since)
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// serveScore returns the public state of a match for HTTP pollers, answering
+// 304 Not Modified when the client already has the current version.
+//
+// HEAD /score?match= only checks that the match exists, 200 or 404, so
+// clients and load balancers can catch a mistyped ID before opening a
+// socket. It only reads the room registry and renders nothing.
+func serveScore(w http.ResponseWriter, r *http.Request) {
+	gs, ok := lookupMatch(matchID(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown match")
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	payload, etag := gs.public().load()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeadScoreChecksMatchExists(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "match=cup")
+	readState(t, conn)
+	for path, want := range map[string]int{
+		"/score":           http.StatusOK,
+		"/score?match=cup": http.StatusOK,
+		"/score?match=cpu": http.StatusNotFound,
+	} {
+		resp, body := do(t, srv, http.MethodHead, path, nil, "")
+		if resp.StatusCode != want {
+			t.Errorf("HEAD %s: %d, want %d", path, resp.StatusCode, want)
+		}
+		if len(body) != 0 {
+			t.Errorf("HEAD %s returned a body: %s", path, body)
+		}
+	}
+	if n := rooms.count(); n != 1 {
+		t.Errorf("%d rooms open after the checks, want only cup", n)
+	}
+
+	// Once its last client leaves, the room is gone.
+	conn.Close()
+	waitFor(t, "cup to close", func() bool { return rooms.count() == 0 })
+	if resp, _ := do(t, srv, http.MethodHead, "/score?match=cup", nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a closed match: %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// clientCloseTimeout bounds each client's close handshake.
+var clientCloseTimeout = envDuration("SHUTDOWN_CLIENT_TIMEOUT", time.Second)
+
+// closeAll runs the close handshake with every client (live or queued): it
+// sends a reconnect hint and a going-away close frame carrying why, waits
+// for the peer's close reply, then closes the connection. Each client gets
+// at most perClient for the whole handshake, after which it is
+// force-closed, and the pass is bounded by grace.
+func (h *Hub) closeAll(grace, perClient time.Duration, why string) {
+	h.closeWhere(func(*Client) bool { return true }, grace, perClient, why)
+}
+
+// closeWhere is closeAll for the clients, live or queued, that match
+// selects.
+func (h *Hub) closeWhere(match func(*Client) bool, grace, perClient time.Duration, why string) {
+	h.mutex.Lock()
+	var clients []*Client
+	for client := range h.clients {
+		if match(client) {
+			clients = append(clients, client)
+		}
+	}
+	for _, client := range h.queue {
+		if match(client) {
+			clients = append(clients, client)
+		}
+	}
+	h.mutex.Unlock()
+
+	deadline := time.Now().Add(grace)
+	if d := time.Now().Add(perClient); d.Before(deadline) {
+		deadline = d
+	}
+
+	// WriteControl is safe alongside other writers, so a client stuck in a
+	// broadcast write can't hold up the rest.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	for _, client := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			defer c.cancel()
+			c.closing.Store(true)
+			// Each client gets its own hint, so they don't all return at
+			// once when the server comes back.
+			hint, reason := reconnectPayloads(retryHint(), why)
+			c.closeBy.Store(deadline.UnixNano())
+			// Written directly, so it can't be overtaken by the close frame.
+			c.writeNow(outFrame{messageType: websocket.TextMessage, payload: hint})
+			frame := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+			if err := c.conn.WriteControl(websocket.CloseMessage, frame, deadline); err != nil {
+				mu.Lock()
+				failed = append(failed, c.conn.RemoteAddr().String())
+				mu.Unlock()
+				return
+			}
+			// The read loop ends when the peer's close frame arrives, or
+			// when the deadline passes for a peer that never answers.
+			c.conn.SetReadDeadline(deadline)
+			<-c.readDone
+		}(client)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		log.Printf("grace expired before all clients were closed (%s)", why)
+		// Closing the connections fails writes still stuck on them.
+		for _, client := range clients {
+			client.cancel()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) > 0 {
+		log.Printf("could not notify %d of %d clients (%s): %v", len(failed), len(clients), why, failed)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCloseAllIsBoundedByGrace(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Neither client reads, so neither answers the close handshake.
+	dial(t, srv, "/ws")
+	dial(t, srv, "/ws")
+	waitFor(t, "both clients to register", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 2
+	})
+	// One is also stuck in a write, as behind a full send buffer.
+	hub.mutex.Lock()
+	var stuck *Client
+	for c := range hub.clients {
+		stuck = c
+		break
+	}
+	hub.mutex.Unlock()
+	stuck.writeMu.Lock()
+	defer stuck.writeMu.Unlock()
+
+	const grace = 300 * time.Millisecond
+	start := time.Now()
+	hub.closeAll(grace, 100*time.Millisecond, "test shutdown")
+	if took := time.Since(start); took > grace+200*time.Millisecond {
+		t.Errorf("closeAll took %s with a grace of %s", took, grace)
+	}
+	waitFor(t, "the clients to be dropped", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 0
+	})
+}
+
+func TestCloseHandshake(t *testing.T) {
+	srv := newTestServer(t)
+
+	// A reading client answers the close frame, which ends the handshake.
+	clean := dial(t, srv, "/ws")
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := clean.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	waitFor(t, "the client to register", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 1
+	})
+	start := time.Now()
+	hub.closeAll(5*time.Second, 2*time.Second, "test shutdown")
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("clean close took %s", took)
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("client saw %v, want a going-away close", err)
+	}
+
+	waitFor(t, "the client to unregister", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 0
+	})
+
+	// A peer that never answers is force-closed at the per-client timeout.
+	dial(t, srv, "/ws")
+	waitFor(t, "the silent client to register", func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return len(hub.clients) == 1
+	})
+	const perClient = 200 * time.Millisecond
+	start = time.Now()
+	hub.closeAll(5*time.Second, perClient, "test shutdown")
+	if took := time.Since(start); took < perClient || took > perClient+500*time.Millisecond {
+		t.Errorf("silent peer closed after %s, want about %s", took, perClient)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// actionSigningKey, when set, makes every controller action carry an
+// HMAC-SHA256 signature, so a hijacked /control connection can't forge or
+// replay actions. A signed frame wraps the action's JSON text, which is
+// what the signature covers byte for byte:
+//
+//	{"signed":"{\"action\":\"increment\",\"team\":\"A\",\"nonce\":\"4f1c…\",\"ts\":1760000000000}",
+//	 "sig":"<hex HMAC-SHA256 of the signed string>"}
+//
+// The action must include a unique nonce and its signing time in Unix
+// milliseconds, within signatureMaxAge of the server's clock.
+var actionSigningKey = envString("ACTION_SIGNING_KEY", "")
+
+// signatureMaxAge bounds the clock skew and delay a signed action may have.
+var signatureMaxAge = envDuration("SIGNATURE_MAX_AGE", 30*time.Second)
+
+// Errors for rejected signed actions, sent to the client as error frames.
+var (
+	ErrUnsigned       = errors.New("actions must be signed")
+	ErrBadSignature   = errors.New("invalid action signature")
+	ErrStaleSignature = errors.New("signed action is too old or from the future")
+	ErrReplayedNonce  = errors.New("nonce already used")
+)
+
+// signedAction is the wire form of a signed frame.
+type signedAction struct {
+	Signed string `json:"signed"`
+	Sig    string `json:"sig"`
+}
+
+// nonceCache remembers the nonces seen within the signature window. Older
+// nonces needn't be kept: their timestamps are refused anyway.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce to when it can be forgotten
+}
+
+var usedNonces = &nonceCache{seen: make(map[string]time.Time)}
+
+// use records nonce, reporting false if it was already used.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if expires, ok := c.seen[nonce]; ok && now.Before(expires) {
+		return false
+	}
+	if len(c.seen) >= 1024 {
+		for n, expires := range c.seen {
+			if !now.Before(expires) {
+				delete(c.seen, n)
+			}
+		}
+	}
+	// A timestamp up to signatureMaxAge ahead stays valid for twice as long.
+	c.seen[nonce] = now.Add(2 * signatureMaxAge)
+	return true
+}
+
+// verifySigned checks a signed controller frame and returns the action's
+// JSON. Without a signing key the frame is passed through as is.
+func verifySigned(payload []byte) ([]byte, error) {
+	if actionSigningKey == "" {
+		return payload, nil
+	}
+	var frame signedAction
+	if err := json.Unmarshal(payload, &frame); err != nil || frame.Signed == "" {
+		return nil, ErrUnsigned
+	}
+	sig, err := hex.DecodeString(frame.Sig)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(actionSigningKey))
+	mac.Write([]byte(frame.Signed))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrBadSignature
+	}
+
+	var fresh struct {
+		Nonce string `json:"nonce"`
+		Ts    int64  `json:"ts"`
+	}
+	if err := json.Unmarshal([]byte(frame.Signed), &fresh); err != nil || fresh.Nonce == "" {
+		return nil, ErrBadSignature
+	}
+	now := time.Now()
+	if skew := now.Sub(time.UnixMilli(fresh.Ts)); skew > signatureMaxAge || skew < -signatureMaxAge {
+		return nil, ErrStaleSignature
+	}
+	if !usedNonces.use(fresh.Nonce, now) {
+		return nil, ErrReplayedNonce
+	}
+	return []byte(frame.Signed), nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// simulator scores random points for demos and load tests. It is enabled by
+// SIMULATOR and scores through applyAndBroadcast like any controller.
+//
+// By default it awards one point to a random team per interval. With
+// SIMULATOR_RATES, e.g. "A=1.5,B=0.9", each team instead scores following a
+// Poisson process at its rate in points per minute, which mimics real games
+// closely enough for capacity planning. Teams not listed don't score.
+//
+// With SIMULATOR_NEEDS_CLIENTS, the default, it idles while nobody is
+// connected, so an unwatched demo neither burns cycles nor runs its score
+// up, and resumes as soon as a client connects.
+type simulator struct {
+	mu       sync.Mutex
+	paused   bool
+	interval time.Duration
+	rates    map[string]float64
+
+	needsClients bool
+	idle         bool       // waiting for a client; guarded by mu
+	connected    *sync.Cond // signalled on mu when a client connects
+}
+
+// sim is the running simulator, or nil when disabled.
+var sim = simulatorFromEnv()
+
+func simulatorFromEnv() *simulator {
+	if !envBool("SIMULATOR", false) {
+		return nil
+	}
+	s := &simulator{
+		interval:     envDuration("SIMULATOR_INTERVAL", 5*time.Second),
+		rates:        parseRates(envString("SIMULATOR_RATES", "")),
+		needsClients: envBool("SIMULATOR_NEEDS_CLIENTS", true),
+	}
+	s.connected = sync.NewCond(&s.mu)
+	return s
+}
+
+// parseRates parses a SIMULATOR_RATES list of team=points-per-minute pairs.
+func parseRates(list string) map[string]float64 {
+	if list == "" {
+		return nil
+	}
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(list, ",") {
+		team, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		rate, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || rate < 0 {
+			log.Printf("invalid entry %q in SIMULATOR_RATES", entry)
+			continue
+		}
+		rates[strings.TrimSpace(team)] = rate
+	}
+	return rates
+}
+
+// poisson draws from a Poisson distribution with mean lambda (Knuth's
+// method, fine for the small means of a scoreboard tick).
+func poisson(lambda float64) int {
+	limit := math.Exp(-lambda)
+	k := 0
+	for p := rand.Float64(); p > limit; p *= rand.Float64() {
+		k++
+	}
+	return k
+}
+
+// run steps the simulator every interval until ctx is cancelled, skipping
+// ticks while paused and waiting out spells without clients.
+func (s *simulator) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	stop := context.AfterFunc(ctx, s.clientConnected)
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.needsClients && !s.awaitClients(ctx) {
+			return
+		}
+		switch {
+		case s.isPaused():
+		case s.rates != nil:
+			s.tick()
+		default:
+			s.step()
+		}
+	}
+}
+
+// tick scores one interval of the rate-driven simulation, as a single
+// broadcast.
+func (s *simulator) tick() {
+	minutes := s.interval.Minutes()
+	gameState.mu.Lock()
+	var msgs []Message
+	for _, t := range gameState.Teams {
+		for range poisson(s.rates[t.Name] * minutes) {
+			msgs = append(msgs, Message{Action: "increment", Team: t.Name, Actor: "simulator", Trusted: true})
+		}
+	}
+	gameState.mu.Unlock()
+	if len(msgs) > 0 {
+		applyAndBroadcast(msgs...)
+	}
+}
+
+// step applies exactly one simulated action: a point for a random team,
+// weighted by its rate when rates are configured.
+func (s *simulator) step() error {
+	gameState.mu.Lock()
+	team := gameState.Teams[rand.Intn(len(gameState.Teams))].Name
+	if s.rates != nil {
+		team = s.weightedTeam(gameState.Teams)
+	}
+	gameState.mu.Unlock()
+	if team == "" {
+		return errors.New("no team has a scoring rate")
+	}
+	return applyAndBroadcast(Message{Action: "increment", Team: team, Actor: "simulator", Trusted: true})
+}
+
+// weightedTeam picks a team with probability proportional to its rate, or
+// returns "" if every rate is zero.
+func (s *simulator) weightedTeam(teams []Team) string {
+	var total float64
+	for _, t := range teams {
+		total += s.rates[t.Name]
+	}
+	if total == 0 {
+		return ""
+	}
+	pick := rand.Float64() * total
+	for _, t := range teams {
+		if pick -= s.rates[t.Name]; pick < 0 {
+			return t.Name
+		}
+	}
+	return teams[len(teams)-1].Name
+}
+
+// awaitClients blocks while no client is connected, reporting false if ctx
+// ends first.
+func (s *simulator) awaitClients(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hasClients() {
+		return true
+	}
+	s.idle = true
+	log.Println("Simulator idle until a client connects")
+	for !hasClients() && ctx.Err() == nil {
+		s.connected.Wait()
+	}
+	s.idle = false
+	return ctx.Err() == nil
+}
+
+// clientConnected wakes a simulator waiting for clients. It must not be
+// called with hub.mutex held.
+func (s *simulator) clientConnected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected.Broadcast()
+}
+
+// hasClients reports whether any live client is connected to the default
+// match, which the simulator scores.
+func hasClients() bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return hub.clientsOn(&gameState) > 0
+}
+
+func (s *simulator) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+func (s *simulator) isIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idle
+}
+
+func (s *simulator) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+// status describes the simulator for /healthz.
+func (s *simulator) status() string {
+	switch {
+	case s == nil:
+		return "disabled"
+	case s.isPaused():
+		return "paused"
+	case s.isIdle():
+		return "idle"
+	default:
+		return "running"
+	}
+}
+
+// serveSimulator handles POST /admin/sim/{pause,resume,step}.
+func serveSimulator(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if sim == nil {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "simulator disabled")
+		return
+	}
+	switch r.URL.Path {
+	case "/admin/sim/pause":
+		sim.setPaused(true)
+	case "/admin/sim/resume":
+		sim.setPaused(false)
+	case "/admin/sim/step":
+		if err := sim.step(); err != nil {
+			writeJSONError(w, http.StatusConflict, CodeConflict, err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown simulator command")
+		return
+	}
+	log.Printf("Simulator %s via %s", sim.status(), r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"simulator": sim.status()})
+}
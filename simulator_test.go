@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSimulatorIdlesWithoutClients(t *testing.T) {
+	s := &simulator{interval: 5 * time.Millisecond, needsClients: true}
+	s.connected = sync.NewCond(&s.mu)
+	setVar(t, &sim, s)
+	srv := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	version := func() uint64 {
+		gameState.mu.Lock()
+		defer gameState.mu.Unlock()
+		return gameState.Version
+	}
+
+	waitFor(t, "the simulator to idle", s.isIdle)
+	time.Sleep(50 * time.Millisecond)
+	if v := version(); v != 0 {
+		t.Fatalf("simulator scored %d points with nobody connected", v)
+	}
+
+	for range 2 {
+		viewer := dial(t, srv, "/ws")
+		joined := readState(t, viewer)
+		// It resumes scoring for the viewer.
+		readStateWith(t, viewer, func(s StateJSON) bool { return s.Version > joined.Version })
+		if s.status() != "running" {
+			t.Errorf("status %q with a client connected, want running", s.status())
+		}
+		viewer.Close()
+		waitFor(t, "the simulator to idle again", s.isIdle)
+		idleAt := version()
+		time.Sleep(50 * time.Millisecond)
+		if v := version(); v != idleAt {
+			t.Errorf("simulator went from version %d to %d after the last client left", idleAt, v)
+		}
+	}
+}
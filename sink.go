@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// EventSink receives the audit stream of applied actions. Emit is called
+// with the game state locked, so a sink must not block: slow backends
+// should queue, as the webhook sender does.
+type EventSink interface {
+	Emit(ev ScoreEvent)
+}
+
+// noopSink discards events; it is the default.
+type noopSink struct{}
+
+func (noopSink) Emit(ScoreEvent) {}
+
+// jsonLinesSink writes each event as a line of JSON.
+type jsonLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONLinesSink(w io.Writer) *jsonLinesSink {
+	return &jsonLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonLinesSink) Emit(ev ScoreEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(ev); err != nil {
+		log.Printf("event sink: %v", err)
+	}
+}
+
+// eventSink is the active sink, chosen by EVENT_SINK: "stdout", "file"
+// (appending to EVENT_SINK_FILE) or unset for none. The webhook, when
+// configured, receives events in addition.
+var eventSink = eventSinkFromEnv()
+
+func eventSinkFromEnv() EventSink {
+	switch kind := envString("EVENT_SINK", ""); kind {
+	case "":
+		return noopSink{}
+	case "stdout":
+		return newJSONLinesSink(os.Stdout)
+	case "file":
+		path := envString("EVENT_SINK_FILE", "events.jsonl")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Printf("event sink: %v; events will not be recorded", err)
+			return noopSink{}
+		}
+		return newJSONLinesSink(f)
+	default:
+		log.Printf("unknown EVENT_SINK=%q; events will not be recorded", kind)
+		return noopSink{}
+	}
+}
+
+// emitEvent hands ev to every configured destination.
+func emitEvent(ev ScoreEvent) {
+	eventSink.Emit(ev)
+	webhook.Emit(ev)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventsReachTheFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	t.Setenv("EVENT_SINK", "file")
+	t.Setenv("EVENT_SINK_FILE", path)
+	setVar(t, &eventSink, eventSinkFromEnv())
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 2})
+	send(t, conn, Message{Action: "set", Team: "B", Value: 4})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 2 })
+	// Rooms aren't reported.
+	room := dialControl(t, srv, "match=side")
+	readState(t, room)
+	send(t, room, Message{Action: "increment", Team: "A"})
+	readStateWith(t, room, func(s StateJSON) bool { return s.Version == 1 })
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []ScoreEvent
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var ev ScoreEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("decoding %s: %v", scanner.Bytes(), err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events in the sink, want 2: %+v", len(events), events)
+	}
+	if ev := events[0]; ev.Version != 1 || ev.Action != "increment" || ev.Points != 2 {
+		t.Errorf("first event = %+v, want the increment by 2 at version 1", ev)
+	}
+	if ev := events[1]; ev.Version != 2 || ev.Action != "set" || ev.Teams[1].Score != 4 {
+		t.Errorf("second event = %+v, want the set of B to 4 at version 2", ev)
+	}
+}
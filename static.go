@@ -0,0 +1,36 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+	"strings"
+)
+
+// embeddedAssets is the front-end compiled into the binary.
+//
+//go:embed index.html
+var embeddedAssets embed.FS
+
+// staticDir, when set, serves the front-end from disk instead, so a full UI
+// with CSS, scripts and images can be deployed without rebuilding.
+var staticDir = envString("STATIC_DIR", "")
+
+// staticHandler serves the front-end for every route not claimed by the
+// WebSocket or API handlers. http.FileServer cleans the path, so requests
+// can't climb out of the asset root; dotfiles such as .git are refused too.
+func staticHandler() http.Handler {
+	var root http.FileSystem = http.FS(embeddedAssets)
+	if staticDir != "" {
+		root = http.Dir(staticDir)
+	}
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(part, ".") {
+				writeJSONError(w, http.StatusNotFound, CodeNotFound, "not found")
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
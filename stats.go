@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of power-of-two buckets, starting at 1µs. The
+// last bucket (~67s) absorbs anything slower.
+const latencyBuckets = 27
+
+// statsWindow is how often the rolling latency window advances. Percentiles
+// cover between one and two windows of samples.
+const statsWindow = time.Minute
+
+// latencyHistogram is a bucketed histogram of broadcast latencies over a
+// rolling window. It keeps the current and previous window and reports
+// percentiles over both, so results never drop to empty right after a
+// rotation.
+type latencyHistogram struct {
+	mu       sync.Mutex
+	current  [latencyBuckets]uint64
+	previous [latencyBuckets]uint64
+	rotated  time.Time
+}
+
+var broadcastLatency = &latencyHistogram{rotated: time.Now()}
+
+// oversizedBroadcasts counts broadcasts downgraded to the compact payload.
+var oversizedBroadcasts atomic.Uint64
+
+// skippedBroadcasts counts broadcasts skipped because nobody was listening.
+var skippedBroadcasts atomic.Uint64
+
+// dedupedBroadcasts counts broadcasts dropped because the state was unchanged.
+var dedupedBroadcasts atomic.Uint64
+
+// refusedBroadcasts counts broadcasts refused as implausibly large.
+var refusedBroadcasts atomic.Uint64
+
+// coalescedBroadcasts counts broadcasts held back by BROADCAST_RATE.
+var coalescedBroadcasts atomic.Uint64
+
+// slowClientDrops counts clients dropped because their send buffer filled.
+var slowClientDrops atomic.Uint64
+
+// writeRetryCount counts write timeouts that stalled writes were retried
+// past; see writeRetries.
+var writeRetryCount atomic.Uint64
+
+// bucketBound returns the upper bound of bucket i.
+func bucketBound(i int) time.Duration {
+	return time.Microsecond << i
+}
+
+// rotate advances the window if it has expired. The caller must hold h.mu.
+func (h *latencyHistogram) rotate(now time.Time) {
+	switch elapsed := now.Sub(h.rotated); {
+	case elapsed >= 2*statsWindow:
+		h.previous = [latencyBuckets]uint64{}
+		h.current = [latencyBuckets]uint64{}
+		h.rotated = now
+	case elapsed >= statsWindow:
+		h.previous = h.current
+		h.current = [latencyBuckets]uint64{}
+		h.rotated = now
+	}
+}
+
+// observe records a single latency sample.
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < latencyBuckets-1 && d > bucketBound(i) {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(time.Now())
+	h.current[i]++
+}
+
+// latencySummary is the JSON body served at /stats.
+type latencySummary struct {
+	WindowSeconds int     `json:"windowSeconds"`
+	Count         uint64  `json:"count"`
+	P50Ms         float64 `json:"p50Ms"`
+	P95Ms         float64 `json:"p95Ms"`
+	P99Ms         float64 `json:"p99Ms"`
+
+	OversizedBroadcasts uint64 `json:"oversizedBroadcasts"`
+}
+
+// summary reports the sample count and p50/p95/p99 estimates. Each percentile
+// is the upper bound of the bucket holding that rank.
+func (h *latencyHistogram) summary() latencySummary {
+	h.mu.Lock()
+	h.rotate(time.Now())
+	var merged [latencyBuckets]uint64
+	var total uint64
+	for i := range merged {
+		merged[i] = h.current[i] + h.previous[i]
+		total += merged[i]
+	}
+	h.mu.Unlock()
+
+	percentile := func(p float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		rank := uint64(p * float64(total))
+		var seen uint64
+		for i, n := range merged {
+			seen += n
+			if seen > rank {
+				return float64(bucketBound(i)) / float64(time.Millisecond)
+			}
+		}
+		return float64(bucketBound(latencyBuckets-1)) / float64(time.Millisecond)
+	}
+	return latencySummary{
+		WindowSeconds: int(2 * statsWindow / time.Second),
+		Count:         total,
+		P50Ms:         percentile(0.50),
+		P95Ms:         percentile(0.95),
+		P99Ms:         percentile(0.99),
+
+		OversizedBroadcasts: oversizedBroadcasts.Load(),
+	}
+}
+
+// serveStats reports broadcast latency percentiles, measured from an action
+// being applied to its state being handed to each client.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(broadcastLatency.summary())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store saves match snapshots between restarts. Save is called with the
+// game state locked after every change and must not keep gs; Load returns a
+// match as last saved, or an error wrapping fs.ErrNotExist if it never was.
+// List names every saved match.
+//
+// GameState holds its lock, so matches are passed by pointer rather than by
+// value.
+//
+// To add a backend, register a constructor from an init function in another
+// file of this package and select it with PERSIST_STORE:
+//
+//	func init() {
+//		storeBackends["redis"] = func() (Store, error) {
+//			return newRedisStore(envString("REDIS_URL", ""))
+//		}
+//	}
+type Store interface {
+	Save(matchID string, gs *GameState) error
+	Load(matchID string) (*GameState, error)
+	List() ([]string, error)
+}
+
+// storeBackends maps PERSIST_STORE values to constructors.
+var storeBackends = map[string]func() (Store, error){
+	"file": func() (Store, error) {
+		path := envString("PERSIST_FILE", "")
+		if path == "" {
+			return nil, errors.New("the file store needs PERSIST_FILE")
+		}
+		return &fileStore{path: path}, nil
+	},
+	"memory": func() (Store, error) {
+		return newMemoryStore(), nil
+	},
+}
+
+// fileStore keeps each match in a JSON file. The default match lives at
+// path, so existing PERSIST_FILE snapshots keep loading; any other match
+// sits beside it with its ID added to the name, e.g. state-cup.json.
+type fileStore struct {
+	path string
+}
+
+// file returns the snapshot path of a match.
+func (s *fileStore) file(matchID string) string {
+	if matchID == defaultMatchID {
+		return s.path
+	}
+	ext := filepath.Ext(s.path)
+	return strings.TrimSuffix(s.path, ext) + "-" + matchID + ext
+}
+
+// Save atomically replaces the match's file.
+func (s *fileStore) Save(matchID string, gs *GameState) error {
+	if strings.ContainsAny(matchID, `/\`) || strings.HasPrefix(matchID, ".") {
+		return fmt.Errorf("invalid match ID %q", matchID)
+	}
+	data, err := json.Marshal(gs)
+	if err != nil {
+		return err
+	}
+	path := s.file(matchID)
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore) Load(matchID string) (*GameState, error) {
+	data, err := os.ReadFile(s.file(matchID))
+	if err != nil {
+		return nil, err
+	}
+	gs := new(GameState)
+	if err := json.Unmarshal(data, gs); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.file(matchID), err)
+	}
+	return gs, nil
+}
+
+func (s *fileStore) List() ([]string, error) {
+	var ids []string
+	if _, err := os.Stat(s.path); err == nil {
+		ids = append(ids, defaultMatchID)
+	}
+	ext := filepath.Ext(s.path)
+	prefix := strings.TrimSuffix(s.path, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// memoryStore keeps snapshots in memory: they survive nothing, which suits
+// tests and throwaway demo servers. States are kept encoded, so a caller
+// can't alias a saved match.
+type memoryStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{states: make(map[string][]byte)}
+}
+
+func (s *memoryStore) Save(matchID string, gs *GameState) error {
+	data, err := json.Marshal(gs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[matchID] = data
+	return nil
+}
+
+func (s *memoryStore) Load(matchID string) (*GameState, error) {
+	s.mu.Lock()
+	data, ok := s.states[matchID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("match %q: %w", matchID, fs.ErrNotExist)
+	}
+	gs := new(GameState)
+	if err := json.Unmarshal(data, gs); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+func (s *memoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.states))
+	for id := range s.states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"livescore/engine"
+)
+
+func TestRoomsReopenFromTheStore(t *testing.T) {
+	store := newMemoryStore()
+	setVar(t, &persist, &persister{mode: PersistSnapshot, store: store})
+	srv := newTestServer(t)
+
+	conn := dialControl(t, srv, "match=cup")
+	readState(t, conn)
+	send(t, conn, Message{Action: "increment", Team: "A", Value: 3})
+	readStateWith(t, conn, func(s StateJSON) bool { return s.Version == 1 })
+	conn.Close()
+	waitFor(t, "the room to close", func() bool { return rooms.count() == 0 })
+
+	if ids, _ := store.List(); !slices.Contains(ids, "cup") {
+		t.Fatalf("stored matches %v, want cup among them", ids)
+	}
+	conn = dialControl(t, srv, "match=cup")
+	if s := readState(t, conn); s.Version != 1 || score(t, s, "A") != 3 {
+		t.Errorf("reopened cup at version %d with A=%v, want version 1 with A=3", s.Version, score(t, s, "A"))
+	}
+
+	// The default match goes through the same store.
+	onDefault := dialControl(t, srv, "")
+	readState(t, onDefault)
+	send(t, onDefault, Message{Action: "increment", Team: "B"})
+	readStateWith(t, onDefault, func(s StateJSON) bool { return s.Version == 1 })
+	saved, err := store.Load(defaultMatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != 1 || saved.Team("B").Score != 1 {
+		t.Errorf("saved default match at version %d with B=%v, want version 1 with B=1", saved.Version, saved.Team("B").Score)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := &fileStore{path: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := store.Load("cup"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loading a match never saved: %v, want fs.ErrNotExist", err)
+	}
+
+	gs := &GameState{GameState: engine.GameState{Teams: engine.DefaultTeams(), Period: 2, Version: 7}}
+	gs.Team("A").Score = 4
+	for _, id := range []string{defaultMatchID, "cup"} {
+		if err := store.Save(id, gs); err != nil {
+			t.Fatalf("saving %s: %v", id, err)
+		}
+	}
+	if err := store.Save("../escape", gs); err == nil {
+		t.Error("saved a match ID naming another directory")
+	}
+
+	if ids, err := store.List(); err != nil || !slices.Equal(ids, []string{"cup", defaultMatchID}) {
+		t.Errorf("List() = %v, %v, want [cup %s]", ids, err, defaultMatchID)
+	}
+	loaded, err := store.Load("cup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Period != 2 || loaded.Version != 7 || loaded.Team("A").Score != 4 {
+		t.Errorf("loaded period %d version %d A=%v, want period 2 version 7 A=4", loaded.Period, loaded.Version, loaded.Team("A").Score)
+	}
+}
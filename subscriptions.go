@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Subscriptions let one connection follow several boards, for operator
+// consoles. A connection with ?subscribe=b,c follows the matches b and c
+// beside its own ?match=, joining them like a connection of their own, for
+// as long as it stays. Their broadcasts reach it wrapped in a
+// taggedMessage naming the match, rendered without the connection's
+// filter, locale or binary encoding; its own match's arrive as usual. An
+// action with a "match" field applies to that match, which must be the
+// connection's own or one it subscribed to, under the role it holds there.
+var maxSubscriptions = envInt("MAX_SUBSCRIPTIONS", 8)
+
+// ErrTooManySubscriptions refuses a connection subscribing to more matches
+// than maxSubscriptions.
+var ErrTooManySubscriptions = fmt.Errorf("at most %d matches may be subscribed to", maxSubscriptions)
+
+// subscription is a match a connection follows besides its own.
+type subscription struct {
+	game *GameState
+	// role is what the connection may do on the match; see
+	// subscriptionRole.
+	role string
+}
+
+// taggedMessage carries a frame of a subscribed match.
+type taggedMessage struct {
+	Type    string          `json:"type"`
+	Match   string          `json:"match"`
+	Message json.RawMessage `json:"message"`
+}
+
+// tagged wraps payload, a frame broadcast on the match gs, for its
+// subscribers.
+func tagged(gs *GameState, payload []byte) []byte {
+	out, _ := json.Marshal(taggedMessage{Type: "match", Match: gs.matchID(), Message: payload})
+	return out
+}
+
+// subscribe joins the matches r lists in ?subscribe=, other than primary,
+// the connection's own. Every subscription returned must be given back
+// with leaveSubscriptions.
+func subscribe(r *http.Request, primary *GameState, role string) ([]subscription, error) {
+	list := r.URL.Query().Get("subscribe")
+	if list == "" {
+		return nil, nil
+	}
+	var subs []subscription
+	seen := map[string]bool{primary.matchID(): true}
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		key := scopedMatchID(r, id)
+		if id == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(subs) == maxSubscriptions {
+			leaveSubscriptions(subs)
+			return nil, ErrTooManySubscriptions
+		}
+		game := &gameState
+		if key != defaultMatchID {
+			var err error
+			if game, err = rooms.join(key, roomSetup{}); err != nil {
+				leaveSubscriptions(subs)
+				return nil, fmt.Errorf("match %s: %w", id, err)
+			}
+		}
+		subs = append(subs, subscription{game: game, role: subscriptionRole(r, id, role)})
+	}
+	return subs, nil
+}
+
+// leaveSubscriptions gives back the rooms subs joined.
+func leaveSubscriptions(subs []subscription) {
+	for _, sub := range subs {
+		if sub.game != &gameState {
+			rooms.leave(sub.game)
+		}
+	}
+}
+
+// subscriptionRole returns the role a connection opened with role holds on
+// the subscribed match id: what roleForToken gives its token on a
+// connection to that match, so a resolver can grant a token some matches
+// only. A role granted otherwise, by open mode, a trusted network or a
+// resumed session, holds on every match. A viewer connection stays a
+// viewer everywhere.
+func subscriptionRole(r *http.Request, id, role string) string {
+	token := requestToken(r)
+	if role == RoleViewer || roleForToken(r, token) != role {
+		return role
+	}
+	q := r.URL.Query()
+	q.Set("match", id)
+	q.Del("subscribe")
+	sub := r.Clone(r.Context())
+	sub.URL.RawQuery = q.Encode()
+	return roleForToken(sub, token)
+}
+
+// subscribedTo reports whether c follows the match gs by subscription.
+// The caller must hold hub.mutex.
+func (c *Client) subscribedTo(gs *GameState) (role string, ok bool) {
+	for _, sub := range c.subscriptions {
+		if sub.game == gs {
+			return sub.role, true
+		}
+	}
+	return "", false
+}
+
+// target resolves the "match" field of an action from c: c's own match or
+// one it subscribed to, with the role c holds there.
+func (c *Client) target(id string) (game *GameState, role string, err error) {
+	key := tenantMatchID(c.tenant, id)
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if game = c.game.Load(); game.matchID() == key {
+		return game, c.role, nil
+	}
+	for _, sub := range c.subscriptions {
+		if sub.game.matchID() == key {
+			return sub.game, sub.role, nil
+		}
+	}
+	return nil, "", errors.New("not subscribed to match " + id)
+}
+
+// subscribersLocked counts the subscriptions of live clients to the match
+// gs. The caller must hold h.mutex.
+func (h *Hub) subscribersLocked(gs *GameState) int {
+	n := 0
+	for c := range h.clients {
+		if _, ok := c.subscribedTo(gs); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// moveSubscriptionsLocked points the subscriptions to from at into, for a
+// merge, dropping those that now duplicate a client's own match or another
+// subscription. It returns the change in subscriptions to into. The caller
+// must hold h.mutex.
+func (h *Hub) moveSubscriptionsLocked(from, into *GameState) (delta int) {
+	for c := range h.clients {
+		var kept []subscription
+		for _, sub := range c.subscriptions {
+			if sub.game == into {
+				delta--
+			}
+			if sub.game == from {
+				sub.game = into
+			}
+			if sub.game == into {
+				if c.game.Load() == into || slices.ContainsFunc(kept, func(s subscription) bool { return s.game == into }) {
+					continue
+				}
+				delta++
+			}
+			kept = append(kept, sub)
+		}
+		c.subscriptions = kept
+	}
+	return delta
+}
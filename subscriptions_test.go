@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// readTagged reads frames until a state of the subscribed match id that
+// satisfies match.
+func readTagged(t *testing.T, conn *websocket.Conn, id string, match func(StateJSON) bool) StateJSON {
+	t.Helper()
+	for {
+		f := readFrame(t, conn, func(f frame) bool {
+			inner, _ := f["message"].(map[string]any)
+			return f["type"] == "match" && f["match"] == id && frame(inner).isState()
+		})
+		payload, _ := json.Marshal(f["message"])
+		var s StateJSON
+		if err := json.Unmarshal(payload, &s); err != nil {
+			t.Fatalf("decoding tagged state: %v", err)
+		}
+		if match(s) {
+			return s
+		}
+	}
+}
+
+func TestMultiplexedActionsRouteByMatch(t *testing.T) {
+	srv := newTestServer(t)
+
+	console := dialControl(t, srv, "match=a&subscribe=b")
+	readState(t, console)
+	readTagged(t, console, "b", func(StateJSON) bool { return true })
+	onA := dial(t, srv, "/ws?match=a")
+	readState(t, onA)
+	onB := dial(t, srv, "/ws?match=b")
+	readState(t, onB)
+
+	send(t, console, Message{Action: "increment", Team: "A", Value: 2, Match: "b"})
+	if s := readTagged(t, console, "b", func(s StateJSON) bool { return s.Version == 1 }); score(t, s, "A") != 2 {
+		t.Errorf("tagged b state A = %v, want 2", score(t, s, "A"))
+	}
+	if s := readState(t, onB); score(t, s, "A") != 2 {
+		t.Errorf("b viewer A = %v, want 2", score(t, s, "A"))
+	}
+
+	send(t, console, Message{Action: "increment", Team: "B", Match: "a"})
+	if s := readStateWith(t, console, func(s StateJSON) bool { return s.Version == 1 }); score(t, s, "A") != 0 || score(t, s, "B") != 1 {
+		t.Errorf("a state = %+v, want only B=1", s.Teams)
+	}
+	if s := readState(t, onA); score(t, s, "A") != 0 || score(t, s, "B") != 1 {
+		t.Errorf("a viewer got %+v, want only B=1", s.Teams)
+	}
+	// Without a match field the action is for the connection's own.
+	send(t, console, Message{Action: "increment", Team: "B"})
+	readStateWith(t, console, func(s StateJSON) bool { return s.Version == 2 })
+	send(t, console, Message{Action: "increment", Team: "B", Match: "b"})
+	if s := readState(t, onB); s.Version != 2 || score(t, s, "B") != 1 {
+		t.Errorf("b viewer got version %d with B=%v, want only b's own action", s.Version, score(t, s, "B"))
+	}
+
+	send(t, console, Message{Action: "increment", Team: "A", Match: "c"})
+	if text := readError(t, console); !strings.Contains(text, "not subscribed") {
+		t.Errorf("action on an unsubscribed match: %q", text)
+	}
+}
+
+func TestSubscriptionRolesPerMatch(t *testing.T) {
+	// The console's token scores everywhere but on the match "locked".
+	setVar(t, &roleForToken, func(r *http.Request, token string) string {
+		if token != "console" {
+			return tokenRole(r, token)
+		}
+		if r.URL.Query().Get("match") == "locked" {
+			return RoleViewer
+		}
+		return RoleController
+	})
+	srv := newTestServer(t)
+
+	console := dial(t, srv, "/control?token=console&match=a&subscribe=locked")
+	readState(t, console)
+	readTagged(t, console, "locked", func(StateJSON) bool { return true })
+
+	send(t, console, Message{Action: "increment", Team: "A", Match: "locked"})
+	if text := readError(t, console); !strings.Contains(text, "viewers cannot change the score") {
+		t.Errorf("action on a watch-only match: %q", text)
+	}
+	// It still follows the match as others score on it.
+	scorer := dialControl(t, srv, "match=locked")
+	readState(t, scorer)
+	send(t, scorer, Message{Action: "increment", Team: "B"})
+	if s := readTagged(t, console, "locked", func(s StateJSON) bool { return s.Version == 1 }); score(t, s, "B") != 1 || score(t, s, "A") != 0 {
+		t.Errorf("locked state = %+v, want only B=1", s.Teams)
+	}
+}
+
+func TestSubscriptionsHoldRoomsOpen(t *testing.T) {
+	srv := newTestServer(t)
+
+	console := dialControl(t, srv, "match=a&subscribe=b,c")
+	readState(t, console)
+	for _, id := range []string{"b", "c"} {
+		if _, ok := rooms.lookup(id); !ok {
+			t.Errorf("subscribed room %s not open", id)
+		}
+	}
+	console.Close()
+	waitFor(t, "subscribed rooms to close", func() bool { return rooms.count() == 0 })
+
+	if status := dialStatus(t, srv, "/control?token="+testControllerToken+"&subscribe=ok,bad%20id"); status != http.StatusBadRequest {
+		t.Errorf("invalid subscribed ID: status %d, want 400", status)
+	}
+	waitFor(t, "rooms of the refused connection to close", func() bool { return rooms.count() == 0 })
+}
+
+func TestMergeMovesSubscriptions(t *testing.T) {
+	withAdmin(t)
+	srv := newTestServer(t)
+
+	console := dialControl(t, srv, "match=a&subscribe=b")
+	readState(t, console)
+	readTagged(t, console, "b", func(StateJSON) bool { return true })
+	into := dialControl(t, srv, "match=c")
+	readState(t, into)
+
+	if resp, body := admin(t, srv, http.MethodPost, "/admin/merge?from=b&into=c", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("merge: %d %s", resp.StatusCode, body)
+	}
+	send(t, into, Message{Action: "increment", Team: "A", Value: 3})
+	readTagged(t, console, "c", func(s StateJSON) bool { return score(t, s, "A") == 3 })
+	send(t, console, Message{Action: "increment", Team: "A", Match: "c"})
+	if s := readStateWith(t, into, func(s StateJSON) bool { return score(t, s, "A") > 3 }); score(t, s, "A") != 4 {
+		t.Errorf("c A = %v after the console scored, want 4", score(t, s, "A"))
+	}
+
+	into.Close()
+	console.Close()
+	waitFor(t, "rooms to close", func() bool { return rooms.count() == 0 })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Connection tags label a connection for analytics, e.g. by where an
+// overlay is embedded: /ws?src=overlay&region=us. Only the query
+// parameters named in CLIENT_TAGS are taken as tags, which bounds both the
+// tags per connection and the label names on /metrics.
+var clientTagKeys = parseClientTagKeys(envString("CLIENT_TAGS", "src,region,app"))
+
+const (
+	// maxClientTags bounds CLIENT_TAGS.
+	maxClientTags = 8
+	// maxTagValueLen bounds a tag value.
+	maxTagValueLen = 32
+	// maxTagMetricValues is how many values of a tag /metrics breaks out;
+	// the connections with rarer values are counted as "other".
+	maxTagMetricValues = 20
+)
+
+func parseClientTagKeys(list string) []string {
+	var keys []string
+	for _, k := range strings.Split(list, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if len(keys) == maxClientTags {
+			log.Printf("CLIENT_TAGS: ignoring %q and later keys, at most %d tags", k, maxClientTags)
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// validTagValue reports whether v is a short token of letters, digits,
+// '.', '_' and '-', so it is safe in logs and metric labels.
+func validTagValue(v string) bool {
+	if v == "" || len(v) > maxTagValueLen {
+		return false
+	}
+	for _, r := range v {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// connectionTags reads the tags of a connection request, or reports the
+// first malformed one.
+func connectionTags(r *http.Request) (map[string]string, error) {
+	query := r.URL.Query()
+	var tags map[string]string
+	for _, k := range clientTagKeys {
+		v := query.Get(k)
+		if v == "" {
+			continue
+		}
+		if !validTagValue(v) {
+			return nil, fmt.Errorf("invalid tag %s: values are up to %d letters, digits, '.', '_' or '-'", k, maxTagValueLen)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// tagString renders tags for logs as " key=value ...", in CLIENT_TAGS
+// order, or "" without tags.
+func tagString(tags map[string]string) string {
+	var b strings.Builder
+	for _, k := range clientTagKeys {
+		if v, ok := tags[k]; ok {
+			fmt.Fprintf(&b, " %s=%s", k, v)
+		}
+	}
+	return b.String()
+}
+
+// writeTagMetrics prints the live connections per tag value.
+func writeTagMetrics(w io.Writer) {
+	counts := make(map[string]map[string]int, len(clientTagKeys))
+	hub.mutex.Lock()
+	for c := range hub.clients {
+		for k, v := range c.tags {
+			if counts[k] == nil {
+				counts[k] = make(map[string]int)
+			}
+			counts[k][v]++
+		}
+	}
+	hub.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP livescore_clients_by_tag Live WebSocket connections by connection tag.\n")
+	fmt.Fprintf(w, "# TYPE livescore_clients_by_tag gauge\n")
+	for _, k := range clientTagKeys {
+		values := make([]string, 0, len(counts[k]))
+		for v := range counts[k] {
+			values = append(values, v)
+		}
+		// Most connections first, so the rarest values fold into "other".
+		sort.Slice(values, func(i, j int) bool {
+			if n, m := counts[k][values[i]], counts[k][values[j]]; n != m {
+				return n > m
+			}
+			return values[i] < values[j]
+		})
+		other := 0
+		for i, v := range values {
+			if i >= maxTagMetricValues {
+				other += counts[k][v]
+				continue
+			}
+			fmt.Fprintf(w, "livescore_clients_by_tag{tag=%q,value=%q} %d\n", k, v, counts[k][v])
+		}
+		if other > 0 {
+			fmt.Fprintf(w, "livescore_clients_by_tag{tag=%q,value=\"other\"} %d\n", k, other)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Tenants namespace the matches of organizations sharing one server. A
+// request with ?tenant=acme addresses acme's matches: ?match=game1 names
+// the room "acme.game1", which no other tenant, and no request without a
+// tenant, can reach, and leaving out ?match= names acme's own default
+// board, a room like any other. Tenants and their controller tokens come
+// from TENANT_TOKENS, e.g. "acme=secret1,globex=secret2". A tenant's token
+// controls its matches only, and the server-wide tokens none of them.
+// TENANT_ADMIN_TOKENS, in the same form, opens the match admin endpoints
+// to a tenant's admin, limited to its matches.
+var (
+	tenantTokens      = parseTenantTokens("TENANT_TOKENS")
+	tenantAdminTokens = parseTenantTokens("TENANT_ADMIN_TOKENS")
+)
+
+// parseTenantTokens reads a tenant=token list from the environment
+// variable name.
+func parseTenantTokens(name string) map[string]string {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(envString(name, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		tenant, token, ok := strings.Cut(entry, "=")
+		tenant, token = strings.TrimSpace(tenant), strings.TrimSpace(token)
+		if !ok || !validMatchID(tenant) || token == "" {
+			if entry != "" {
+				log.Printf("invalid entry %q in %s", entry, name)
+			}
+			continue
+		}
+		tokens[tenant] = token
+	}
+	return tokens
+}
+
+// requestTenant returns the tenant r names with ?tenant=, or "" for none.
+func requestTenant(r *http.Request) string {
+	return r.URL.Query().Get("tenant")
+}
+
+// knownTenant reports whether the tenant r names, if any, is configured,
+// replying 404 if it isn't.
+func knownTenant(w http.ResponseWriter, r *http.Request) bool {
+	tenant := requestTenant(r)
+	if _, ok := tenantTokens[tenant]; tenant != "" && !ok {
+		writeJSONError(w, http.StatusNotFound, CodeNotFound, "unknown tenant")
+		return false
+	}
+	return true
+}
+
+// scopedMatchID returns the registry ID of the match id under the tenant r
+// names. The separator is outside the match ID alphabet, so scoped IDs
+// never collide with plain ones.
+func scopedMatchID(r *http.Request, id string) string {
+	return tenantMatchID(requestTenant(r), id)
+}
+
+// tenantMatchID returns the registry ID of the match id under tenant, ""
+// meaning none.
+func tenantMatchID(tenant, id string) string {
+	if tenant != "" {
+		return tenant + "." + id
+	}
+	return id
+}
+
+// validRoomID reports whether id, possibly scoped to a tenant, may name a
+// room.
+func validRoomID(id string) bool {
+	if tenant, match, scoped := strings.Cut(id, "."); scoped {
+		return validMatchID(tenant) && validMatchID(match)
+	}
+	return validMatchID(id)
+}
+
+// inTenant reports whether the match gs belongs to tenant, "" meaning the
+// server's own matches.
+func (gs *GameState) inTenant(tenant string) bool {
+	owner, _, scoped := strings.Cut(gs.matchID(), ".")
+	if !scoped {
+		return tenant == ""
+	}
+	return owner == tenant
+}
+
+// requireMatchAdmin wraps a match admin handler. Besides ADMIN_TOKEN, it
+// accepts the admin token of the tenant the request names, which scopes
+// the handler to that tenant's matches.
+func requireMatchAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tenant := requestTenant(r); tenant != "" {
+			if want := tenantAdminTokens[tenant]; want != "" && tokenMatches(requestToken(r), want) {
+				next(w, r)
+				return
+			}
+		}
+		requireAdmin(next)(w, r)
+	}
+}
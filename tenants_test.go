@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// withTenants configures the tenants acme and globex, each with a
+// controller and an admin token named after it.
+func withTenants(t *testing.T) {
+	setVar(t, &tenantTokens, map[string]string{"acme": "acme-secret", "globex": "globex-secret"})
+	setVar(t, &tenantAdminTokens, map[string]string{"acme": "acme-admin", "globex": "globex-admin"})
+}
+
+func TestTenantsIsolateSameMatchID(t *testing.T) {
+	withTenants(t)
+	srv := newTestServer(t)
+
+	acme := dial(t, srv, "/control?tenant=acme&token=acme-secret&match=game1")
+	readState(t, acme)
+	globex := dial(t, srv, "/control?tenant=globex&token=globex-secret&match=game1")
+	readState(t, globex)
+	plain := dialControl(t, srv, "match=game1")
+	readState(t, plain)
+
+	send(t, acme, Message{Action: "increment", Team: "A", Value: 2})
+	readStateWith(t, acme, func(s StateJSON) bool { return s.Version == 1 })
+	send(t, globex, Message{Action: "increment", Team: "B"})
+	if s := readStateWith(t, globex, func(s StateJSON) bool { return s.Version == 1 }); score(t, s, "A") != 0 || score(t, s, "B") != 1 {
+		t.Errorf("globex game1 = %+v, want only its own B=1", s.Teams)
+	}
+
+	for path, want := range map[string]float64{
+		"/score?tenant=acme&match=game1":   2,
+		"/score?tenant=globex&match=game1": 0,
+		"/score?match=game1":               0,
+	} {
+		resp, body := get(t, srv, path, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %d %s", path, resp.StatusCode, body)
+			continue
+		}
+		var s StateJSON
+		json.Unmarshal(body, &s)
+		if got := score(t, s, "A"); got != want {
+			t.Errorf("GET %s: A = %v, want %v", path, got, want)
+		}
+	}
+
+	// A viewer of one tenant's game1 hears nothing of the other's.
+	viewer := dial(t, srv, "/ws?tenant=globex&match=game1")
+	readState(t, viewer)
+	send(t, acme, Message{Action: "increment", Team: "A"})
+	readStateWith(t, acme, func(s StateJSON) bool { return s.Version == 2 })
+	send(t, globex, Message{Action: "increment", Team: "B"})
+	if s := readState(t, viewer); score(t, s, "A") != 0 || score(t, s, "B") != 2 {
+		t.Errorf("globex viewer got %+v, want A=0 B=2", s.Teams)
+	}
+}
+
+func TestTenantTokensAreScoped(t *testing.T) {
+	withTenants(t)
+	srv := newTestServer(t)
+
+	for path, want := range map[string]int{
+		"/control?tenant=globex&token=acme-secret&match=game1":           http.StatusUnauthorized,
+		"/control?tenant=acme&token=" + testControllerToken + "&match=g": http.StatusUnauthorized,
+		"/control?token=acme-secret&match=game1":                         http.StatusUnauthorized,
+		"/control?tenant=initech&token=acme-secret&match=game1":          http.StatusNotFound,
+		"/ws?tenant=initech": http.StatusNotFound,
+	} {
+		if got := dialStatus(t, srv, path); got != want {
+			t.Errorf("dialing %s: status %d, want %d", path, got, want)
+		}
+	}
+
+	// Leaving out ?match= names the tenant's own default board.
+	acme := dial(t, srv, "/control?tenant=acme&token=acme-secret")
+	readState(t, acme)
+	send(t, acme, Message{Action: "increment", Team: "A"})
+	readStateWith(t, acme, func(s StateJSON) bool { return s.Version == 1 })
+	if _, ok := rooms.lookup("acme." + defaultMatchID); !ok {
+		t.Errorf("no room acme.%s", defaultMatchID)
+	}
+	if gameState.Version != 0 {
+		t.Errorf("server default match at version %d, want untouched", gameState.Version)
+	}
+}
+
+func TestTenantAdminIsScoped(t *testing.T) {
+	withAdmin(t)
+	withTenants(t)
+	srv := newTestServer(t)
+
+	acme := dial(t, srv, "/control?tenant=acme&token=acme-secret&match=game1")
+	readState(t, acme)
+	send(t, acme, Message{Action: "increment", Team: "A"})
+	readStateWith(t, acme, func(s StateJSON) bool { return s.Version == 1 })
+	globex := dial(t, srv, "/control?tenant=globex&token=globex-secret&match=game1")
+	readState(t, globex)
+	send(t, globex, Message{Action: "increment", Team: "A"})
+	readStateWith(t, globex, func(s StateJSON) bool { return s.Version == 1 })
+	applyAndBroadcast(Message{Action: "increment", Team: "A", Trusted: true})
+
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/admin/matches/game1/clients?tenant=acme", "acme-admin", http.StatusOK},
+		{"/admin/matches/game1/clients?tenant=globex", "acme-admin", http.StatusUnauthorized},
+		{"/admin/matches/game1/clients", "acme-admin", http.StatusUnauthorized},
+		{"/admin/matches/game1/clients?tenant=globex", testAdminToken, http.StatusOK},
+		{"/admin/matches/game1/clients", testAdminToken, http.StatusNotFound},
+	} {
+		if resp, body := get(t, srv, tc.path, bearer(tc.token)); resp.StatusCode != tc.want {
+			t.Errorf("GET %s as %s: %d %s, want %d", tc.path, tc.token, resp.StatusCode, body, tc.want)
+		}
+	}
+
+	// A tenant's reset-all clears its matches only.
+	resp, body := do(t, srv, http.MethodPost, "/admin/reset-all?tenant=acme", bearer("acme-admin"), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reset-all: %d %s", resp.StatusCode, body)
+	}
+	if s := readStateWith(t, acme, func(s StateJSON) bool { return s.Version > 1 }); score(t, s, "A") != 0 {
+		t.Errorf("acme game1 A = %v after reset, want 0", score(t, s, "A"))
+	}
+	if gs, _ := rooms.lookup("globex.game1"); gs.Team("A").Score != 1 {
+		t.Error("acme's reset-all reset globex's game1")
+	}
+	if gameState.Team("A").Score != 1 {
+		t.Error("acme's reset-all reset the server's default match")
+	}
+}
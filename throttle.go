@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrServerBusy is returned when the global action throttle is exhausted.
+var ErrServerBusy = errors.New("server busy, try again shortly")
+
+// tokenBucket is a server-wide throttle on client actions, a coarse safety
+// valve so a burst across many connections can't swamp the broadcast path.
+// It also tracks the accepted action rate for /metrics. Viewers' reactions
+// are limited per client with buckets of their own.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second; 0 disables the throttle
+	burst  float64
+	tokens float64
+	filled time.Time
+
+	// window counts accepted actions in the current second; lastRate is the
+	// count for the previous one.
+	windowStart time.Time
+	window      int
+	lastRate    int
+}
+
+// actionThrottle limits client actions to ACTION_RATE per second across the
+// server, allowing bursts of ACTION_BURST.
+var actionThrottle = newTokenBucket(float64(envInt("ACTION_RATE", 0)), float64(envInt("ACTION_BURST", 50)))
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, filled: time.Now()}
+}
+
+// allow takes a token, reporting false when none is left.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.rate > 0 {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.filled).Seconds()*b.rate)
+		b.filled = now
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+	}
+	b.count(now)
+	b.window++
+	return true
+}
+
+// count advances the rate window. The caller must hold b.mu.
+func (b *tokenBucket) count(now time.Time) {
+	switch elapsed := now.Sub(b.windowStart); {
+	case elapsed >= 2*time.Second:
+		b.lastRate, b.window, b.windowStart = 0, 0, now
+	case elapsed >= time.Second:
+		b.lastRate, b.window, b.windowStart = b.window, 0, now
+	}
+}
+
+// currentRate returns the actions accepted in the last full second.
+func (b *tokenBucket) currentRate() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.count(time.Now())
+	return b.lastRate
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"livescore/engine"
+)
+
+// scheduleStart opens a scheduled match for scoring at its StartsAt time and
+// broadcasts the change. The match must already be marked not started,
+// which main does before serving so no action slips in early.
+func scheduleStart() {
+	gameState.mu.Lock()
+	startsAt, waiting := gameState.StartsAt, gameState.NotStarted
+	gameState.mu.Unlock()
+	if !waiting {
+		return
+	}
+
+	log.Printf("Match starts at %s", startsAt.Format(time.RFC3339))
+	time.AfterFunc(time.Until(startsAt), func() {
+		if err := applyAndBroadcast(Message{Action: "start", Actor: "system", Trusted: true}); err != nil {
+			log.Printf("starting match: %v", err)
+			return
+		}
+		log.Println("Match started at its scheduled time")
+	})
+}
+
+// scheduleEnd finishes the match at its EndsAt time, regardless of period,
+// awarding it by score and broadcasting the result. A match whose end has
+// already passed, for example when the server restarts late, finishes
+// immediately.
+func scheduleEnd() {
+	gameState.mu.Lock()
+	endsAt := gameState.EndsAt
+	gameState.mu.Unlock()
+	if endsAt.IsZero() {
+		return
+	}
+
+	log.Printf("Match ends at %s", endsAt.Format(time.RFC3339))
+	time.AfterFunc(time.Until(endsAt), func() {
+		err := applyAndBroadcast(Message{Action: "finish", Actor: "system", Trusted: true})
+		if err != nil && !errors.Is(err, engine.ErrMatchFinished) {
+			log.Printf("finishing match: %v", err)
+			return
+		}
+		log.Println("Match finished at its scheduled end")
+	})
+}
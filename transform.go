@@ -0,0 +1,69 @@
+package main
+
+import "encoding/json"
+
+// BroadcastTransform reshapes the state sent to clients of a given role. Its
+// result is encoded as JSON in place of the state, once per role for each
+// broadcast; filtered clients get it applied to their filtered view. It may
+// run with gameState.mu held and must not modify gs.
+//
+// To redact data from an audience, assign a transform from an init function
+// in another file of this package:
+//
+//	func init() {
+//		broadcastTransform = func(role string, gs *GameState) any {
+//			if role != RoleViewer {
+//				return gs
+//			}
+//			// Viewers only see names and scores.
+//			return gs.wire(gs.Teams)
+//		}
+//	}
+type BroadcastTransform func(role string, gs *GameState) any
+
+// broadcastTransform is consulted on every state sent to a client. Nil is
+// the identity: every role gets the state as is.
+var broadcastTransform BroadcastTransform
+
+// publicPayload renders the state for the outputs anyone can read without a
+// socket: /score, /next, /diff snapshots and MQTT. That is payload, the
+// state as broadcast, or with a transform the viewers' rendering, so none
+// of them shows what the transform hides from viewers. The caller must
+// hold gs.mu or own gs.
+func (gs *GameState) publicPayload(payload []byte) []byte {
+	if broadcastTransform == nil {
+		return payload
+	}
+	return gs.view(RoleViewer, nil, "")
+}
+
+// view renders the state as a client with the given role, team filter and
+// locale should receive it. A transform's output replaces the state, so it
+// carries no locale strings. The caller must hold gs.mu or own gs.
+func (gs *GameState) view(role string, filter map[string]bool, locale string) []byte {
+	if broadcastTransform == nil {
+		if locale != "" {
+			s := gs.Wire(gs.FilterTeams(filter))
+			s.Formatted = gs.formatted(s.Teams, locale)
+			payload, _ := json.Marshal(s)
+			return payload
+		}
+		if filter != nil {
+			return gs.MarshalFiltered(filter)
+		}
+		payload, _ := json.Marshal(gs)
+		return payload
+	}
+	v := gs
+	if filter != nil {
+		v = gs.clone()
+		v.Teams = v.Teams[:0]
+		for _, t := range gs.Teams {
+			if filter[t.Name] {
+				v.Teams = append(v.Teams, t)
+			}
+		}
+	}
+	payload, _ := json.Marshal(broadcastTransform(role, v))
+	return payload
+}
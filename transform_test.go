@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBroadcastTransformRedactsByRole(t *testing.T) {
+	// Viewers only see names and scores; fouls are for officials.
+	type publicTeam struct {
+		Name  string  `json:"name"`
+		Score float64 `json:"score"`
+	}
+	setVar(t, &broadcastTransform, func(role string, gs *GameState) any {
+		if role != RoleViewer {
+			return gs
+		}
+		teams := make([]publicTeam, 0, len(gs.Teams))
+		for _, tm := range gs.Teams {
+			teams = append(teams, publicTeam{tm.Name, tm.Score})
+		}
+		return map[string]any{"teams": teams, "version": gs.Version}
+	})
+	srv := newTestServer(t)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+	controller := dialControl(t, srv, "")
+	readState(t, controller)
+	send(t, controller, Message{Action: "foul_increment", Team: "A"})
+
+	if s := readStateWith(t, controller, func(s StateJSON) bool { return s.Version == 1 }); s.Teams[0].Fouls != 1 {
+		t.Errorf("controller sees %d fouls, want 1", s.Teams[0].Fouls)
+	}
+	f := readFrame(t, viewer, func(f frame) bool { return f.isState() && f["version"] == 1.0 })
+	for _, tm := range f["teams"].([]any) {
+		if _, ok := tm.(map[string]any)["fouls"]; ok {
+			t.Errorf("viewer frame carries fouls: %v", f)
+		}
+	}
+	if _, ok := f["period"]; ok {
+		t.Errorf("viewer frame carries fields the transform dropped: %v", f)
+	}
+}
+
+func TestScoreEndpointRendersTheViewerTransform(t *testing.T) {
+	setVar(t, &broadcastTransform, func(role string, gs *GameState) any {
+		if role != RoleViewer {
+			return gs
+		}
+		return map[string]any{"version": gs.Version, "teams": len(gs.Teams)}
+	})
+	srv := newTestServer(t)
+
+	controller := dialControl(t, srv, "")
+	readState(t, controller)
+	send(t, controller, Message{Action: "foul_increment", Team: "A"})
+	readStateWith(t, controller, func(s StateJSON) bool { return s.Version == 1 })
+
+	waitFor(t, "/score to reach version 1", func() bool {
+		_, body := get(t, srv, "/score", nil)
+		return strings.Contains(string(body), `"version":1`)
+	})
+	if _, body := get(t, srv, "/score", nil); strings.Contains(string(body), "fouls") {
+		t.Errorf("/score shows what the transform hides from viewers: %s", body)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TCP keepalive on WebSocket connections lets the kernel notice peers that
+// vanished behind a NAT or a dropped link, which otherwise linger as ghost
+// clients until the next write fails. TCP_KEEPALIVE=false turns it off;
+// TCP_KEEPALIVE_PERIOD is the idle time before the first probe and the
+// interval between probes.
+var (
+	tcpKeepAlive       = envBool("TCP_KEEPALIVE", true)
+	tcpKeepAlivePeriod = envDuration("TCP_KEEPALIVE_PERIOD", 30*time.Second)
+)
+
+// WebSocket heartbeats find dead clients faster than TCP keepalive, which
+// backs off for minutes on some stacks: the server pings every client each
+// WS_PING_INTERVAL, and drops one that sends nothing, not even a pong, for
+// WS_PONG_WAIT. WS_WRITE_TIMEOUT bounds every write to a client, so a stuck
+// peer is dropped instead of holding up a broadcast. Zero disables each.
+var (
+	pingInterval = envDuration("WS_PING_INTERVAL", 30*time.Second)
+	pongWait     = envDuration("WS_PONG_WAIT", 60*time.Second)
+	writeTimeout = envDuration("WS_WRITE_TIMEOUT", 10*time.Second)
+)
+
+// WS_WRITE_RETRIES is how many more write timeouts a write that stalls on a
+// congested link gets before its client is dropped; each one is logged.
+// gorilla/websocket fails every write on a connection after the first error,
+// and a write that timed out may have put part of a frame on the wire, so a
+// stalled write is retried by letting it run on, not by sending the frame
+// again. A write that fails outright, like one to a reset connection, drops
+// the client at once.
+var writeRetries = max(envInt("WS_WRITE_RETRIES", 2), 0)
+
+// writeDeadline returns the deadline for a write starting now, or the zero
+// time when writes are unbounded.
+func writeDeadline() time.Time {
+	if writeTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(writeTimeout)
+}
+
+// boundWrite sets the deadline for the next write to the client: the write
+// timeout and its retries from now, or the shutdown handshake's deadline if
+// that comes first. The caller must hold c.writeMu.
+func (c *Client) boundWrite() {
+	var deadline time.Time
+	if writeTimeout > 0 {
+		deadline = time.Now().Add(writeTimeout * time.Duration(1+writeRetries))
+	}
+	if closeBy := c.closeBy.Load(); closeBy != 0 {
+		if d := time.Unix(0, closeBy); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if !deadline.IsZero() {
+		c.conn.SetWriteDeadline(deadline)
+	}
+}
+
+// watchStall logs each write timeout the write starting now stalls past,
+// as one of its retries, and returns a func that stops watching once the
+// write is done.
+func (c *Client) watchStall() (stop func()) {
+	if writeTimeout <= 0 || writeRetries == 0 {
+		return func() {}
+	}
+	timeout, retries := writeTimeout, writeRetries
+	var mu sync.Mutex
+	var done bool
+	var timer *time.Timer
+	attempt := 0
+	var retry func()
+	retry = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		attempt++
+		writeRetryCount.Add(1)
+		log.Printf("write to %s stalled for %v, retrying (%d of %d)", c.ip, time.Duration(attempt)*timeout, attempt, retries)
+		if attempt < retries {
+			timer = time.AfterFunc(timeout, retry)
+		}
+	}
+	mu.Lock()
+	timer = time.AfterFunc(timeout, retry)
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		done = true
+		timer.Stop()
+	}
+}
+
+// extendRead pushes back the read deadline of a client's connection after
+// it was heard from.
+func (c *Client) extendRead() {
+	if pongWait > 0 && !c.closing.Load() {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	}
+}
+
+// heartbeat pings the client until it disconnects, dropping it if a ping
+// can't be sent.
+func (c *Client) heartbeat() {
+	if pingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			// WriteControl is safe alongside the client's other writers.
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, writeDeadline()); err != nil {
+				c.drop("ping failed", err)
+				return
+			}
+		}
+	}
+}
+
+// tuneConn applies the keepalive settings to an upgraded connection.
+func tuneConn(conn *websocket.Conn) {
+	tcp, ok := conn.NetConn().(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tcp.SetKeepAlive(tcpKeepAlive); err != nil {
+		log.Printf("setting TCP keepalive: %v", err)
+		return
+	}
+	if tcpKeepAlive && tcpKeepAlivePeriod > 0 {
+		if err := tcp.SetKeepAlivePeriod(tcpKeepAlivePeriod); err != nil {
+			log.Printf("setting TCP keepalive period: %v", err)
+		}
+	}
+}
+
+// newServer returns the HTTP server with its timeouts from the environment.
+// They bound plain HTTP requests only; upgraded WebSocket connections clear
+// the deadlines and live as long as the client stays.
+//
+//   - HTTP_READ_HEADER_TIMEOUT (5s): reading request headers.
+//   - HTTP_READ_TIMEOUT (10s): reading a whole request, body included.
+//   - HTTP_WRITE_TIMEOUT (60s): writing a response. Keep it above
+//     LONGPOLL_TIMEOUT, or /next polls are cut off.
+//   - HTTP_IDLE_TIMEOUT (120s): keeping an idle keep-alive connection open.
+//
+// Zero disables a timeout.
+func newServer(addr string) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+	}
+	if srv.WriteTimeout > 0 && srv.WriteTimeout <= longPollTimeout {
+		log.Printf("HTTP_WRITE_TIMEOUT=%s is not above LONGPOLL_TIMEOUT=%s; long polls will be cut off", srv.WriteTimeout, longPollTimeout)
+	}
+	return srv
+}
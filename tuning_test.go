@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStalledWriteIsRetriedAndLogged(t *testing.T) {
+	setVar(t, &writeTimeout, 20*time.Millisecond)
+	setVar(t, &writeRetries, 2)
+	logs := captureLog(t)
+	c := &Client{ip: "192.0.2.1"}
+
+	// A write that finishes inside its timeout is not retried.
+	c.watchStall()()
+	time.Sleep(50 * time.Millisecond)
+	if strings.Contains(logs(), "retrying") {
+		t.Fatalf("a finished write was retried: %s", logs())
+	}
+
+	// One that stalls is retried once per timeout, up to WS_WRITE_RETRIES.
+	before := writeRetryCount.Load()
+	stop := c.watchStall()
+	time.Sleep(150 * time.Millisecond)
+	stop()
+	out := logs()
+	for _, want := range []string{"192.0.2.1 stalled", "retrying (1 of 2)", "retrying (2 of 2)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q: %s", want, out)
+		}
+	}
+	if n := strings.Count(out, "retrying"); n != 2 {
+		t.Errorf("logged %d retries, want 2: %s", n, out)
+	}
+	if n := writeRetryCount.Load() - before; n != 2 {
+		t.Errorf("counted %d retries, want 2", n)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// ActionValidator decides whether an action may be applied. It runs with the
+// game state locked, just before applyAction, on every mutation path
+// (WebSocket, feed and REST). Returning an error rejects the action and the
+// error text is sent back to the client.
+//
+// To enforce a custom policy, assign a validator from an init function in
+// another file of this package:
+//
+//	func init() {
+//		actionValidator = func(gs *GameState, msg Message) error {
+//			if msg.Action == "reset" && gs.Period > 1 {
+//				return errors.New("reset is only allowed in the first period")
+//			}
+//			return nil
+//		}
+//	}
+type ActionValidator func(gs *GameState, msg Message) error
+
+// allowAll is the default validator; it accepts every action.
+func allowAll(*GameState, Message) error { return nil }
+
+// actionValidator is the validator consulted before each action.
+var actionValidator ActionValidator = allowAll
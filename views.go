@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// BroadcastView is a named channel onto the match that clients select with
+// ?view=name. Every view follows the one underlying state, but sends each
+// change Delay after it happened and reshaped by Transform, whose result is
+// encoded as JSON in place of the state as with BroadcastTransform. A nil
+// Transform sends the state as the default channel would. The clock a
+// delayed view shows is wound back by the delay, so it agrees with the
+// score.
+//
+// To offer a delayed, redacted public feed beside the full one, register
+// views from an init function in another file of this package:
+//
+//	func init() {
+//		broadcastViews["public"] = BroadcastView{
+//			Delay: 30 * time.Second,
+//			Transform: func(gs *GameState) any {
+//				return gs.wire(gs.Teams)
+//			},
+//		}
+//		broadcastViews["official"] = BroadcastView{}
+//	}
+//
+// Clients that name no view get the live state, held back by
+// broadcastDelay if one is set. Messages other than the state, such as
+// notices and the roster, go to every client at once.
+type BroadcastView struct {
+	Transform func(gs *GameState) any
+	Delay     time.Duration
+}
+
+// broadcastViews holds the views clients may select, by name.
+var broadcastViews = map[string]BroadcastView{}
+
+// broadcastDelay holds states back from clients that select no view, for
+// competitive integrity in the venue or to line the board up with a TV
+// feed: actions apply at once, but those clients see each change this long
+// after it happened, and connect to the state as it was then. Controllers
+// keep the live state unless delayControllers is set. /score, /next and
+// MQTT follow the delayed state too.
+var (
+	broadcastDelay   = envDuration("BROADCAST_DELAY", 0)
+	delayControllers = envBool("BROADCAST_DELAY_CONTROLLERS", false)
+)
+
+// viewQueueSize bounds the states a view holds back. A view whose delay
+// spans more changes than this drops the excess.
+const viewQueueSize = 4096
+
+// viewChannel delivers a view's states to the clients that selected it.
+type viewChannel struct {
+	BroadcastView
+	name  string
+	queue chan pendingState
+
+	mu   sync.Mutex
+	last *GameState // the state the view currently shows
+}
+
+// pendingState is a state waiting out its view's delay.
+type pendingState struct {
+	state     *GameState
+	committed time.Time
+	due       time.Time
+}
+
+// viewChannels holds a channel per registered view, and the broadcast
+// delay's under the name "", which ?view= can't select. It is filled by
+// startViews before the server takes traffic and only read afterwards.
+var viewChannels map[string]*viewChannel
+
+// startViews starts delivering every registered view, each showing the
+// current state until the first change reaches it. Delivery stops when ctx
+// is cancelled.
+func startViews(ctx context.Context) {
+	views := maps.Clone(broadcastViews)
+	if broadcastDelay > 0 {
+		views[""] = BroadcastView{Delay: broadcastDelay}
+	}
+	viewChannels = make(map[string]*viewChannel, len(views))
+	gameState.mu.Lock()
+	defer gameState.mu.Unlock()
+	for name, v := range views {
+		ch := &viewChannel{BroadcastView: v, name: name, queue: make(chan pendingState, viewQueueSize)}
+		ch.last = ch.delayed(gameState.clone())
+		viewChannels[name] = ch
+		go ch.run(ctx)
+		log.Printf("Broadcast view %q delayed by %s", name, v.Delay)
+	}
+}
+
+// defaultView returns the channel for a client with the given role that
+// selected no view: the broadcast delay's, or nil for the live state.
+func defaultView(role string) *viewChannel {
+	if role != RoleViewer && !delayControllers {
+		return nil
+	}
+	return viewChannels[""]
+}
+
+// enqueue schedules state for the view. It never blocks, as it is called
+// from the broadcast fanout.
+func (ch *viewChannel) enqueue(state *GameState, committed time.Time) {
+	select {
+	case ch.queue <- pendingState{state: state, committed: committed, due: time.Now().Add(ch.Delay)}:
+	default:
+		log.Printf("view %q: queue full, dropping version %d", ch.name, state.Version)
+	}
+}
+
+// run delivers queued states as they come due.
+func (ch *viewChannel) run(ctx context.Context) {
+	for {
+		var p pendingState
+		select {
+		case <-ctx.Done():
+			return
+		case p = <-ch.queue:
+		}
+		if wait := time.Until(p.due); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		ch.deliver(ch.delayed(p.state), p.committed)
+	}
+}
+
+// delayed returns a copy of state whose clock reads as it did Delay ago,
+// or state itself for an undelayed view.
+func (ch *viewChannel) delayed(state *GameState) *GameState {
+	if ch.Delay == 0 || !state.Clock.Running() {
+		return state
+	}
+	v := state.clone()
+	v.Clock.StartedAt = v.Clock.StartedAt.Add(ch.Delay)
+	return v
+}
+
+// latest returns the state the view currently shows.
+func (ch *viewChannel) latest() *GameState {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.last
+}
+
+// deliver sends state to the view's live clients. The broadcast delay's
+// channel also publishes it to /score pollers and MQTT.
+func (ch *viewChannel) deliver(state *GameState, committed time.Time) {
+	ch.mu.Lock()
+	ch.last = state
+	ch.mu.Unlock()
+	if ch.name == "" {
+		if payload := state.broadcastPayload(); payload != nil {
+			payload = state.publicPayload(payload)
+			publicScore.store(payload, state.Version)
+			mqtt.publishState(defaultMatchID, payload)
+		}
+	}
+
+	type target struct {
+		client *Client
+		filter map[string]bool
+		locale string
+	}
+	hub.mutex.Lock()
+	var targets []target
+	for client := range hub.clients {
+		if client.view == ch && !client.muted {
+			targets = append(targets, target{client, client.filter, client.locale})
+		}
+	}
+	hub.mutex.Unlock()
+
+	// Clients without a filter or locale share one encoding per frame
+	// type, made on first use.
+	shared := make(map[int][]byte)
+	var failed []*Client
+	for _, t := range targets {
+		client := t.client
+		var messageType int
+		var payload []byte
+		if t.filter == nil && t.locale == "" {
+			kind := client.frameType()
+			if kind == websocket.BinaryMessage && !state.fitsBinary(nil) {
+				kind = websocket.TextMessage
+			}
+			if payload = shared[kind]; payload == nil {
+				_, payload = state.encodeFor(client, nil, "")
+				shared[kind] = payload
+			}
+			messageType = kind
+		} else {
+			messageType, payload = state.encodeFor(client, t.filter, t.locale)
+		}
+		frame := outFrame{messageType: messageType, payload: payload}
+		if !committed.IsZero() {
+			// The latency stats leave out the delay itself.
+			frame.committed = committed.Add(ch.Delay)
+		}
+		if err := client.enqueue(frame); err != nil {
+			client.drop("broadcast error", err)
+			failed = append(failed, client)
+		}
+	}
+	if len(failed) > 0 {
+		hub.mutex.Lock()
+		for _, client := range failed {
+			delete(hub.clients, client)
+		}
+		hub.mutex.Unlock()
+	}
+}
+
+// transform renders a state through the view's Transform, limited to the
+// teams in filter when it is non-nil.
+func (ch *viewChannel) transform(gs *GameState, filter map[string]bool) []byte {
+	if filter != nil {
+		gs = gs.clone()
+		gs.Teams = gs.FilterTeams(filter)
+	}
+	payload, _ := json.Marshal(ch.Transform(gs))
+	return payload
+}
+
+// publishState hands state, a new state of the match gs broadcast as
+// payload, to /score pollers and, for the default match, MQTT, as
+// publicPayload renders it. Under a broadcast delay the default match is
+// left to the delay's channel, which publishes each state as it comes due.
+func publishState(gs, state *GameState, payload []byte) {
+	payload, version := state.publicPayload(payload), state.Version
+	if gs != &gameState {
+		gs.public().store(payload, version)
+		return
+	}
+	if viewChannels[""] != nil {
+		return
+	}
+	publicScore.store(payload, version)
+	mqtt.publishState(defaultMatchID, payload)
+}
+
+// source returns the state client c currently sees: live, or the latest
+// its view has delivered.
+func (c *Client) source(live *GameState) *GameState {
+	if c.view == nil {
+		return live
+	}
+	return c.view.latest()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestViewsOfOneMatchDiffer(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	type publicTeam struct {
+		Name  string  `json:"name"`
+		Score float64 `json:"score"`
+	}
+	setVar(t, &broadcastViews, map[string]BroadcastView{
+		// The public view is late and shows no fouls.
+		"public": {Delay: delay, Transform: func(gs *GameState) any {
+			teams := make([]publicTeam, 0, len(gs.Teams))
+			for _, tm := range gs.Teams {
+				teams = append(teams, publicTeam{tm.Name, tm.Score})
+			}
+			return map[string]any{"teams": teams, "version": gs.Version}
+		}},
+		"official": {},
+	})
+	srv := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	startViews(ctx)
+
+	public := dial(t, srv, "/ws?view=public")
+	readState(t, public)
+	official := dial(t, srv, "/ws?view=official")
+	readState(t, official)
+	if status := dialStatus(t, srv, "/ws?view=secret"); status != http.StatusBadRequest {
+		t.Errorf("unknown view: status %d, want 400", status)
+	}
+
+	console := dialControl(t, srv, "")
+	readState(t, console)
+	sent := time.Now()
+	send(t, console, Message{Action: "foul_increment", Team: "A"})
+
+	if s := readState(t, official); s.Version != 1 || s.Teams[0].Fouls != 1 {
+		t.Errorf("official view: version %d with %d fouls, want version 1 with 1", s.Version, s.Teams[0].Fouls)
+	}
+	if late := time.Since(sent); late >= delay {
+		t.Errorf("official view took %s, want it undelayed", late)
+	}
+	f := readFrame(t, public, func(f frame) bool { return f.isState() && f["version"] == 1.0 })
+	if late := time.Since(sent); late < delay {
+		t.Errorf("public view arrived after %s, want at least %s", late, delay)
+	}
+	for _, tm := range f["teams"].([]any) {
+		if _, ok := tm.(map[string]any)["fouls"]; ok {
+			t.Errorf("public view carries fouls: %v", f)
+		}
+	}
+}
+
+func TestBroadcastDelayHoldsBackViewers(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	setVar(t, &broadcastDelay, delay)
+	setVar(t, &delayControllers, false)
+	srv := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	startViews(ctx)
+
+	viewer := dial(t, srv, "/ws")
+	readState(t, viewer)
+	console := dialControl(t, srv, "")
+	readState(t, console)
+	sent := time.Now()
+	send(t, console, Message{Action: "increment", Team: "A"})
+
+	// The controller sees the change at once.
+	readStateWith(t, console, func(s StateJSON) bool { return s.Version == 1 })
+	if late := time.Since(sent); late >= delay {
+		t.Errorf("controller got the change after %s, want it undelayed", late)
+	}
+	// A viewer joining meanwhile connects to the state as it was.
+	if s := readState(t, dial(t, srv, "/ws")); s.Version != 0 {
+		t.Errorf("viewer joining during the delay got version %d, want 0", s.Version)
+	}
+	// Viewers get it about the delay later.
+	readStateWith(t, viewer, func(s StateJSON) bool { return s.Version == 1 })
+	if late := time.Since(sent); late < delay || late > 2*delay {
+		t.Errorf("viewer got the change after %s, want about %s", late, delay)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ScoreEvent describes one applied action and the state it produced.
+type ScoreEvent struct {
+	Version uint64  `json:"version"`
+	Action  string  `json:"action"`
+	Team    string  `json:"team,omitempty"`
+	Value   float64 `json:"value,omitempty"`
+	// Points is what an "increment" or "decrement" was worth, its Step
+	// when the action named no points.
+	Points float64   `json:"points,omitempty"`
+	Teams  []Team    `json:"teams"`
+	At     time.Time `json:"at"`
+}
+
+// newScoreEvent builds the event for msg just applied to gs. The caller must
+// hold gs.mu.
+func newScoreEvent(gs *GameState, msg Message) ScoreEvent {
+	ev := ScoreEvent{
+		Version: gs.Version,
+		Action:  msg.Action,
+		Team:    msg.Team,
+		Value:   msg.Value,
+		Teams:   append([]Team(nil), gs.Teams...),
+		At:      time.Now().UTC(),
+	}
+	if msg.Action == "increment" || msg.Action == "decrement" {
+		// Already validated when the action was applied.
+		ev.Points, _ = gs.Options.ValidPoints(msg.Worth())
+	}
+	return ev
+}
+
+// webhookAttempts is how many times an event is POSTed before it is dropped.
+const webhookAttempts = 4
+
+// webhookSender POSTs score events to a URL from a bounded queue, so a slow
+// receiver never holds up broadcasting.
+type webhookSender struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan ScoreEvent
+}
+
+// webhook is the active sender, or nil when WEBHOOK_URL is unset.
+var webhook = webhookFromEnv()
+
+// webhookFromEnv configures a sender from WEBHOOK_URL, WEBHOOK_SECRET and
+// WEBHOOK_QUEUE.
+func webhookFromEnv() *webhookSender {
+	url := envString("WEBHOOK_URL", "")
+	if url == "" {
+		return nil
+	}
+	return newWebhookSender(url, envString("WEBHOOK_SECRET", ""), envInt("WEBHOOK_QUEUE", 256))
+}
+
+func newWebhookSender(url, secret string, queueSize int) *webhookSender {
+	return &webhookSender{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan ScoreEvent, queueSize),
+	}
+}
+
+// Emit schedules an event for delivery without blocking, implementing
+// EventSink. Events are dropped with a warning when the queue is full. Safe
+// on a nil sender.
+func (s *webhookSender) Emit(ev ScoreEvent) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		log.Printf("webhook queue full, dropping event version %d", ev.Version)
+	}
+}
+
+// run delivers queued events until ctx is cancelled.
+func (s *webhookSender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-s.queue:
+			s.deliver(ctx, ev)
+		}
+	}
+}
+
+// deliver POSTs one event, retrying with exponential backoff.
+func (s *webhookSender) deliver(ctx context.Context, ev ScoreEvent) {
+	body, _ := json.Marshal(ev)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Printf("webhook delivery of version %d failed after %d attempts: %v", ev.Version, attempt, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends body once. The X-Livescore-Signature header carries
+// "sha256=" and the hex HMAC-SHA256 of the body under the shared secret.
+func (s *webhookSender) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set("X-Livescore-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}